@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// accessLogWriter records the response status and the resolved target of a request for the access log
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	target string
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// setAccessTarget records the target a mutating request resolved to in the access log entry, if access logging is
+// enabled
+func setAccessTarget(w http.ResponseWriter, target string) {
+	if aw, ok := w.(*accessLogWriter); ok {
+		aw.target = target
+	}
+}
+
+// accessLog wraps an API handler to log each request at info level. /metrics is excluded to keep scrapes out of the
+// log.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		aw := &accessLogWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(aw, r)
+		fields := log.Fields{
+			"method": r.Method,
+			"path":   r.URL.Path,
+			"remote": r.RemoteAddr,
+			"status": aw.status,
+		}
+		if aw.target != "" {
+			fields["target"] = aw.target
+		}
+		log.WithFields(fields).Info("API request")
+	})
+}
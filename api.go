@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// newAPIMux creates the API request router
+func newAPIMux(config *Config, d *Director) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/reroute", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+			return
+		}
+		if config.MonitorOnly {
+			writeAPIError(w, http.StatusForbidden, errMonitorOnly)
+			return
+		}
+		to := r.URL.Query().Get("to")
+		if id := r.URL.Query().Get("id"); id != "" {
+			if to != "" {
+				writeAPIError(w, http.StatusBadRequest, fmt.Errorf("to and id are mutually exclusive"))
+				return
+			}
+			name, err := d.NodeName(id)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, err)
+				return
+			}
+			to = name
+		}
+		result, err := d.Reroute(to, r.URL.Query().Get("force") == "true")
+		setAccessTarget(w, result.Target)
+		resp := apiResponse{Status: "ok", Target: result.Target, Reason: result.Reason, Preflight: result.Preflight}
+		if err != nil {
+			resp.Status, resp.Error = "error", err.Error()
+			writeJSON(w, apiErrorStatus(err), resp)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+
+	mux.HandleFunc("/reroute/preview", func(w http.ResponseWriter, r *http.Request) {
+		to := r.URL.Query().Get("to")
+		if id := r.URL.Query().Get("id"); id != "" {
+			if to != "" {
+				writeAPIError(w, http.StatusBadRequest, fmt.Errorf("to and id are mutually exclusive"))
+				return
+			}
+			name, err := d.NodeName(id)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, err)
+				return
+			}
+			to = name
+		}
+		preview, err := d.PreviewReroute(to)
+		if err != nil {
+			writeAPIError(w, apiErrorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, preview)
+	})
+
+	mux.HandleFunc("/noreroute", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+			return
+		}
+		if config.MonitorOnly {
+			writeAPIError(w, http.StatusForbidden, errMonitorOnly)
+			return
+		}
+		if err := d.NoReroute(); err != nil {
+			writeAPIError(w, apiErrorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, apiResponse{Status: "ok"})
+	})
+
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		if config.MonitorOnly {
+			writeAPIError(w, http.StatusForbidden, errMonitorOnly)
+			return
+		}
+		if config.PanicTarget == "" || config.PanicToken == "" {
+			writeAPIError(w, http.StatusNotFound, fmt.Errorf("panic mode is not configured"))
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+			return
+		}
+		if !panicAuthorized(config, r) {
+			writeAPIError(w, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+			return
+		}
+		setAccessTarget(w, config.PanicTarget)
+		resp := apiResponse{Status: "ok", Target: config.PanicTarget, Reason: "panic"}
+		if err := panicReroute(config); err != nil {
+			resp.Status, resp.Error = "error", fmt.Sprintf("rerouting to panic target %s: %s", config.PanicTarget, err)
+			writeJSON(w, apiErrorStatus(err), resp)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+
+	mux.HandleFunc("/unpanic", func(w http.ResponseWriter, r *http.Request) {
+		if config.MonitorOnly {
+			writeAPIError(w, http.StatusForbidden, errMonitorOnly)
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+			return
+		}
+		if !panicAuthorized(config, r) {
+			writeAPIError(w, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+			return
+		}
+		if err := unpanic(config); err != nil {
+			writeAPIError(w, apiErrorStatus(err), fmt.Errorf("leaving panic mode: %s", err))
+			return
+		}
+		writeJSON(w, http.StatusOK, apiResponse{Status: "ok"})
+	})
+
+	mux.HandleFunc("/candidates", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("explain") == "true" {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(d.ExplainCandidates()); err != nil {
+				log.Warnf("Error encoding candidates: %s", err)
+			}
+			return
+		}
+		candidates := []candidateResponse{}
+		for _, c := range d.Candidates() {
+			candidates = append(candidates, candidateResponse{
+				Name:    c.Name,
+				ID:      c.ID,
+				IP:      c.IP,
+				Tags:    c.Tags,
+				Latency: c.Latency,
+				Jitter:  c.Jitter,
+				Loss:    c.Loss,
+			})
+		}
+		writeJSON(w, http.StatusOK, candidates)
+	})
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		status := d.Status()
+		if r.URL.Query().Get("detail") == "true" {
+			status.Percentiles = windowPercentiles()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			log.Warnf("Error encoding status: %s", err)
+		}
+	})
+
+	mux.HandleFunc("/fabric/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(fabricHealth(config)); err != nil {
+			log.Warnf("Error encoding fabric health: %s", err)
+		}
+	})
+
+	mux.HandleFunc("/simulate", func(w http.ResponseWriter, r *http.Request) {
+		if !config.AllowSimulation {
+			http.Error(w, "Simulation is disabled", http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		name := r.URL.Query().Get("node")
+		if _, ok := config.Nodes[name]; !ok {
+			http.Error(w, fmt.Sprintf("Unknown node %s", name), http.StatusBadRequest)
+			return
+		}
+		switch state := r.URL.Query().Get("state"); state {
+		case "down":
+			setSimulatedDown(name, true)
+		case "clear":
+			setSimulatedDown(name, false)
+		default:
+			http.Error(w, fmt.Sprintf("Invalid state %s (must be down or clear)", state), http.StatusBadRequest)
+			return
+		}
+		setAccessTarget(w, name)
+		events.Add("simulate", name, r.URL.Query().Get("state"))
+		log.Warnf("Simulation for %s set to %s", name, r.URL.Query().Get("state"))
+		_, _ = fmt.Fprintf(w, "Simulation for %s set to %s\n", name, r.URL.Query().Get("state"))
+	})
+
+	mux.HandleFunc("/loglevel", func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuthorized(config, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			name := r.URL.Query().Get("level")
+			if name == "" {
+				http.Error(w, "Missing level parameter", http.StatusBadRequest)
+				return
+			}
+			level, err := log.ParseLevel(name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.SetLevel(level)
+			log.Infof("Log level set to %s via API", level)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "%s\n", log.GetLevel())
+	})
+
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// Served without the config read lock, since the reload needs the write lock
+		configLock.RLock()
+		authorized := adminAuthorized(config, r)
+		configLock.RUnlock()
+		if !authorized {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err := requestReload(r.Context()); err != nil {
+			code := http.StatusBadRequest
+			if errors.Is(err, errShuttingDown) {
+				code = http.StatusServiceUnavailable
+			}
+			http.Error(w, fmt.Sprintf("Error reloading configuration: %s", err), code)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "Configuration reloaded\n")
+	})
+
+	mux.HandleFunc("/matrix", func(w http.ResponseWriter, r *http.Request) {
+		if config.MatrixToken != "" && !bearerAuthorized(r, config.MatrixToken) && tokenScope(config, r.Header.Get("Authorization")) == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(localMatrixView()); err != nil {
+			log.Warnf("Error encoding matrix: %s", err)
+		}
+	})
+
+	mux.HandleFunc("/partitions", func(w http.ResponseWriter, r *http.Request) {
+		if !config.PartitionDetection {
+			http.Error(w, "Partition detection is disabled", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(currentPartitions()); err != nil {
+			log.Warnf("Error encoding partitions: %s", err)
+		}
+	})
+
+	mux.HandleFunc("/samples", func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuthorized(config, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		set, ok := nodeSamples(r.URL.Query().Get("node"))
+		if !ok {
+			http.Error(w, fmt.Sprintf("No samples for node %s", r.URL.Query().Get("node")), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(set); err != nil {
+			log.Warnf("Error encoding samples: %s", err)
+		}
+	})
+
+	mux.HandleFunc("/routes", func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuthorized(config, r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		routes, err := installedRoutes(config)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(routes); err != nil {
+			log.Warnf("Error encoding routes: %s", err)
+		}
+	})
+
+	// Mirrored state from a primary director when this director is its standby
+	mux.HandleFunc("/standby", func(w http.ResponseWriter, r *http.Request) {
+		if !bearerAuthorized(r, config.StandbyToken) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodPost:
+			var update standbyUpdate
+			if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+				http.Error(w, fmt.Sprintf("invalid update: %s", err), http.StatusBadRequest)
+				return
+			}
+			receiveMirror(update)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(currentMirror()); err != nil {
+				log.Warnf("Error encoding mirrored state: %s", err)
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(events.Snapshot()); err != nil {
+			log.Warnf("Error encoding events: %s", err)
+		}
+	})
+
+	// Serve OpenMetrics to scrapers that negotiate it so exemplars are exposed, and the classic format otherwise
+	var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+	if config.MetricNodeLabel != "" {
+		gatherer = nodeLabelGatherer{Gatherer: gatherer, label: config.MetricNodeLabel}
+	}
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+	))
+
+	return mux
+}
+
+// apiResponse is the JSON body of the mutating endpoints. Status is ok or error, and error is set on failure.
+type apiResponse struct {
+	Status    string `json:"status"`
+	Target    string `json:"target,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Preflight string `json:"preflight,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// candidateResponse is a candidate node as listed by /candidates
+type candidateResponse struct {
+	Name    string            `json:"name"`
+	ID      uint8             `json:"id"`
+	IP      string            `json:"ip"`
+	Tags    map[string]string `json:"tags,omitempty"`
+	Latency time.Duration     `json:"latency"`
+	Jitter  time.Duration     `json:"jitter"`
+	Loss    float64           `json:"loss"`
+}
+
+// writeJSON writes a JSON response body with a status code
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warnf("Error encoding API response: %s", err)
+	}
+}
+
+// writeAPIError writes an apiResponse carrying an error
+func writeAPIError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, apiResponse{Status: "error", Error: err.Error()})
+}
+
+// apiErrorStatus returns the HTTP status code of an error from a Director operation: a failed route change is a server
+// error, an unknown node a bad request, and anything else a request refused in the director's current state
+func apiErrorStatus(err error) int {
+	var routeErr routeChangeError
+	switch {
+	case errors.Is(err, errMonitorOnly):
+		return http.StatusForbidden
+	case errors.Is(err, errUnknownNode):
+		return http.StatusBadRequest
+	case errors.As(err, &routeErr):
+		return http.StatusInternalServerError
+	}
+	return http.StatusConflict
+}
+
+// adminAuthorized returns true if a request carries the configured admin bearer token. Admin endpoints are disabled
+// when no admin token is configured.
+func adminAuthorized(config *Config, r *http.Request) bool {
+	return bearerAuthorized(r, config.AdminToken)
+}
+
+// bearerAuthorized returns true if a request carries the given bearer token, and always false if token is empty
+func bearerAuthorized(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// bindListeners opens a listener for each configured API and gRPC address not yet in listeners, so a port conflict is
+// reported before the kernel is touched. Addresses on the overlay can't be bound until the tunnels are up, so they are
+// skipped when early is set. On error all listeners are closed.
+func bindListeners(config *Config, listeners map[string]net.Listener, early bool) error {
+	overlay := map[string]bool{}
+	for _, addr := range overlayListenAddrs(config) {
+		overlay[addr] = true
+	}
+	for _, addr := range append(append([]string{}, config.Listen...), config.GRPCListen) {
+		if addr == "" || listeners[addr] != nil || early && overlay[addr] {
+			continue
+		}
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return fmt.Errorf("can't listen on %s: %s", addr, err)
+		}
+		listeners[addr] = listener
+	}
+	return nil
+}
+
+// startAPIServers starts an HTTP server on the listener of each configured listen address, with timeouts so stuck or
+// slow clients can't hold connections open indefinitely. The servers use HTTPS if tlsConfig is set.
+func startAPIServers(config *Config, handler http.Handler, listeners map[string]net.Listener, tlsConfig *tls.Config) []*http.Server {
+	var servers []*http.Server
+	for _, addr := range config.Listen {
+		server := &http.Server{
+			Addr:              addr,
+			Handler:           handler,
+			ReadHeaderTimeout: config.APIReadTimeout,
+			ReadTimeout:       config.APIReadTimeout,
+			WriteTimeout:      config.APIWriteTimeout,
+			IdleTimeout:       config.APIIdleTimeout,
+			MaxHeaderBytes:    config.APIMaxHeaderBytes,
+			TLSConfig:         tlsConfig,
+		}
+		servers = append(servers, server)
+		listener := listeners[addr]
+		go func() {
+			var err error
+			if server.TLSConfig != nil {
+				log.Infof("Starting API on %s with TLS", server.Addr)
+				err = server.ServeTLS(listener, "", "")
+			} else {
+				log.Infof("Starting API on %s", server.Addr)
+				err = server.Serve(listener)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+	return servers
+}
+
+// shutdownAPIServers gracefully stops all API servers, waiting up to timeout for in-flight requests
+func shutdownAPIServers(servers []*http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			log.Warnf("Error shutting down API on %s: %s", server.Addr, err)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestPanicEndpointErrors(t *testing.T) {
+	const panicConfig = "panic-target: fmt2\npanic-token: s3cret\n"
+	for _, tt := range []struct {
+		name     string
+		extra    string
+		method   string
+		path     string
+		token    string
+		wantCode int
+	}{
+		{"panic not configured", "", http.MethodPost, "/panic", "s3cret", http.StatusNotFound},
+		{"panic monitor-only", panicConfig + "monitor-only: true\n", http.MethodPost, "/panic", "s3cret", http.StatusForbidden},
+		{"panic GET", panicConfig, http.MethodGet, "/panic", "s3cret", http.StatusMethodNotAllowed},
+		{"panic without token", panicConfig, http.MethodPost, "/panic", "", http.StatusUnauthorized},
+		{"panic wrong token", panicConfig, http.MethodPost, "/panic", "wrong", http.StatusUnauthorized},
+		{"unpanic GET", panicConfig, http.MethodGet, "/unpanic", "s3cret", http.StatusMethodNotAllowed},
+		{"unpanic wrong token", panicConfig, http.MethodPost, "/unpanic", "wrong", http.StatusUnauthorized},
+		{"unpanic outside panic mode", panicConfig, http.MethodPost, "/unpanic", "s3cret", http.StatusConflict},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, tt.extra)
+			mux := newAPIMux(config, &Director{config: config})
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantCode)
+			}
+			var resp apiResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("decoding response: %s", err)
+			}
+			if resp.Status != "error" || resp.Error == "" {
+				t.Errorf("response %+v, want an error", resp)
+			}
+		})
+	}
+}
+
+func TestSimulateEndpoint(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		extra    string
+		method   string
+		query    string
+		down     []string // Nodes simulated down before the request
+		wantCode int
+		wantDown []string
+	}{
+		{"disabled", "", http.MethodPost, "node=fmt2&state=down", nil, http.StatusForbidden, nil},
+		{"GET", "allow-simulation: true\n", http.MethodGet, "node=fmt2&state=down", nil, http.StatusMethodNotAllowed, nil},
+		{"unknown node", "allow-simulation: true\n", http.MethodPost, "node=lax9&state=down", nil, http.StatusBadRequest, nil},
+		{"invalid state", "allow-simulation: true\n", http.MethodPost, "node=fmt2&state=up", nil, http.StatusBadRequest, nil},
+		{"down", "allow-simulation: true\n", http.MethodPost, "node=fmt2&state=down", nil, http.StatusOK, []string{"fmt2"}},
+		{"clear", "allow-simulation: true\n", http.MethodPost, "node=fmt2&state=clear", []string{"fmt2"}, http.StatusOK, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, tt.extra)
+			mux := newAPIMux(config, &Director{config: config})
+			for _, name := range tt.down {
+				setSimulatedDown(name, true)
+			}
+
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tt.method, "/simulate?"+tt.query, nil))
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got := simulatedDownNodes(); !reflect.DeepEqual(got, tt.wantDown) {
+				t.Errorf("simulated down %v, want %v", got, tt.wantDown)
+			}
+		})
+	}
+}
+
+func TestRerouteByIDErrors(t *testing.T) {
+	for _, tt := range []struct {
+		query    string
+		wantCode int
+	}{
+		{"id=40", http.StatusBadRequest},
+		{"id=fmt2", http.StatusBadRequest},
+		{"id=20&to=fmt2", http.StatusBadRequest},
+	} {
+		t.Run(tt.query, func(t *testing.T) {
+			config := testConfig(t, "")
+			mux := newAPIMux(config, &Director{config: config})
+
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reroute?"+tt.query, nil))
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantCode)
+			}
+			if reroute.Active {
+				t.Error("rerouted despite the error")
+			}
+		})
+	}
+}
+
+func TestReroutePreviewErrors(t *testing.T) {
+	for _, tt := range []struct {
+		query    string
+		wantCode int
+	}{
+		{"id=20&to=fmt2", http.StatusBadRequest},
+		{"id=40", http.StatusBadRequest},
+		{"to=lax9", http.StatusBadRequest},
+		{"", http.StatusConflict}, // No candidates
+	} {
+		t.Run(tt.query, func(t *testing.T) {
+			config := testConfig(t, "")
+			mux := newAPIMux(config, &Director{config: config})
+
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/reroute/preview?"+tt.query, nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantCode)
+			}
+			var resp apiResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.Status != "error" || resp.Error == "" {
+				t.Errorf("response %+v (%v), want a JSON error", resp, err)
+			}
+
+			// The preview fails like the reroute it previews
+			rec = httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reroute?"+tt.query, nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("reroute status %d, want %d like the preview", rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestMonitorOnlyEndpoints(t *testing.T) {
+	config := testConfig(t, "monitor-only: true\n")
+	mux := newAPIMux(config, &Director{config: config})
+	for _, path := range []string{"/reroute?to=fmt2", "/reroute", "/noreroute"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s status %d, want %d", path, rec.Code, http.StatusForbidden)
+		}
+	}
+	if reroute.Active {
+		t.Error("rerouted in monitor-only mode")
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var status statusResponse
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("decoding status: %s", err)
+	}
+	if !status.MonitorOnly {
+		t.Error("status doesn't report monitor-only mode")
+	}
+}
+
+func TestStatusDetail(t *testing.T) {
+	config := testConfig(t, "")
+	mux := newAPIMux(config, &Director{config: config})
+	recordSamples(config, "fmt2", measurement{probeResult: probeResult{Samples: []time.Duration{20 * time.Millisecond}}}, time.Now())
+
+	for _, tt := range []struct {
+		query string
+		want  bool
+	}{
+		{"", false},
+		{"?detail=true", true},
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status"+tt.query, nil))
+		var status statusResponse
+		if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+			t.Fatalf("decoding status: %s", err)
+		}
+		if _, ok := status.Percentiles["fmt2"]; ok != tt.want {
+			t.Errorf("/status%s reports percentiles %t, want %t", tt.query, ok, tt.want)
+		}
+	}
+}
+
+func TestLogLevelEndpoint(t *testing.T) {
+	config := testConfig(t, "admin-token: s3cret\n")
+	mux := newAPIMux(config, &Director{config: config})
+	t.Cleanup(func() { log.SetLevel(log.FatalLevel) })
+	for _, tt := range []struct {
+		name      string
+		method    string
+		query     string
+		token     string
+		wantCode  int
+		wantBody  string
+		wantLevel log.Level
+	}{
+		{"GET without token", http.MethodGet, "", "", http.StatusUnauthorized, "Unauthorized", log.FatalLevel},
+		{"PUT without token", http.MethodPut, "?level=debug", "", http.StatusUnauthorized, "Unauthorized", log.FatalLevel},
+		{"PUT wrong token", http.MethodPut, "?level=debug", "wrong", http.StatusUnauthorized, "Unauthorized", log.FatalLevel},
+		{"GET", http.MethodGet, "", "s3cret", http.StatusOK, "fatal", log.FatalLevel},
+		{"PUT without level", http.MethodPut, "", "s3cret", http.StatusBadRequest, "Missing level parameter", log.FatalLevel},
+		{"PUT invalid level", http.MethodPut, "?level=verbose", "s3cret", http.StatusBadRequest, "not a valid logrus Level", log.FatalLevel},
+		{"PUT", http.MethodPut, "?level=debug", "s3cret", http.StatusOK, "debug", log.DebugLevel},
+		{"POST", http.MethodPost, "?level=debug", "s3cret", http.StatusMethodNotAllowed, "Method not allowed", log.FatalLevel},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			log.SetLevel(log.FatalLevel)
+			req := httptest.NewRequest(tt.method, "/loglevel"+tt.query, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode || !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("status %d response %q, want %d %q", rec.Code, rec.Body.String(), tt.wantCode, tt.wantBody)
+			}
+			if got := log.GetLevel(); got != tt.wantLevel {
+				t.Errorf("log level %s, want %s", got, tt.wantLevel)
+			}
+		})
+	}
+}
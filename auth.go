@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+)
+
+// apiToken is a bearer token accepted by the API and the scope it grants, read or write
+type apiToken struct {
+	Token string `yaml:"token"`
+	Scope string `yaml:"scope"`
+}
+
+// authExempt is the endpoints that don't take an API token because they are scraped
+var authExempt = map[string]bool{
+	"/metrics": true,
+}
+
+// peerSecret returns the token peers and operators use for an endpoint instead of an API token, or an empty string if
+// the endpoint only takes API tokens
+func peerSecret(config *Config, path string) string {
+	switch path {
+	case "/matrix":
+		return config.MatrixToken
+	case "/panic", "/unpanic":
+		return config.PanicToken
+	case "/standby":
+		return config.StandbyToken
+	}
+	return ""
+}
+
+// loadAPITokens adds the tokens listed in api-tokens-file to the configured ones and validates them all
+func loadAPITokens(config *Config) error {
+	if config.APITokensFile != "" {
+		b, err := os.ReadFile(config.APITokensFile)
+		if err != nil {
+			return fmt.Errorf("error reading api-tokens-file: %s", err)
+		}
+		var tokens []apiToken
+		if err := yaml.Unmarshal(b, &tokens); err != nil {
+			return fmt.Errorf("error parsing api-tokens-file: %s", err)
+		}
+		config.APITokens = append(config.APITokens, tokens...)
+	}
+	for i, token := range config.APITokens {
+		if token.Token == "" {
+			return fmt.Errorf("api token %d is empty", i)
+		}
+		switch token.Scope {
+		case "":
+			config.APITokens[i].Scope = "read"
+		case "read", "write":
+		default:
+			return fmt.Errorf("api token %d has invalid scope %s (must be read or write)", i, token.Scope)
+		}
+	}
+	return nil
+}
+
+// tokenScope returns the scope of the bearer token in an Authorization header, or an empty string if it isn't a
+// configured API token. The admin token has write scope.
+func tokenScope(config *Config, authorization string) string {
+	got := []byte(strings.TrimPrefix(authorization, "Bearer "))
+	scope := ""
+	for _, token := range config.APITokens {
+		if subtle.ConstantTimeCompare(got, []byte(token.Token)) == 1 && scope != "write" {
+			scope = token.Scope
+		}
+	}
+	if config.AdminToken != "" && subtle.ConstantTimeCompare(got, []byte(config.AdminToken)) == 1 {
+		scope = "write"
+	}
+	return scope
+}
+
+// requireToken wraps the HTTP API so that, once api-tokens are configured, every endpoint not in authExempt requires
+// a token, and methods other than GET and HEAD require a token with write scope. The peer endpoints also take their
+// own secret instead, and only an API token if no secret is configured.
+func requireToken(config *Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		configLock.RLock()
+		enabled := len(config.APITokens) > 0
+		scope := tokenScope(config, r.Header.Get("Authorization"))
+		peer := bearerAuthorized(r, peerSecret(config, r.URL.Path))
+		configLock.RUnlock()
+		if !enabled || authExempt[r.URL.Path] || peer {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if scope == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if scope != "write" && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Token is read-only", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// grpcReadMethods is the gRPC methods a token with read scope may call
+var grpcReadMethods = map[string]bool{
+	"Status":     true,
+	"Candidates": true,
+}
+
+// grpcTokenInterceptor applies the same token checks as requireToken to gRPC calls. Calls hold the config read lock
+// throughout, like HTTP requests.
+func grpcTokenInterceptor(config *Config) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		configLock.RLock()
+		defer configLock.RUnlock()
+		if len(config.APITokens) == 0 {
+			return handler(ctx, req)
+		}
+		var authorization string
+		if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) > 0 {
+			authorization = md.Get("authorization")[0]
+		}
+		scope := tokenScope(config, authorization)
+		if scope == "" {
+			return nil, status.Error(codes.Unauthenticated, "missing or unknown token")
+		}
+		method := info.FullMethod[strings.LastIndex(info.FullMethod, "/")+1:]
+		if scope != "write" && !grpcReadMethods[method] {
+			return nil, status.Error(codes.PermissionDenied, "token is read-only")
+		}
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireToken(t *testing.T) {
+	const tokens = "api-tokens: [{token: reader, scope: read}, {token: writer, scope: write}]\n"
+	secrets := testConfig(t, tokens+"panic-target: fmt2\npanic-token: panicking\nstandby-token: mirror\nmatrix-token: peer\n")
+	noSecrets := testConfig(t, tokens)
+	for _, tt := range []struct {
+		name     string
+		config   *Config
+		method   string
+		path     string
+		token    string
+		wantCode int
+	}{
+		{"metrics", secrets, http.MethodGet, "/metrics", "", http.StatusOK},
+		{"status without token", secrets, http.MethodGet, "/status", "", http.StatusUnauthorized},
+		{"status", secrets, http.MethodGet, "/status", "reader", http.StatusOK},
+		{"reroute read-only", secrets, http.MethodPost, "/reroute", "reader", http.StatusForbidden},
+		{"reroute", secrets, http.MethodPost, "/reroute", "writer", http.StatusOK},
+		{"matrix without token", secrets, http.MethodGet, "/matrix", "", http.StatusUnauthorized},
+		{"matrix with its secret", secrets, http.MethodGet, "/matrix", "peer", http.StatusOK},
+		{"matrix with API token", secrets, http.MethodGet, "/matrix", "reader", http.StatusOK},
+		{"matrix with another secret", secrets, http.MethodGet, "/matrix", "mirror", http.StatusUnauthorized},
+		{"panic without token", secrets, http.MethodPost, "/panic", "", http.StatusUnauthorized},
+		{"panic with its secret", secrets, http.MethodPost, "/panic", "panicking", http.StatusOK},
+		{"unpanic with its secret", secrets, http.MethodPost, "/unpanic", "panicking", http.StatusOK},
+		{"standby without token", secrets, http.MethodPost, "/standby", "", http.StatusUnauthorized},
+		{"standby with its secret", secrets, http.MethodPost, "/standby", "mirror", http.StatusOK},
+		// Without a configured secret the peer endpoints take API tokens only
+		{"matrix without secret", noSecrets, http.MethodGet, "/matrix", "", http.StatusUnauthorized},
+		{"panic without secret", noSecrets, http.MethodPost, "/panic", "", http.StatusUnauthorized},
+		{"standby without secret", noSecrets, http.MethodPost, "/standby", "", http.StatusUnauthorized},
+		{"standby without secret with API token", noSecrets, http.MethodPost, "/standby", "writer", http.StatusOK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := requireToken(tt.config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// autoRerouteStreak counts consecutive local health checks with the same outcome. It is guarded by the reroute lock.
+var autoRerouteStreak struct {
+	Unhealthy int
+	Healthy   int
+}
+
+// superviseAutoReroute reroutes to the default target once local health has failed for AutoRerouteEnter consecutive
+// checks, and withdraws a reroute it made once local health has passed for AutoRerouteExit consecutive checks. With
+// auto-revert set, withdrawing is left to superviseRevert so revert-hold and failback-window apply. Nothing is done
+// while probes are blocked, since the local health targets fail along with every node and the candidates are stale.
+func superviseAutoReroute(config *Config, health localHealth) {
+	reroute.Lock()
+	defer reroute.Unlock()
+	if probeTransportBlocked() {
+		autoRerouteStreak.Unhealthy = 0
+		autoRerouteStreak.Healthy = 0
+		return
+	}
+	if health.Healthy {
+		autoRerouteStreak.Unhealthy = 0
+		autoRerouteStreak.Healthy++
+	} else {
+		autoRerouteStreak.Healthy = 0
+		autoRerouteStreak.Unhealthy++
+	}
+	if reroute.Panic {
+		return
+	}
+
+	if reroute.Active {
+		if !reroute.Auto || config.AutoRevert || autoRerouteStreak.Healthy < config.AutoRerouteExit {
+			return
+		}
+		reason := fmt.Sprintf("local healthy for %d checks", autoRerouteStreak.Healthy)
+		log.Infof("Local health recovered, withdrawing automatic reroute to %s", reroute.Target)
+		if err := noRerouteLocked(config, reason); err != nil {
+			log.Errorf("Error withdrawing automatic reroute: %s", err)
+		}
+		return
+	}
+
+	if autoRerouteStreak.Unhealthy < config.AutoRerouteEnter {
+		return
+	}
+	// Keep retrying every check while local health stays down, but only warn on the first attempt
+	logf := log.Debugf
+	if autoRerouteStreak.Unhealthy == config.AutoRerouteEnter {
+		logf = log.Warnf
+	}
+	if err := degradedEverywhere(config); err != nil {
+		logf("Local health failed for %d checks, not rerouting: %s", autoRerouteStreak.Unhealthy, err)
+		return
+	}
+	node, name, choice := defaultTarget(config)
+	if node == nil {
+		logf("Local health failed for %d checks, not rerouting: %s", autoRerouteStreak.Unhealthy, choice)
+		return
+	}
+	reason := fmt.Sprintf("local unhealthy for %d checks (latency %s, loss %.1f%%), %s",
+		autoRerouteStreak.Unhealthy, health.Latency, health.Loss, choice)
+	if err := rerouteToAutoLocked(config, name, node, reason, true); err != nil {
+		logf("Error rerouting automatically to %s: %s", name, err)
+		return
+	}
+	log.Warnf("Local health failed, rerouted to %s: %s", name, reason)
+}
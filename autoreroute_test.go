@@ -0,0 +1,27 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestSuperviseAutoRerouteWhileProbesBlocked(t *testing.T) {
+	config := testConfig(t, "local-health-targets: [192.0.2.1]\nauto-reroute: true\nauto-reroute-enter: 2\n")
+	atomic.StoreInt32(&probesBlocked, 1)
+	t.Cleanup(func() {
+		atomic.StoreInt32(&probesBlocked, 0)
+		autoRerouteStreak.Unhealthy, autoRerouteStreak.Healthy = 0, 0
+	})
+	candidateNodes.Set("fmt2", config.Nodes["fmt2"])
+
+	for i := 0; i < 5; i++ {
+		superviseAutoReroute(config, localHealth{Healthy: false, Loss: 100})
+	}
+
+	if reroute.Active {
+		t.Fatal("rerouted automatically while probes were blocked")
+	}
+	if autoRerouteStreak.Unhealthy != 0 {
+		t.Errorf("unhealthy streak is %d while probes are blocked, want 0", autoRerouteStreak.Unhealthy)
+	}
+}
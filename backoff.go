@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// probeSchedule tracks consecutive probe failures of a node and when it's next due to be probed
+type probeSchedule struct {
+	failures int
+	interval time.Duration
+	next     time.Time
+}
+
+var schedules = struct {
+	sync.Mutex
+	nodes map[string]*probeSchedule
+}{nodes: map[string]*probeSchedule{}}
+
+// dueForProbe returns true if a node should be probed this cycle
+func dueForProbe(name string, now time.Time) bool {
+	schedules.Lock()
+	defer schedules.Unlock()
+	s, ok := schedules.nodes[name]
+	return !ok || !now.Before(s.next)
+}
+
+// backoffInterval returns the probe interval for a node probed every base interval with the given number of
+// consecutive failures. Nodes are probed every base interval until ProbeBackoffAfter failures, then the interval grows
+// by ProbeBackoffFactor per failure up to ProbeBackoffMax, or the base interval if that is longer.
+func backoffInterval(config *Config, base time.Duration, failures int) time.Duration {
+	if config.ProbeBackoffAfter == 0 || failures < config.ProbeBackoffAfter {
+		return base
+	}
+	exp := float64(failures - config.ProbeBackoffAfter + 1)
+	interval := time.Duration(float64(base) * math.Pow(config.ProbeBackoffFactor, exp))
+	if interval > config.ProbeBackoffMax || interval <= 0 {
+		interval = config.ProbeBackoffMax
+	}
+	if interval < base {
+		return base
+	}
+	return interval
+}
+
+// recordProbeOutcome updates a node's consecutive failure count and schedules its next probe, returning to full rate
+// as soon as a probe is answered. ok is whether the node answered at all, however slowly. It returns the consecutive
+// failure count.
+func recordProbeOutcome(config *Config, name string, ok bool, now time.Time) int {
+	schedules.Lock()
+	defer schedules.Unlock()
+	s, found := schedules.nodes[name]
+	if !found {
+		s = &probeSchedule{}
+		schedules.nodes[name] = s
+	}
+	base := config.nodePingInterval(config.Nodes[name])
+	if ok {
+		if s.interval > base {
+			log.Infof("Node %s recovered, resuming probes every %s", name, base)
+		}
+		s.failures = 0
+	} else {
+		s.failures++
+	}
+	metricNodeConsecutiveFailures.WithLabelValues(name).Set(float64(s.failures))
+
+	interval := backoffInterval(config, base, s.failures)
+	if interval != s.interval && interval > base {
+		log.Debugf("Node %s failed %d consecutive probes, probing every %s", name, s.failures, interval)
+	}
+	s.interval = interval
+	// Schedule slightly early so jitter in the ticker doesn't push the probe to the following cycle
+	s.next = now.Add(interval - config.PingInterval/2)
+	return s.failures
+}
+
+// probeNextCycle schedules a node to be probed on the next cycle regardless of its backoff
+func probeNextCycle(name string) {
+	schedules.Lock()
+	defer schedules.Unlock()
+	if s, ok := schedules.nodes[name]; ok {
+		s.next = time.Time{}
+	}
+}
+
+// probeIntervals returns the current probe interval of each node
+func probeIntervals() map[string]string {
+	schedules.Lock()
+	defer schedules.Unlock()
+	intervals := map[string]string{}
+	for name, s := range schedules.nodes {
+		intervals[name] = s.interval.String()
+	}
+	return intervals
+}
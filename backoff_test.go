@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBackoffInterval(t *testing.T) {
+	config := testConfig(t, "probe-backoff-after: 3\nprobe-backoff-factor: 2\nprobe-backoff-max: 10s\n")
+	for _, tt := range []struct {
+		base     time.Duration
+		failures int
+		want     time.Duration
+	}{
+		{time.Second, 0, time.Second},
+		{time.Second, 2, time.Second},
+		{time.Second, 3, 2 * time.Second},
+		{time.Second, 4, 4 * time.Second},
+		{time.Second, 10, 10 * time.Second},
+		{15 * time.Second, 5, 15 * time.Second}, // Never shorter than the node's own interval
+	} {
+		if got := backoffInterval(config, tt.base, tt.failures); got != tt.want {
+			t.Errorf("backoffInterval(%s, %d) = %s, want %s", tt.base, tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestBackoffDisabled(t *testing.T) {
+	config := testConfig(t, "")
+	if got := backoffInterval(config, time.Second, 100); got != time.Second {
+		t.Errorf("backoffInterval without probe-backoff-after = %s, want 1s", got)
+	}
+}
+
+func TestRecordProbeOutcome(t *testing.T) {
+	config := testConfig(t, "probe-backoff-after: 2\n")
+	now := time.Now()
+	for i := 1; i <= 3; i++ {
+		if got := recordProbeOutcome(config, "fmt2", false, now); got != i {
+			t.Fatalf("%d failures recorded after %d failed probes", got, i)
+		}
+	}
+	if dueForProbe("fmt2", now.Add(config.PingInterval)) {
+		t.Error("backed off node is due again after one ping-interval")
+	}
+	if !dueForProbe("fmt2", now.Add(4*config.PingInterval)) {
+		t.Error("backed off node isn't due after its backoff interval")
+	}
+
+	if got := recordProbeOutcome(config, "fmt2", true, now); got != 0 {
+		t.Errorf("%d failures after an answered probe, want 0", got)
+	}
+	if !dueForProbe("fmt2", now.Add(config.PingInterval)) {
+		t.Error("recovered node isn't probed every ping-interval again")
+	}
+}
+
+func TestApplySweepSlowNodeIsNotBackedOff(t *testing.T) {
+	config := testConfig(t, "probe-backoff-after: 1\n")
+	// Answered, but far above the latency threshold
+	slow := sweepResult{
+		Measurement: measurement{probeResult: probeResult{Latency: time.Second, Samples: []time.Duration{time.Second}}},
+		ProbeTime:   time.Now(),
+	}
+	applySweep(config, nil, map[string]sweepResult{"fmt2": slow}, true)
+	applySweep(config, nil, map[string]sweepResult{"fmt2": slow}, true)
+
+	if _, ok := candidateNodes.Get("fmt2"); ok {
+		t.Error("slow node is a candidate")
+	}
+	if !dueForProbe("fmt2", slow.ProbeTime.Add(config.PingInterval)) {
+		t.Error("slow but answering node was backed off")
+	}
+}
+
+func TestConsecutiveFailuresMetric(t *testing.T) {
+	config := testConfig(t, "")
+	now := time.Now()
+	gauge := metricNodeConsecutiveFailures.WithLabelValues("fmt2")
+	answered := []bool{false, false, false, true, false}
+	want := []float64{1, 2, 3, 0, 1}
+	for i, ok := range answered {
+		recordProbeOutcome(config, "fmt2", ok, now)
+		if got := testutil.ToFloat64(gauge); got != want[i] {
+			t.Errorf("after probe %d consecutive failures gauge is %.0f, want %.0f", i, got, want[i])
+		}
+	}
+}
+
+func TestProbeNextCycle(t *testing.T) {
+	config := testConfig(t, "probe-backoff-after: 1\n")
+	now := time.Now()
+	recordProbeOutcome(config, "fmt2", false, now)
+	recordProbeOutcome(config, "fmt2", false, now)
+	if dueForProbe("fmt2", now.Add(config.PingInterval)) {
+		t.Fatal("backed off node is due after one ping-interval")
+	}
+
+	probeNextCycle("fmt2")
+	if !dueForProbe("fmt2", now.Add(config.PingInterval)) {
+		t.Error("node isn't due on the next cycle despite its backoff")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// blackout holds the number of consecutive cycles skipped as probe blackouts
+var blackout = struct {
+	sync.Mutex
+	skips int
+}{}
+
+// probeBlackout returns true if a cycle's results should be ignored because at least blackout-fraction of the probed
+// nodes failed at once, which is more likely a local glitch such as a CPU spike starving the prober than a
+// simultaneous failure of most of the fabric. At most blackout-max-skips consecutive cycles are ignored, after which
+// the failures are acted on.
+func probeBlackout(config *Config, probed, lost int) bool {
+	if config.BlackoutFraction == 0 {
+		return false
+	}
+	blackout.Lock()
+	defer blackout.Unlock()
+	if probed < 2 || float64(lost)/float64(probed) < config.BlackoutFraction {
+		if blackout.skips > 0 {
+			log.Infof("Probe blackout over after %d skipped cycles", blackout.skips)
+			events.Add("blackout-end", "", fmt.Sprintf("%d cycles skipped", blackout.skips))
+			blackout.skips = 0
+		}
+		return false
+	}
+	if blackout.skips >= config.BlackoutMaxSkips {
+		if blackout.skips == config.BlackoutMaxSkips {
+			log.Warnf("Probe blackout persisted for %d cycles, acting on the failures", blackout.skips)
+			events.Add("blackout-expired", "", fmt.Sprintf("%d of %d nodes failed", lost, probed))
+			blackout.skips++
+		}
+		return false
+	}
+	blackout.skips++
+	log.Warnf("%d of %d nodes failed probes at once, assuming a local glitch and skipping evictions this cycle "+
+		"(%d/%d)", lost, probed, blackout.skips, config.BlackoutMaxSkips)
+	events.Add("blackout", "", fmt.Sprintf("%d of %d nodes failed", lost, probed))
+	metricProbeBlackouts.Inc()
+	return true
+}
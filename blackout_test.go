@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// resetBlackout forgets skipped blackout cycles when the test ends
+func resetBlackout(t *testing.T) {
+	t.Cleanup(func() {
+		blackout.Lock()
+		blackout.skips = 0
+		blackout.Unlock()
+	})
+}
+
+func TestProbeBlackout(t *testing.T) {
+	config := testConfig(t, "blackout-fraction: 0.5\nblackout-max-skips: 2\n")
+	resetBlackout(t)
+	skipped := testutil.ToFloat64(metricProbeBlackouts)
+	for i, tt := range []struct {
+		probed, lost int
+		want         bool
+	}{
+		{4, 1, false},
+		{1, 1, false}, // Too few nodes to tell a blackout from a failure
+		{4, 2, true},
+		{4, 4, true},
+		{4, 4, false}, // Past blackout-max-skips the failures are acted on
+		{4, 4, false},
+		{4, 0, false},
+		{4, 3, true}, // A new blackout after recovering is skipped again
+	} {
+		if got := probeBlackout(config, tt.probed, tt.lost); got != tt.want {
+			t.Errorf("cycle %d with %d of %d lost: blackout %t, want %t", i, tt.lost, tt.probed, got, tt.want)
+		}
+	}
+	if got := testutil.ToFloat64(metricProbeBlackouts) - skipped; got != 3 {
+		t.Errorf("counted %.0f blackouts, want 3", got)
+	}
+
+	if probeBlackout(testConfig(t, ""), 4, 4) {
+		t.Error("blackout without blackout-fraction")
+	}
+}
+
+func TestApplySweepBlackoutHoldsCandidates(t *testing.T) {
+	config := testConfig(t, "blackout-fraction: 0.5\nblackout-max-skips: 1\n")
+	resetBlackout(t)
+	candidateNodes.Set("fmt2", config.Nodes["fmt2"])
+	candidateNodes.Set("sea3", config.Nodes["sea3"])
+	sweep := answeredSweep(20*time.Millisecond, "sea3")
+	for name, result := range lostSweep("fmt2") {
+		sweep[name] = result
+	}
+
+	applySweep(config, nil, sweep, true)
+	if _, ok := candidateNodes.Get("fmt2"); !ok {
+		t.Error("failed node evicted during a blackout")
+	}
+	applySweep(config, nil, sweep, true)
+	if _, ok := candidateNodes.Get("fmt2"); ok {
+		t.Error("failed node still a candidate after blackout-max-skips")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// probesBlocked is set while every node and reference target shows total loss, which points at the probe transport
+// (e.g. ICMP filtered fleet-wide) rather than an outage of the whole fabric
+var probesBlocked int32
+
+// probeTransportBlocked returns true if probing is believed to be blocked, in which case the candidate set is held and
+// automatic failover is suspended
+func probeTransportBlocked() bool {
+	return atomic.LoadInt32(&probesBlocked) == 1
+}
+
+// updateProbesBlocked records a cycle's probe outcome: probed nodes, how many of them had total loss, and whether the
+// reference targets (or true if none are configured) also failed. A single probed node losing all replies is more
+// likely to be down than blocked, so at least two are required.
+func updateProbesBlocked(probed, lost int, referencesFailed bool) {
+	blocked := probed > 1 && lost == probed && referencesFailed
+	var value int32
+	if blocked {
+		value = 1
+	}
+	if atomic.SwapInt32(&probesBlocked, value) == value {
+		return
+	}
+	if blocked {
+		log.Errorf("All %d nodes show total loss, assuming probes are blocked: holding candidates and suspending "+
+			"automatic reroute", probed)
+		events.Add("probes-blocked", "", "all nodes show total loss")
+		metricProbesBlocked.Set(1)
+	} else {
+		log.Info("Probe replies received again, resuming candidate updates")
+		events.Add("probes-unblocked", "", "")
+		metricProbesBlocked.Set(0)
+	}
+}
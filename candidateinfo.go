@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// candidateInfoStatusLabels are the status dimensions that can be promoted to fabric_director_candidate_info labels.
+// Any other label is taken from the node tag of the same name.
+var candidateInfoStatusLabels = map[string]func(c candidateExplanation) string{
+	"degraded":        func(c candidateExplanation) string { return strconv.FormatBool(c.Degraded) },
+	"drained":         func(c candidateExplanation) string { return strconv.FormatBool(peerDraining(c.Name)) },
+	"selected":        func(c candidateExplanation) string { return strconv.FormatBool(c.Selected) },
+	"auto_selectable": func(c candidateExplanation) string { return strconv.FormatBool(c.AutoSelectable) },
+}
+
+// candidateInfoCollector exports one fabric_director_candidate_info series per candidate node at scrape time, with the
+// selection score as the value and the configured status dimensions as labels, so a dashboard can render the
+// candidate table from a single query. Series of nodes that stop being candidates disappear without cleanup.
+type candidateInfoCollector struct {
+	config *Config
+	desc   *prometheus.Desc
+}
+
+// newCandidateInfoCollector returns a collector for the labels listed in candidate-info-labels
+func newCandidateInfoCollector(config *Config) (*candidateInfoCollector, error) {
+	labels := []string{"dst"}
+	for _, label := range config.CandidateInfoLabels {
+		if !labelNameRegex.MatchString(label) || label == "dst" || label == config.MetricNodeLabel {
+			return nil, fmt.Errorf("invalid candidate info label %s", label)
+		}
+		labels = append(labels, label)
+	}
+	return &candidateInfoCollector{
+		config: config,
+		desc: prometheus.NewDesc("fabric_director_candidate_info",
+			"Selection score of each candidate node (latency in seconds, lowest wins)", labels, nil),
+	}, nil
+}
+
+func (c *candidateInfoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *candidateInfoCollector) Collect(ch chan<- prometheus.Metric) {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	if warmingUp(c.config) {
+		return
+	}
+	for _, candidate := range explainCandidates(c.config) {
+		// Candidates are always configured nodes, which bounds the number of series by the node count
+		node, ok := c.config.Nodes[candidate.Name]
+		if !ok {
+			continue
+		}
+		values := []string{candidate.Name}
+		for _, label := range c.config.CandidateInfoLabels {
+			if status, ok := candidateInfoStatusLabels[label]; ok {
+				values = append(values, status(candidate))
+			} else {
+				values = append(values, node.Tags[label])
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, candidate.Score, values...)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// candidateStore is the set of candidate nodes by name. The probe loop writes it while the API, the reroute logic and
+// metric collectors read it concurrently, so all access goes through its methods.
+type candidateStore struct {
+	sync.RWMutex
+	nodes map[string]Node
+}
+
+var candidateNodes = &candidateStore{nodes: map[string]Node{}}
+
+// Get returns a candidate node by name and whether it is a candidate
+func (s *candidateStore) Get(name string) (Node, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	node, ok := s.nodes[name]
+	return node, ok
+}
+
+// Set adds or updates a candidate node and returns true if it wasn't a candidate before
+func (s *candidateStore) Set(name string, node Node) bool {
+	s.Lock()
+	defer s.Unlock()
+	_, ok := s.nodes[name]
+	s.nodes[name] = node
+	return !ok
+}
+
+// Delete removes a candidate node and returns true if it was a candidate
+func (s *candidateStore) Delete(name string) bool {
+	s.Lock()
+	defer s.Unlock()
+	_, ok := s.nodes[name]
+	delete(s.nodes, name)
+	return ok
+}
+
+// Len returns the number of candidate nodes
+func (s *candidateStore) Len() int {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.nodes)
+}
+
+// Snapshot returns a copy of the candidate nodes by name that is safe to use without holding the lock
+func (s *candidateStore) Snapshot() map[string]Node {
+	s.RLock()
+	defer s.RUnlock()
+	nodes := make(map[string]Node, len(s.nodes))
+	for name, node := range s.nodes {
+		nodes[name] = node
+	}
+	return nodes
+}
+
+// sortedCandidates returns the current candidate nodes sorted by name
+func sortedCandidates() []namedNode {
+	var candidates []namedNode
+	for name, node := range candidateNodes.Snapshot() {
+		candidates = append(candidates, namedNode{Name: name, Node: node})
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Name < candidates[j].Name })
+	return candidates
+}
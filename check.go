@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+)
+
+// checkRoute is how a rerouted prefix would be routed towards one target node
+type checkRoute struct {
+	Prefix  string `json:"prefix"`
+	Target  string `json:"target"`
+	Nexthop string `json:"nexthop"`
+}
+
+// checkResult is the plan printed by -check
+type checkResult struct {
+	Plan   *plan        `json:"plan"`
+	Routes []checkRoute `json:"routes"`
+}
+
+// checkConfig loads and validates a config file and prints the tunnels and reroute routes it would set up, without
+// resolving hostnames or touching the kernel
+func checkConfig(path string, asJSON bool) error {
+	config, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	p, err := buildPlan(config)
+	if err != nil {
+		return err
+	}
+
+	result := checkResult{Plan: p}
+	for _, t := range p.Tunnels {
+		for _, prefix := range reroutedPrefixes(config) {
+			// Prefixes in prefix-targets go to their own node whichever node the reroute targets
+			node := config.Nodes[prefixTarget(config, prefix, t.Node)]
+			nexthop4, nexthop6 := rerouteNexthops(config, &node)
+			nexthop := nexthop4
+			if _, ipNet, _ := net.ParseCIDR(prefix); ipNet.IP.To4() == nil {
+				nexthop = nexthop6
+			}
+			if nexthop == "" && config.RerouteVia == "underlay" && isHostname(node.IP) {
+				nexthop = node.IP // Resolved at startup
+			}
+			if nexthop == "" {
+				return fmt.Errorf("no nexthop for %s via %s", prefix, t.Node)
+			}
+			result.Routes = append(result.Routes, checkRoute{Prefix: prefix, Target: t.Node, Nexthop: nexthop})
+		}
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+	fmt.Printf("%s is valid, local node %s (%s)\n", path, p.LocalNode, p.LocalIP)
+	for _, t := range p.Tunnels {
+		fmt.Printf("  tunnel    %s\n", t)
+	}
+	for _, r := range result.Routes {
+		fmt.Printf("  route     %s via %s when rerouted to %s\n", r.Prefix, r.Nexthop, r.Target)
+	}
+	fmt.Printf("%d tunnels, %d prefixes\n", len(p.Tunnels), len(p.Prefixes))
+	return nil
+}
@@ -0,0 +1,871 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	LocalID              uint8           `yaml:"local-id"`
+	Prefix4              string          `yaml:"prefix4"`
+	Prefix6              string          `yaml:"prefix6"`
+	PingInterval         time.Duration   `yaml:"ping-interval"`
+	LatencyThreshold     time.Duration   `yaml:"latency-threshold"`
+	LossThreshold        float64         `yaml:"loss-threshold"`
+	Listen               listenAddrs     `yaml:"listen"`
+	Prefixes             []string        `yaml:"prefixes"`
+	PrefixAggregates     []string        `yaml:"prefix-aggregates"` // Ranges prefixes must fall within, empty to allow any
+	Nodes                map[string]Node `yaml:"nodes"`
+	ProbeType            string          `yaml:"probe-type"`
+	ProbeFallback        string          `yaml:"probe-fallback"`
+	ProbePort            uint16          `yaml:"probe-port"`
+	ProbeGrace           time.Duration   `yaml:"probe-grace"`        // Extra time to count late replies, zero to disable
+	BlackoutFraction     float64         `yaml:"blackout-fraction"`  // Fraction of nodes failing at once, zero to disable
+	BlackoutMaxSkips     int             `yaml:"blackout-max-skips"` // Consecutive blackout cycles to ignore
+	ProbePanicExit       int             `yaml:"probe-panic-exit"`   // Consecutive panicking cycles before exiting, zero to never exit
+	ProbeConcurrency     int             `yaml:"probe-concurrency"`  // Nodes probed at once
+	ProbeSweepTimeout    time.Duration   `yaml:"probe-sweep-timeout"`
+	RerouteVia           string          `yaml:"reroute-via"`
+	RerouteFallbacks     []string        `yaml:"reroute-fallbacks"`
+	TargetProbeCount     int             `yaml:"target-probe-count"`
+	SampleWindow         int             `yaml:"sample-window"` // Per-packet RTTs retained per node for percentiles
+	TargetProbeInterval  time.Duration   `yaml:"target-probe-interval"`
+	TargetDownCycles     int             `yaml:"target-down-cycles"`
+	RerouteMinCycles     int             `yaml:"reroute-min-cycles"` // Completed cycles required before automatic selection
+	Webhook              string          `yaml:"webhook"`
+	StandbyPeer          string          `yaml:"standby-peer"`  // Base URL of the standby director's API
+	StandbyToken         string          `yaml:"standby-token"` // Bearer token for mirrored updates, in both directions
+	MatrixToken          string          `yaml:"matrix-token"`  // Bearer token for /matrix views, in both directions
+	RerouteMaxLatency    time.Duration   `yaml:"reroute-max-latency"`
+	EventLogSize         int             `yaml:"event-log-size"`
+	ProbeIPv6            bool            `yaml:"probe-ipv6"`
+	LatencyThreshold4    time.Duration   `yaml:"latency-threshold-v4"`
+	LatencyThreshold6    time.Duration   `yaml:"latency-threshold-v6"`
+	FamilyHealth         string          `yaml:"family-health"`
+	AllowSimulation      bool            `yaml:"allow-simulation"`
+	FailoverOnTargetDown string          `yaml:"failover-on-target-down"`
+	MinImprovement       time.Duration   `yaml:"min-improvement"`       // Latency a failover must gain, zero to disable
+	MinImprovementRatio  float64         `yaml:"min-improvement-ratio"` // Fraction of latency a failover must gain
+	TerminalPolicy       string          `yaml:"terminal-policy"`       // local, hold, or least-bad when next finds no candidate
+	ProbeBlockedAction   string          `yaml:"probe-blocked-action"`  // hold or off
+	MetricLabels         []string        `yaml:"metric-labels"`
+	MetricNodeLabel      string          `yaml:"metric-node-label"` // Label carrying the local node name on all series
+	CandidateInfoLabels  []string        `yaml:"candidate-info-labels"`
+	ProbeBind            string          `yaml:"probe-bind"`
+	ProbeSources         []string        `yaml:"probe-source-strategies"`
+	SourceInterfaces     []string        `yaml:"probe-source-interfaces"`
+	UnreadySource        string          `yaml:"unready-source"` // skip or probe
+	CandidateWindow      int             `yaml:"candidate-window"`
+	CandidateWindowPass  int             `yaml:"candidate-window-pass"`
+	EWMAAlpha            float64         `yaml:"ewma-alpha"` // Weight of the newest sample in the smoothed latency and loss
+	LatencyHysteresis    time.Duration   `yaml:"latency-hysteresis"`
+	LossHysteresis       float64         `yaml:"loss-hysteresis"`
+	ClosestHysteresis    time.Duration   `yaml:"closest-hysteresis"`
+	MetricExemplars      bool            `yaml:"metric-exemplars"`
+	LatencyMetricType    string          `yaml:"latency-metric-type"` // gauge, histogram, or summary
+	LocalHealthTargets   []string        `yaml:"local-health-targets"`
+	AutoRevert           bool            `yaml:"auto-revert"`
+	AutoReroute          bool            `yaml:"auto-reroute"`
+	AutoRerouteEnter     int             `yaml:"auto-reroute-enter"` // Consecutive unhealthy local health checks before rerouting
+	AutoRerouteExit      int             `yaml:"auto-reroute-exit"`  // Consecutive healthy local health checks before reverting
+	RevertHold           time.Duration   `yaml:"revert-hold"`
+	FailbackWindow       string          `yaml:"failback-window"` // Daily local time range automatic reverts wait for
+	DNSTTL               time.Duration   `yaml:"dns-ttl"`         // How long node hostname resolutions are cached
+	JitterThreshold      time.Duration   `yaml:"jitter-threshold"`
+	JitterAction         string          `yaml:"jitter-action"`
+	DegradedLoss         float64         `yaml:"degraded-loss"`   // Loss percent from which a candidate is degraded, zero to disable
+	DegradedAction       string          `yaml:"degraded-action"` // deprioritize or exclude
+	OnNodeUp             []string        `yaml:"on-node-up"`
+	OnNodeDown           []string        `yaml:"on-node-down"`
+	HookTimeout          time.Duration   `yaml:"hook-timeout"`
+	DefaultRerouteTarget string          `yaml:"default-reroute-target"`
+	DefaultRerouteStrict bool            `yaml:"default-reroute-strict"`
+	ProbeBackoffAfter    int             `yaml:"probe-backoff-after"`
+	ProbeBackoffFactor   float64         `yaml:"probe-backoff-factor"`
+	ProbeBackoffMax      time.Duration   `yaml:"probe-backoff-max"`
+	AdminToken           string          `yaml:"admin-token"`
+	APITokens            []apiToken      `yaml:"api-tokens"`
+	APITokensFile        string          `yaml:"api-tokens-file"`
+	TLSCert              string          `yaml:"tls-cert"`
+	TLSKey               string          `yaml:"tls-key"`
+	TLSClientCA          string          `yaml:"tls-client-ca"` // Require client certificates issued by this CA
+	PanicTarget          string          `yaml:"panic-target"`
+	PanicToken           string          `yaml:"panic-token"`
+	TunnelMTU            int             `yaml:"tunnel-mtu"`
+	ReroutePreflight     string          `yaml:"reroute-preflight"` // Empty to disable, or warn or refuse
+	AddressFamily        string          `yaml:"address-family"`    // auto, dual, or ipv4
+	WebhookRetries       int             `yaml:"webhook-retries"`
+	GRPCListen           string          `yaml:"grpc-listen"`
+	APIAccessLog         bool            `yaml:"api-access-log"`
+	APIReadTimeout       time.Duration   `yaml:"api-read-timeout"`
+	APIWriteTimeout      time.Duration   `yaml:"api-write-timeout"`
+	APIIdleTimeout       time.Duration   `yaml:"api-idle-timeout"`
+	APIMaxHeaderBytes    int             `yaml:"api-max-header-bytes"`
+	TeardownOnStart      bool            `yaml:"teardown-on-start"`
+	TeardownOnExit       bool            `yaml:"teardown-on-exit"` // Withdraw the reroute and delete tunnels on SIGTERM
+	TeardownDelay        time.Duration   `yaml:"teardown-delay"`
+	TunnelSetupInterval  time.Duration   `yaml:"tunnel-setup-interval"`
+	TunnelType           string          `yaml:"tunnel-type"` // gre, wireguard or vxlan
+	WireguardKey         string          `yaml:"wireguard-private-key"`
+	WireguardKeyFile     string          `yaml:"wireguard-private-key-file"`
+	WireguardPort        int             `yaml:"wireguard-port"` // Base listen port, the peer's node ID is added
+	VxlanVNI             int             `yaml:"vxlan-vni"`      // Base VNI, the node pair's IDs are added
+	VxlanPort            int             `yaml:"vxlan-port"`
+	MetricMaxNodes       int             `yaml:"metric-max-nodes"` // Zero for no limit
+	MetricsWarmup        bool            `yaml:"metrics-warmup"`
+	MinHealthyFraction   float64         `yaml:"min-healthy-fraction"`
+	PartitionDetection   bool            `yaml:"partition-detection"`
+	MatrixURL            string          `yaml:"matrix-url"` // Peer /matrix URL with {ip} and {node} placeholders
+	MatrixInterval       time.Duration   `yaml:"matrix-interval"`
+	StateFile            string          `yaml:"state-file"`
+	KVBackend            string          `yaml:"kv-backend"` // consul or etcd, empty to disable
+	KVAddress            string          `yaml:"kv-address"`
+	KVKey                string          `yaml:"kv-key"`
+	RestartTarget        string          `yaml:"restart-target"`       // previous or closest
+	ListenOverlayCheck   string          `yaml:"listen-overlay-check"` // warn, fail, or off
+	WebhookRetryBackoff  time.Duration   `yaml:"webhook-retry-backoff"`
+	PathMTUProbe         bool            `yaml:"path-mtu-probe"`
+	PathMTUInterval      time.Duration   `yaml:"path-mtu-interval"`
+	KeepaliveInterval    time.Duration   `yaml:"keepalive-interval"` // Zero to disable
+	KeepaliveFailures    int             `yaml:"keepalive-failures"`
+	LinkWatch            bool            `yaml:"link-watch"`
+	MonitorOnly          bool            `yaml:"monitor-only"`
+	OTLPEndpoint         string          `yaml:"otlp-endpoint"` // host:port of an OTLP gRPC collector, empty to disable tracing
+	OTLPInsecure         bool            `yaml:"otlp-insecure"`
+	DrainTimeout         time.Duration   `yaml:"drain-timeout"`  // Zero to shut down without draining
+	SecondOpinion        []string        `yaml:"second-opinion"` // Sibling /matrix URLs asked before evicting a candidate
+	ProbePinning         bool            `yaml:"probe-pinning"`
+
+	FabricHealth fabricHealthConfig `yaml:"fabric-health"`
+
+	// Name of this fabric and the internal prefixes of other fabrics sharing this host, by fabric name
+	FabricName string                    `yaml:"fabric-name"`
+	Fabrics    map[string]fabricPrefixes `yaml:"fabrics"`
+
+	// Upper bounds in seconds of the reroute duration histogram buckets
+	RerouteDurationBuckets []float64 `yaml:"reroute-duration-buckets"`
+
+	// Quantile to allowed error of the latency summary with latency-metric-type summary
+	LatencySummaryObjectives map[float64]float64 `yaml:"latency-summary-objectives"`
+
+	// Consecutive failed cycles after which a node's tunnel is recreated if its underlay answers, zero to disable, and
+	// the minimum time between recreations of a tunnel
+	TunnelRecreateAfter    int           `yaml:"tunnel-recreate-after"`
+	TunnelRecreateInterval time.Duration `yaml:"tunnel-recreate-interval"`
+
+	// Node each listed prefix is rerouted to instead of the reroute target, and prefixes that are never rerouted
+	PrefixTargets map[string]string `yaml:"prefix-targets"`
+	RerouteDeny   []string          `yaml:"reroute-deny"`
+}
+
+// loadConfig reads a config file, applying defaults and validating it
+func loadConfig(path string) (*Config, error) {
+	yamlBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(yamlBytes, &config); err != nil {
+		return nil, err
+	}
+
+	switch config.RerouteVia {
+	case "":
+		config.RerouteVia = "overlay"
+	case "overlay", "underlay":
+	default:
+		return nil, fmt.Errorf("invalid reroute-via %s (must be overlay or underlay)", config.RerouteVia)
+	}
+
+	switch config.FailoverOnTargetDown {
+	case "":
+		config.FailoverOnTargetDown = "next"
+	case "next", "local", "hold":
+	default:
+		return nil, fmt.Errorf("invalid failover-on-target-down %s (must be next, local, or hold)", config.FailoverOnTargetDown)
+	}
+
+	if config.MinImprovementRatio < 0 || config.MinImprovementRatio >= 1 {
+		return nil, fmt.Errorf("min-improvement-ratio must be between 0 and 1")
+	}
+
+	switch config.ProbeBlockedAction {
+	case "":
+		config.ProbeBlockedAction = "hold"
+	case "hold", "off":
+	default:
+		return nil, fmt.Errorf("invalid probe-blocked-action %s (must be hold or off)", config.ProbeBlockedAction)
+	}
+
+	switch config.TerminalPolicy {
+	case "":
+		config.TerminalPolicy = "local"
+	case "local", "hold", "least-bad":
+	default:
+		return nil, fmt.Errorf("invalid terminal-policy %s (must be local, hold, or least-bad)", config.TerminalPolicy)
+	}
+
+	switch config.AddressFamily {
+	case "":
+		config.AddressFamily = "auto"
+	case "auto", "dual", "ipv4":
+	default:
+		return nil, fmt.Errorf("invalid address-family %s (must be auto, dual, or ipv4)", config.AddressFamily)
+	}
+
+	switch config.LatencyMetricType {
+	case "":
+		config.LatencyMetricType = "gauge"
+	case "gauge", "histogram", "summary":
+	default:
+		return nil, fmt.Errorf("invalid latency-metric-type %s (must be gauge, histogram, or summary)", config.LatencyMetricType)
+	}
+	if config.MetricExemplars && config.LatencyMetricType != "histogram" {
+		return nil, fmt.Errorf("metric-exemplars requires latency-metric-type histogram")
+	}
+	if len(config.LatencySummaryObjectives) == 0 {
+		config.LatencySummaryObjectives = map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001}
+	}
+
+	if config.FabricHealth.YellowBelow == 0 {
+		config.FabricHealth.YellowBelow = 0.9
+	}
+	if config.FabricHealth.RedBelow == 0 {
+		config.FabricHealth.RedBelow = 0.5
+	}
+	if config.FabricHealth.RedBelow > config.FabricHealth.YellowBelow {
+		return nil, fmt.Errorf("fabric-health red-below must not be greater than yellow-below")
+	}
+	if config.FabricHealth.RerouteLevel == "" {
+		config.FabricHealth.RerouteLevel = "yellow"
+	}
+	if config.FabricHealth.LocalDegradedLevel == "" {
+		config.FabricHealth.LocalDegradedLevel = "red"
+	}
+	for _, level := range []string{config.FabricHealth.RerouteLevel, config.FabricHealth.LocalDegradedLevel} {
+		if _, ok := healthLevels[level]; !ok {
+			return nil, fmt.Errorf("invalid fabric-health level %s (must be green, yellow, or red)", level)
+		}
+	}
+
+	if config.PanicTarget != "" {
+		if _, ok := config.Nodes[config.PanicTarget]; !ok {
+			return nil, fmt.Errorf("panic-target %s is not a configured node", config.PanicTarget)
+		}
+		if config.PanicToken == "" {
+			return nil, fmt.Errorf("panic-target requires panic-token to be set")
+		}
+		if config.PanicToken == config.AdminToken {
+			return nil, fmt.Errorf("panic-token must differ from admin-token")
+		}
+	}
+
+	if err := loadAPITokens(&config); err != nil {
+		return nil, err
+	}
+	if err := loadTLS(&config); err != nil {
+		return nil, err
+	}
+
+	if config.StandbyPeer != "" && config.StandbyToken == "" {
+		return nil, fmt.Errorf("standby-peer requires standby-token to be set")
+	}
+
+	switch config.KVBackend {
+	case "":
+	case "consul", "etcd":
+		if config.KVAddress == "" {
+			return nil, fmt.Errorf("kv-backend requires kv-address to be set")
+		}
+		if config.KVKey == "" {
+			config.KVKey = "fabric-director/{node}/reroute"
+		}
+	default:
+		return nil, fmt.Errorf("invalid kv-backend %s (must be consul or etcd)", config.KVBackend)
+	}
+
+	switch config.RestartTarget {
+	case "":
+		config.RestartTarget = "previous"
+	case "previous", "closest":
+	default:
+		return nil, fmt.Errorf("invalid restart-target %s (must be previous or closest)", config.RestartTarget)
+	}
+
+	switch config.ReroutePreflight {
+	case "", "warn", "refuse":
+	default:
+		return nil, fmt.Errorf("invalid reroute-preflight %s (must be warn or refuse)", config.ReroutePreflight)
+	}
+
+	switch config.ProbeBind {
+	case "":
+		config.ProbeBind = "source"
+	case "source", "interface":
+	default:
+		return nil, fmt.Errorf("invalid probe-bind %s (must be source or interface)", config.ProbeBind)
+	}
+	switch config.UnreadySource {
+	case "":
+		config.UnreadySource = "skip"
+	case "skip", "probe":
+	default:
+		return nil, fmt.Errorf("invalid unready-source %s (must be skip or probe)", config.UnreadySource)
+	}
+	if len(config.ProbeSources) == 0 {
+		config.ProbeSources = []string{config.ProbeBind}
+	}
+	for _, strategy := range config.ProbeSources {
+		valid := false
+		for _, s := range sourceStrategies {
+			if strategy == s {
+				valid = true
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid probe source strategy %s (must be source, interface, or auto)", strategy)
+		}
+	}
+
+	switch config.JitterAction {
+	case "":
+		config.JitterAction = "evict"
+	case "evict", "deselect":
+	default:
+		return nil, fmt.Errorf("invalid jitter-action %s (must be evict or deselect)", config.JitterAction)
+	}
+
+	switch config.DegradedAction {
+	case "":
+		config.DegradedAction = "deprioritize"
+	case "deprioritize", "exclude":
+	default:
+		return nil, fmt.Errorf("invalid degraded-action %s (must be deprioritize or exclude)", config.DegradedAction)
+	}
+	if config.DegradedLoss != 0 && config.LossThreshold != 0 && config.DegradedLoss >= config.LossThreshold {
+		return nil, fmt.Errorf("degraded-loss %.1f must be below loss-threshold %.1f", config.DegradedLoss, config.LossThreshold)
+	}
+
+	switch config.FamilyHealth {
+	case "":
+		config.FamilyHealth = "all"
+	case "all", "any":
+	default:
+		return nil, fmt.Errorf("invalid family-health %s (must be all or any)", config.FamilyHealth)
+	}
+
+	if config.BlackoutFraction < 0 || config.BlackoutFraction > 1 {
+		return nil, fmt.Errorf("blackout-fraction must be between 0 and 1")
+	}
+	if config.BlackoutMaxSkips == 0 {
+		config.BlackoutMaxSkips = 3
+	}
+	if config.ProbeConcurrency == 0 {
+		config.ProbeConcurrency = 16
+	}
+	if config.ProbeConcurrency < 0 {
+		return nil, fmt.Errorf("probe-concurrency must not be negative")
+	}
+	if config.ProbeSweepTimeout == 0 {
+		config.ProbeSweepTimeout = config.PingInterval
+	}
+	if config.ProbeSweepTimeout < 0 {
+		return nil, fmt.Errorf("probe-sweep-timeout must not be negative")
+	}
+	if config.ProbeGrace < 0 {
+		return nil, fmt.Errorf("probe-grace must not be negative")
+	}
+	if config.ProbeType == "" {
+		config.ProbeType = "icmp"
+	}
+	for name, node := range config.Nodes {
+		if node.PingInterval < 0 || node.PingCount < 0 || node.ProbeTimeout < 0 || node.LatencyMax < 0 || node.LossMax < 0 {
+			return nil, fmt.Errorf("node %s has a negative probe override", name)
+		}
+		if node.PingInterval != 0 && node.PingInterval < config.PingInterval {
+			return nil, fmt.Errorf("node %s ping-interval %s is shorter than the global ping-interval %s", name, node.PingInterval, config.PingInterval)
+		}
+		if node.LossMax > 100 {
+			return nil, fmt.Errorf("node %s loss-threshold %.1f exceeds 100", name, node.LossMax)
+		}
+	}
+	if config.SampleWindow == 0 {
+		config.SampleWindow = 300
+	}
+	if config.SampleWindow < 0 || config.SampleWindow > 10000 {
+		return nil, fmt.Errorf("sample-window must be between 1 and 10000")
+	}
+	if config.TargetProbeCount == 0 {
+		config.TargetProbeCount = 10
+	}
+	if config.TargetProbeInterval == 0 {
+		config.TargetProbeInterval = 20 * time.Millisecond
+	}
+	if config.TargetDownCycles == 0 {
+		config.TargetDownCycles = 2
+	}
+	if config.EventLogSize == 0 {
+		config.EventLogSize = 100
+	}
+
+	if config.CandidateWindow == 0 {
+		config.CandidateWindow = 1
+	}
+	if config.CandidateWindowPass == 0 {
+		config.CandidateWindowPass = config.CandidateWindow
+	}
+	if config.CandidateWindowPass > config.CandidateWindow {
+		return nil, fmt.Errorf("candidate-window-pass %d exceeds candidate-window %d", config.CandidateWindowPass, config.CandidateWindow)
+	}
+	if config.EWMAAlpha < 0 || config.EWMAAlpha > 1 {
+		return nil, fmt.Errorf("ewma-alpha must be between 0 and 1")
+	}
+	if config.LatencyHysteresis < 0 || config.LossHysteresis < 0 || config.ClosestHysteresis < 0 {
+		return nil, fmt.Errorf("latency-hysteresis, loss-hysteresis and closest-hysteresis must not be negative")
+	}
+
+	if len(config.Listen) == 0 {
+		return nil, fmt.Errorf("no listen address configured")
+	}
+	for _, addr := range config.Listen {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("invalid listen address %s: %s", addr, err)
+		}
+	}
+	switch config.ListenOverlayCheck {
+	case "":
+		config.ListenOverlayCheck = "warn"
+	case "warn", "fail", "off":
+	default:
+		return nil, fmt.Errorf("invalid listen-overlay-check %s (must be warn, fail, or off)", config.ListenOverlayCheck)
+	}
+	if config.ListenOverlayCheck != "off" {
+		for _, addr := range overlayListenAddrs(&config) {
+			if config.ListenOverlayCheck == "fail" {
+				return nil, fmt.Errorf("listen address %s is on the overlay or a rerouted prefix, the API would be unreachable when the overlay fails", addr)
+			}
+			log.Warnf("Listen address %s is on the overlay or a rerouted prefix, the API will be unreachable for manual recovery if the overlay fails", addr)
+		}
+	}
+	if config.GRPCListen != "" {
+		if _, _, err := net.SplitHostPort(config.GRPCListen); err != nil {
+			return nil, fmt.Errorf("invalid grpc-listen address %s: %s", config.GRPCListen, err)
+		}
+	}
+
+	if config.AutoRevert && len(config.LocalHealthTargets) == 0 {
+		return nil, fmt.Errorf("auto-revert requires local-health-targets")
+	}
+	if config.AutoReroute && len(config.LocalHealthTargets) == 0 {
+		return nil, fmt.Errorf("auto-reroute requires local-health-targets")
+	}
+	if config.AutoRerouteEnter == 0 {
+		config.AutoRerouteEnter = 3
+	}
+	if config.AutoRerouteExit == 0 {
+		config.AutoRerouteExit = 3
+	}
+	if config.AutoRerouteEnter < 1 || config.AutoRerouteExit < 1 {
+		return nil, fmt.Errorf("auto-reroute-enter and auto-reroute-exit must be at least 1")
+	}
+	if config.ProbeBackoffFactor == 0 {
+		config.ProbeBackoffFactor = 2
+	}
+	if config.ProbeBackoffFactor < 1 {
+		return nil, fmt.Errorf("probe-backoff-factor must be at least 1")
+	}
+	if config.ProbeBackoffMax == 0 {
+		config.ProbeBackoffMax = time.Minute
+	}
+
+	if config.TunnelRecreateInterval == 0 {
+		config.TunnelRecreateInterval = 10 * time.Minute
+	}
+	switch config.TunnelType {
+	case "":
+		config.TunnelType = "gre"
+	case "gre", "wireguard", "vxlan":
+	default:
+		return nil, fmt.Errorf("invalid tunnel-type %s (must be gre, wireguard or vxlan)", config.TunnelType)
+	}
+	if config.WireguardPort == 0 {
+		config.WireguardPort = 51820
+	}
+	if config.WireguardPort < 1 || config.WireguardPort+255 > 65535 {
+		return nil, fmt.Errorf("wireguard-port must be between 1 and 65280")
+	}
+	if config.VxlanVNI == 0 {
+		config.VxlanVNI = 0x100000
+	}
+	if config.VxlanVNI < 1 || config.VxlanVNI+0xffff > 0xffffff {
+		return nil, fmt.Errorf("vxlan-vni must be between 1 and %d", 0xffffff-0xffff)
+	}
+	if config.VxlanPort == 0 {
+		config.VxlanPort = 4789
+	}
+	if config.VxlanPort < 1 || config.VxlanPort > 65535 {
+		return nil, fmt.Errorf("vxlan-port must be between 1 and 65535")
+	}
+	var wireguard bool
+	for name, node := range config.Nodes {
+		switch nodeTunnelType(&config, node) {
+		case "gre", "vxlan":
+		case "wireguard":
+			if node.ID != config.LocalID && node.PublicKey == "" {
+				return nil, fmt.Errorf("node %s uses a wireguard tunnel but has no public-key", name)
+			}
+			wireguard = wireguard || node.ID != config.LocalID
+		default:
+			return nil, fmt.Errorf("node %s has invalid tunnel-type %s (must be gre, wireguard or vxlan)", name, node.TunnelType)
+		}
+	}
+	if wireguard {
+		if _, err := wireguardPrivateKey(&config); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.TunnelMTU == 0 {
+		config.TunnelMTU = 1436 // 1500 - 20 byte TCP header - 20 byte IP header - 24 byte GRE header + IP header
+		if config.TunnelType == "wireguard" {
+			config.TunnelMTU = 1420 // 1500 - 80 byte WireGuard overhead over IPv6
+		} else if config.TunnelType == "vxlan" {
+			config.TunnelMTU = 1430 // 1500 - 70 byte VXLAN overhead over IPv6
+		}
+	}
+	if len(config.RerouteDurationBuckets) == 0 {
+		// One minute to about two days
+		config.RerouteDurationBuckets = prometheus.ExponentialBuckets(60, 2, 12)
+	}
+	if config.CandidateInfoLabels == nil {
+		// An explicitly empty list exports only dst
+		config.CandidateInfoLabels = []string{"degraded", "drained", "selected"}
+	}
+	if config.APIReadTimeout == 0 {
+		config.APIReadTimeout = 10 * time.Second
+	}
+	if config.APIWriteTimeout == 0 {
+		config.APIWriteTimeout = 30 * time.Second
+	}
+	if config.APIIdleTimeout == 0 {
+		config.APIIdleTimeout = 2 * time.Minute
+	}
+	if config.APIMaxHeaderBytes == 0 {
+		config.APIMaxHeaderBytes = 64 << 10
+	}
+	if config.KeepaliveFailures == 0 {
+		config.KeepaliveFailures = 3
+	}
+	if config.PathMTUInterval == 0 {
+		config.PathMTUInterval = 10 * time.Minute
+	}
+
+	if config.PartitionDetection && config.MatrixURL == "" {
+		return nil, fmt.Errorf("partition-detection requires matrix-url to be set")
+	}
+	if config.MatrixInterval == 0 {
+		config.MatrixInterval = 30 * time.Second
+	}
+
+	if config.MinHealthyFraction < 0 || config.MinHealthyFraction > 1 {
+		return nil, fmt.Errorf("min-healthy-fraction must be between 0 and 1")
+	}
+
+	if config.MetricMaxNodes < 0 {
+		return nil, fmt.Errorf("metric-max-nodes must not be negative")
+	}
+
+	if config.WebhookRetries < 0 {
+		return nil, fmt.Errorf("webhook-retries must not be negative")
+	}
+	if config.WebhookRetryBackoff == 0 {
+		config.WebhookRetryBackoff = time.Second
+	}
+
+	if config.HookTimeout == 0 {
+		config.HookTimeout = 10 * time.Second
+	}
+
+	if config.RevertHold == 0 {
+		config.RevertHold = 5 * time.Minute
+	}
+	if config.FailbackWindow != "" {
+		if _, err := parseTimeWindow(config.FailbackWindow); err != nil {
+			return nil, fmt.Errorf("failback-window: %s", err)
+		}
+	}
+	if config.DNSTTL == 0 {
+		config.DNSTTL = 5 * time.Minute
+	}
+
+	ids := map[uint8]string{}
+	for name, node := range config.Nodes {
+		if other, ok := ids[node.ID]; ok {
+			if other > name {
+				name, other = other, name
+			}
+			return nil, fmt.Errorf("nodes %s and %s have the same id %d", other, name, node.ID)
+		}
+		ids[node.ID] = name
+		// Anything that isn't an IP literal must be a hostname, resolved at startup through the DNS cache
+		if isHostname(node.IP) && !hostnameRegex.MatchString(node.IP) {
+			return nil, fmt.Errorf("node %s has invalid IP %s", name, node.IP)
+		}
+		if host, zone := splitZone(node.IP); zone != "" {
+			if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+				return nil, fmt.Errorf("node %s has zone %s on IPv4 address %s", name, zone, host)
+			}
+		}
+		if node.ProbeNexthop != "" {
+			host, _ := splitZone(node.ProbeNexthop)
+			if net.ParseIP(host) == nil {
+				return nil, fmt.Errorf("node %s has invalid probe-nexthop %s", name, node.ProbeNexthop)
+			}
+		}
+	}
+
+	if err := validateUnderlay(&config); err != nil {
+		return nil, err
+	}
+
+	if config.DefaultRerouteTarget != "" {
+		if _, ok := config.Nodes[config.DefaultRerouteTarget]; !ok {
+			return nil, fmt.Errorf("default reroute target %s is not a configured node", config.DefaultRerouteTarget)
+		}
+	}
+
+	for _, name := range config.RerouteFallbacks {
+		if _, ok := config.Nodes[name]; !ok {
+			return nil, fmt.Errorf("reroute fallback %s is not a configured node", name)
+		}
+	}
+
+	configured := map[string]bool{}
+	for _, prefix := range config.Prefixes {
+		if _, _, err := net.ParseCIDR(prefix); err != nil {
+			return nil, fmt.Errorf("invalid prefix %s: %s", prefix, err)
+		}
+		configured[prefix] = true
+	}
+	for prefix, name := range config.PrefixTargets {
+		if !configured[prefix] {
+			return nil, fmt.Errorf("prefix target %s is not one of prefixes", prefix)
+		}
+		node, ok := config.Nodes[name]
+		if !ok {
+			return nil, fmt.Errorf("prefix target %s for %s is not a configured node", name, prefix)
+		}
+		if node.ID == config.LocalID {
+			return nil, fmt.Errorf("prefix target for %s is the local node %s", prefix, name)
+		}
+	}
+	for _, prefix := range config.RerouteDeny {
+		if !configured[prefix] {
+			return nil, fmt.Errorf("reroute-deny prefix %s is not one of prefixes", prefix)
+		}
+		if _, ok := config.PrefixTargets[prefix]; ok {
+			return nil, fmt.Errorf("prefix %s is in both reroute-deny and prefix-targets", prefix)
+		}
+	}
+	if err := checkPrefixAggregates(&config); err != nil {
+		return nil, err
+	}
+
+	if err := checkFabricOverlap(&config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// nodeLatencyThreshold returns the latency threshold of a node for an address family, which is the node's own
+// latency-threshold if set
+func (c *Config) nodeLatencyThreshold(node Node, ipv6 bool) time.Duration {
+	if node.LatencyMax != 0 {
+		return node.LatencyMax
+	}
+	return c.latencyThreshold(ipv6)
+}
+
+// nodeLossThreshold returns the loss threshold of a node, which is the node's own loss-threshold if set
+func (c *Config) nodeLossThreshold(node Node) float64 {
+	if node.LossMax != 0 {
+		return node.LossMax
+	}
+	return c.LossThreshold
+}
+
+// nodePingInterval returns how often a node is probed, which is the node's own ping-interval if set
+func (c *Config) nodePingInterval(node Node) time.Duration {
+	if node.PingInterval != 0 {
+		return node.PingInterval
+	}
+	return c.PingInterval
+}
+
+// latencyThreshold returns the latency threshold for an address family, falling back to the global threshold
+func (c *Config) latencyThreshold(ipv6 bool) time.Duration {
+	if ipv6 && c.LatencyThreshold6 != 0 {
+		return c.LatencyThreshold6
+	}
+	if !ipv6 && c.LatencyThreshold4 != 0 {
+		return c.LatencyThreshold4
+	}
+	return c.LatencyThreshold
+}
+
+// listenAddrs is a list of listen addresses that may also be given as a single scalar address
+type listenAddrs []string
+
+// UnmarshalYAML accepts either a single address or a list of addresses
+func (l *listenAddrs) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = listenAddrs{value.Value}
+		return nil
+	}
+	var addrs []string
+	if err := value.Decode(&addrs); err != nil {
+		return err
+	}
+	*l = addrs
+	return nil
+}
+
+// checkPrefixAggregates returns an error if any rerouted prefix falls outside all of the configured prefix aggregates,
+// guarding against rerouting a range this node doesn't serve
+func checkPrefixAggregates(config *Config) error {
+	if len(config.PrefixAggregates) == 0 {
+		return nil
+	}
+	var aggregates []*net.IPNet
+	for _, aggregate := range config.PrefixAggregates {
+		_, n, err := net.ParseCIDR(aggregate)
+		if err != nil {
+			return fmt.Errorf("invalid prefix aggregate %s: %s", aggregate, err)
+		}
+		aggregates = append(aggregates, n)
+	}
+	for _, prefix := range config.Prefixes {
+		_, n, err := net.ParseCIDR(prefix)
+		if err != nil {
+			return fmt.Errorf("invalid prefix %s: %s", prefix, err)
+		}
+		ones, bits := n.Mask.Size()
+		var covered bool
+		for _, aggregate := range aggregates {
+			aggOnes, aggBits := aggregate.Mask.Size()
+			if bits == aggBits && ones >= aggOnes && aggregate.Contains(n.IP) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return fmt.Errorf("prefix %s is not within any of prefix-aggregates %v", prefix, config.PrefixAggregates)
+		}
+	}
+	return nil
+}
+
+// fabricPrefixes is the internal prefixes of another fabric sharing this host
+type fabricPrefixes struct {
+	Prefix4 string `yaml:"prefix4"`
+	Prefix6 string `yaml:"prefix6"`
+}
+
+// internalNets returns the networks that internal IPs computed from prefixes fall within
+func internalNets(prefix4, prefix6 string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{internalIP(prefix4, 0, 0, 16), internalIP(prefix6, 0, 0, 96)} {
+		if _, n, err := net.ParseCIDR(cidr); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
+
+// checkInternalPrefixes returns an error if a fabric's prefixes don't form valid internal IPs, which would leave them
+// out of the overlap check
+func checkInternalPrefixes(fabric, prefix4, prefix6 string) error {
+	for _, p := range []struct {
+		key, prefix string
+		mask        uint8
+	}{{"prefix4", prefix4, 16}, {"prefix6", prefix6, 96}} {
+		if p.prefix == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(internalIP(p.prefix, 0, 0, p.mask)); err != nil {
+			return fmt.Errorf("%s %s of fabric %s doesn't form valid internal IPs", p.key, p.prefix, fabric)
+		}
+	}
+	return nil
+}
+
+// checkFabricOverlap returns an error if the internal IPs of this fabric could collide with those of another fabric
+// sharing the host, since both would assign the same addresses to their tunnels
+func checkFabricOverlap(config *Config) error {
+	if len(config.Fabrics) == 0 {
+		return nil
+	}
+	self := config.FabricName
+	if self == "" {
+		self = "default"
+	}
+	if err := checkInternalPrefixes(self, config.Prefix4, config.Prefix6); err != nil {
+		return err
+	}
+	ours := internalNets(config.Prefix4, config.Prefix6)
+	for name, fabric := range config.Fabrics {
+		if name == self {
+			return fmt.Errorf("fabric %s lists itself in fabrics", name)
+		}
+		if err := checkInternalPrefixes(name, fabric.Prefix4, fabric.Prefix6); err != nil {
+			return err
+		}
+		for _, theirs := range internalNets(fabric.Prefix4, fabric.Prefix6) {
+			for _, n := range ours {
+				if n.Contains(theirs.IP) || theirs.Contains(n.IP) {
+					return fmt.Errorf("internal prefix %s of fabric %s overlaps %s of fabric %s", n, self, theirs, name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// overlayListenAddrs returns the listen addresses that are within the overlay's internal prefixes or the rerouted
+// prefixes, coupling the control plane to the data plane it manages
+func overlayListenAddrs(config *Config) []string {
+	overlay := internalNets(config.Prefix4, config.Prefix6)
+	for _, prefix := range config.Prefixes {
+		if _, n, err := net.ParseCIDR(prefix); err == nil {
+			overlay = append(overlay, n)
+		}
+	}
+
+	var addrs []string
+	for _, addr := range append(append([]string{}, config.Listen...), config.GRPCListen) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+		host, _ = splitZone(host)
+		ip := net.ParseIP(host)
+		if ip == nil {
+			continue
+		}
+		for _, n := range overlay {
+			if n.Contains(ip) {
+				addrs = append(addrs, addr)
+				break
+			}
+		}
+	}
+	return addrs
+}
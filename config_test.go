@@ -0,0 +1,178 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testConfigYAML is a minimal valid config of a three node fabric seen from pdx1
+const testConfigYAML = `
+local-id: 10
+prefix4: "172.16"
+prefix6: "fd00::10"
+ping-interval: 1s
+latency-threshold: 100ms
+loss-threshold: 10
+listen: "127.0.0.1:8080"
+prefixes: [198.51.100.0/24]
+nodes:
+  pdx1:
+    id: 10
+    ip: 192.0.2.10
+  fmt2:
+    id: 20
+    ip: 192.0.2.20
+  sea3:
+    id: 30
+    ip: 192.0.2.30
+`
+
+// loadTestConfig loads a config from YAML written to a temporary file
+func loadTestConfig(t *testing.T, yaml string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return loadConfig(path)
+}
+
+// testConfig loads the minimal test config with extra top-level settings appended, failing the test if it is invalid.
+// Everything recorded about the config's nodes is forgotten when the test ends.
+func testConfig(t *testing.T, extra string) *Config {
+	t.Helper()
+	config, err := loadTestConfig(t, testConfigYAML+extra)
+	if err != nil {
+		t.Fatalf("loading config: %s", err)
+	}
+	t.Cleanup(func() {
+		for name := range config.Nodes {
+			forgetNode(name)
+		}
+		closestSelection.Lock()
+		closestSelection.name = ""
+		closestSelection.Unlock()
+	})
+	return config
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	config := testConfig(t, "")
+	if config.ProbeType != "icmp" {
+		t.Errorf("probe-type defaults to %s, want icmp", config.ProbeType)
+	}
+	if config.TunnelType != "gre" {
+		t.Errorf("tunnel-type defaults to %s, want gre", config.TunnelType)
+	}
+	if config.ProbeBlockedAction != "hold" {
+		t.Errorf("probe-blocked-action defaults to %s, want hold", config.ProbeBlockedAction)
+	}
+	if config.CandidateWindow != 1 || config.CandidateWindowPass != 1 {
+		t.Errorf("candidate window defaults to %d/%d, want 1/1", config.CandidateWindowPass, config.CandidateWindow)
+	}
+	if len(config.RerouteDurationBuckets) != 12 || config.RerouteDurationBuckets[0] != 60 {
+		t.Errorf("reroute-duration-buckets defaults to %v, want 12 buckets from 60s", config.RerouteDurationBuckets)
+	}
+	if config.ProbeSweepTimeout != config.PingInterval {
+		t.Errorf("probe-sweep-timeout defaults to %s, want ping-interval %s", config.ProbeSweepTimeout, config.PingInterval)
+	}
+}
+
+func TestLoadConfigRejects(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"invalid reroute-via", testConfigYAML + "reroute-via: tunnel\n", "invalid reroute-via"},
+		{"invalid failover-on-target-down", testConfigYAML + "failover-on-target-down: panic\n", "invalid failover-on-target-down"},
+		{"invalid probe-bind", testConfigYAML + "probe-bind: device\n", "invalid probe-bind"},
+		{"window pass above size", testConfigYAML + "candidate-window: 2\ncandidate-window-pass: 3\n", "candidate-window-pass 3 exceeds candidate-window 2"},
+		{"auto-revert without local health", testConfigYAML + "auto-revert: true\n", "auto-revert requires local-health-targets"},
+		{"invalid jitter-action", testConfigYAML + "jitter-action: drop\n", "invalid jitter-action"},
+		{"unknown default-reroute-target", testConfigYAML + "default-reroute-target: lax9\n", "default reroute target lax9 is not a configured node"},
+		{"prefix target not in prefixes", testConfigYAML + "prefix-targets: {203.0.113.0/24: fmt2}\n", "prefix target 203.0.113.0/24 is not one of prefixes"},
+		{"unknown prefix target", testConfigYAML + "prefix-targets: {198.51.100.0/24: lax9}\n", "prefix target lax9 for 198.51.100.0/24 is not a configured node"},
+		{"local prefix target", testConfigYAML + "prefix-targets: {198.51.100.0/24: pdx1}\n", "prefix target for 198.51.100.0/24 is the local node pdx1"},
+		{"reroute-deny not in prefixes", testConfigYAML + "reroute-deny: [203.0.113.0/24]\n", "reroute-deny prefix 203.0.113.0/24 is not one of prefixes"},
+		{"denied prefix target", testConfigYAML + "prefix-targets: {198.51.100.0/24: fmt2}\nreroute-deny: [198.51.100.0/24]\n", "prefix 198.51.100.0/24 is in both reroute-deny and prefix-targets"},
+		{"invalid reroute-preflight", testConfigYAML + "reroute-preflight: skip\n", "invalid reroute-preflight"},
+		{"invalid address-family", testConfigYAML + "address-family: ipv6\n", "invalid address-family"},
+		{"negative webhook-retries", testConfigYAML + "webhook-retries: -1\n", "webhook-retries"},
+		{"invalid probe source strategy", testConfigYAML + "probe-source-strategies: [source, tunnel]\n", "invalid probe source strategy tunnel"},
+		{"negative metric-max-nodes", testConfigYAML + "metric-max-nodes: -1\n", "metric-max-nodes"},
+		{"min-healthy-fraction above 1", testConfigYAML + "min-healthy-fraction: 1.5\n", "min-healthy-fraction must be between 0 and 1"},
+		{"invalid restart-target", testConfigYAML + "restart-target: none\n", "invalid restart-target"},
+		{"invalid latency-metric-type", testConfigYAML + "latency-metric-type: counter\n", "invalid latency-metric-type"},
+		{"exemplars without histogram", testConfigYAML + "metric-exemplars: true\nlatency-metric-type: summary\n", "metric-exemplars requires latency-metric-type histogram"},
+		{"fabric-health red above yellow", testConfigYAML + "fabric-health:\n  yellow-below: 0.5\n  red-below: 0.8\n", "red-below must not be greater than yellow-below"},
+		{"invalid fabric-health level", testConfigYAML + "fabric-health:\n  reroute-level: orange\n", "invalid fabric-health level orange"},
+		{"invalid unready-source", testConfigYAML + "unready-source: wait\n", "invalid unready-source"},
+		{"min-improvement-ratio of 1", testConfigYAML + "min-improvement-ratio: 1\n", "min-improvement-ratio must be between 0 and 1"},
+		{"invalid terminal-policy", testConfigYAML + "terminal-policy: next\n", "invalid terminal-policy"},
+		{"invalid degraded-action", testConfigYAML + "degraded-action: evict\n", "invalid degraded-action"},
+		{"degraded-loss at loss-threshold", testConfigYAML + "degraded-loss: 10\n", "degraded-loss 10.0 must be below loss-threshold 10.0"},
+		{"invalid prefix aggregate", testConfigYAML + "prefix-aggregates: [198.51.100.0]\n", "invalid prefix aggregate"},
+		{"prefix outside aggregates", testConfigYAML + "prefix-aggregates: [203.0.113.0/24]\n", "not within any of prefix-aggregates"},
+		{"sample-window too large", testConfigYAML + "sample-window: 10001\n", "sample-window must be between 1 and 10000"},
+		{"invalid failback-window", testConfigYAML + "failback-window: nightly\n", "failback-window: invalid time window nightly"},
+		{"blackout-fraction above 1", testConfigYAML + "blackout-fraction: 2\n", "blackout-fraction must be between 0 and 1"},
+		{"negative probe-grace", testConfigYAML + "probe-grace: -1s\n", "probe-grace must not be negative"},
+		{"invalid node hostname", strings.Replace(testConfigYAML, "ip: 192.0.2.20", "ip: fmt2_example.net", 1), "node fmt2 has invalid IP"},
+		{"zone on an IPv4 node ip", strings.Replace(testConfigYAML, "ip: 192.0.2.20", "ip: 192.0.2.20%lo", 1), "zone lo on IPv4"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, tt.yaml)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckFabricOverlap(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		extra   string
+		wantErr string
+	}{
+		{"no other fabrics", "", ""},
+		{"disjoint", "fabrics:\n  lab:\n    prefix4: \"172.17\"\n    prefix6: \"fd00::11\"\n", ""},
+		{"same IPv4 prefix", "fabrics:\n  lab:\n    prefix4: \"172.16\"\n    prefix6: \"fd00::11\"\n", "overlaps"},
+		{"same IPv6 prefix", "fabrics:\n  lab:\n    prefix4: \"172.17\"\n    prefix6: \"fd00::10\"\n", "overlaps"},
+		{"invalid IPv6 prefix", "fabrics:\n  lab:\n    prefix4: \"172.17\"\n    prefix6: \"fd00:0:0:11\"\n", "prefix6 fd00:0:0:11 of fabric lab"},
+		{"lists itself", "fabric-name: prod\nfabrics:\n  prod:\n    prefix4: \"172.17\"\n", "fabric prod lists itself"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, testConfigYAML+tt.extra)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckPrefixAggregates(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		prefixes   []string
+		aggregates []string
+		wantErr    bool
+	}{
+		{"no aggregates", []string{"198.51.100.0/24"}, nil, false},
+		{"equal", []string{"198.51.100.0/24"}, []string{"198.51.100.0/24"}, false},
+		{"more specific", []string{"198.51.100.128/25", "2001:db8:1::/48"}, []string{"198.51.100.0/24", "2001:db8::/32"}, false},
+		{"less specific", []string{"198.51.100.0/23"}, []string{"198.51.100.0/24"}, true},
+		{"outside", []string{"203.0.113.0/24"}, []string{"198.51.100.0/24"}, true},
+		{"other address family", []string{"2001:db8::/48"}, []string{"198.51.100.0/24"}, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPrefixAggregates(&Config{Prefixes: tt.prefixes, PrefixAggregates: tt.aggregates})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// applySweep acts on a sweep's measurements: it updates each measured node's candidacy, backoff, samples and metrics,
+// unless the cycle looks like a probe blackout or blocked probes. referencesFailed reports whether the local health
+// reference targets failed too, or is true if none are configured. prober is used to check a failing node's underlay
+// before recreating its tunnel.
+func applySweep(config *Config, prober Prober, measured map[string]sweepResult, referencesFailed bool) {
+	probed, lost := len(measured), 0
+	for _, r := range measured {
+		if r.Measurement.Err != nil || r.Measurement.Loss >= 100 {
+			lost++
+		}
+	}
+	blackout := probeBlackout(config, probed, lost)
+	// Decide whether probes are blocked before acting on any node, or the first cycle in which every probe fails
+	// would evict every candidate before the hold took effect
+	if config.ProbeBlockedAction == "hold" {
+		updateProbesBlocked(probed, lost, referencesFailed)
+	}
+
+	for name, r := range measured {
+		node, m, probeTime := config.Nodes[name], r.Measurement, r.ProbeTime
+		if config.ProbeBlockedAction == "hold" && probeTransportBlocked() {
+			log.Debugf("Probes look blocked, holding %s's candidate state", name)
+			continue
+		}
+		if blackout {
+			log.Debugf("Probe blackout, holding %s's candidate state", name)
+			continue
+		}
+		simulated := isSimulatedDown(name)
+		if simulated {
+			log.Debugf("Treating %s as down (simulated)", name)
+			m.Loss = 100
+		}
+		latency, loss, method := m.Latency, m.Loss, m.Method
+		superviseTarget(config, name, latency, loss, m.Err)
+
+		// Judge candidacy on the smoothed latency and loss, with looser thresholds for staying a candidate than
+		// for becoming one. Simulated failures bypass smoothing so they take effect at once.
+		_, wasCandidate := candidateNodes.Get(name)
+		if !simulated {
+			latency, loss = smoothMeasurement(config, name, m)
+		}
+		latencyMax, lossMax := candidateThresholds(config, node, false, wasCandidate)
+		healthy := latency <= latencyMax && loss < lossMax
+
+		// Exclude nodes with excessive jitter from candidacy or auto-selection
+		if config.JitterThreshold != 0 && m.Jitter > config.JitterThreshold {
+			reason := fmt.Sprintf("jitter %s exceeds %s", m.Jitter, config.JitterThreshold)
+			if setExclusion(name, reason) {
+				log.Infof("Excluding %s: %s", name, reason)
+				events.Add("jitter-exclude", name, reason)
+			}
+			if config.JitterAction == "evict" {
+				healthy = false
+			}
+		} else if setExclusion(name, "") {
+			events.Add("jitter-clear", name, fmt.Sprintf("jitter %s", m.Jitter))
+		}
+
+		if config.ProbeIPv6 {
+			// Judge the node on IPv4 alone until the IPv6 source is usable
+			if r.Measurement6 != nil {
+				m6 := *r.Measurement6
+				if simulated {
+					m6.Loss = 100
+				}
+				latencyMax6, lossMax6 := candidateThresholds(config, node, true, wasCandidate)
+				healthy6 := m6.Latency <= latencyMax6 && m6.Loss < lossMax6
+				if config.FamilyHealth == "any" {
+					healthy = healthy || healthy6
+				} else {
+					healthy = healthy && healthy6
+				}
+				if exportNode(config, name) {
+					metricNodeLatency6.With(nodeLabels(config, name)).Set(m6.Latency.Seconds())
+				}
+			}
+		}
+
+		// Back off on probes that go unanswered, not on nodes that answer but miss the thresholds
+		failures := recordProbeOutcome(config, name, m.Err == nil && m.Loss < 100, probeTime)
+		if (m.Err != nil || m.Loss >= 100) && !simulated && recreateDue(config, name, failures, probeTime) {
+			recreateTunnel(config, prober, name, node)
+		}
+		setReachable(name, m.Err == nil && m.Loss < 100)
+		recordSamples(config, name, m, probeTime)
+
+		// A failed tunnel keepalive or a draining peer evicts the node at once rather than waiting out the
+		// candidate window
+		evict := config.KeepaliveInterval > 0 && tunnelDown(name) || peerDraining(name)
+		if evict {
+			healthy = false
+		}
+
+		candidate := recordWindow(config, name, healthy) && !evict
+		if wasCandidate && !candidate && !evict && len(config.SecondOpinion) > 0 {
+			// The problem is likely this node's local path if a sibling can still reach the node
+			if sibling := siblingReaches(config, name); sibling != "" {
+				log.Warnf("Not evicting %s, sibling %s can still reach it", name, sibling)
+				events.Add("eviction-downgraded", name, fmt.Sprintf("reachable from %s", sibling))
+				candidate = true
+			}
+		}
+		if candidate {
+			node.Latency = latency
+			node.Jitter = m.Jitter
+			node.Loss = loss
+			log.Debugf("Adding candidate node %+v", node)
+			if candidateNodes.Set(name, node) {
+				events.Add("candidate-add", name, fmt.Sprintf("latency %s loss %.1f%%", latency, loss))
+				runNodeHook(config, name, "up", latency, loss)
+			}
+		} else {
+			if candidateNodes.Delete(name) {
+				events.Add("candidate-remove", name, fmt.Sprintf("latency %s loss %.1f%%", latency, loss))
+				runNodeHook(config, name, "down", latency, loss)
+				if name == activeTarget() {
+					// Losing the current failover destination is critical, unlike routine candidate churn
+					log.Errorf("Active reroute target %s is no longer a candidate", name)
+					metricActiveTargetLost.Inc()
+					events.Add("active-target-lost", name, fmt.Sprintf("latency %s loss %.1f%%", latency, loss))
+				}
+			}
+		}
+
+		if !warmingUp(config) {
+			metricCandidateNodes.Set(float64(candidateNodes.Len()))
+		}
+		if exportNode(config, name) {
+			metricNodeLatency.With(nodeLabels(config, name)).Set(m.Latency.Seconds())
+			metricNodeJitter.With(nodeLabels(config, name)).Set(m.Jitter.Seconds())
+			if m.Err == nil && m.Loss < 100 {
+				observeLatency(config, name, m.Latency, m.Samples, probeTime)
+			}
+			for _, m := range []string{nodeProbeType(config, node), config.ProbeFallback} {
+				if m != method {
+					metricNodeProbeMethod.DeleteLabelValues(name, m)
+				}
+			}
+			metricNodeProbeMethod.With(prometheus.Labels{
+				"dst":    name,
+				"method": method,
+			}).Set(1)
+		}
+	}
+	updateClosestSelection(config)
+}
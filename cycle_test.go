@@ -0,0 +1,193 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// lostSweep returns a sweep in which every probe to the given nodes was lost
+func lostSweep(names ...string) map[string]sweepResult {
+	measured := map[string]sweepResult{}
+	for _, name := range names {
+		measured[name] = sweepResult{Measurement: measurement{probeResult: probeResult{Loss: 100}}}
+	}
+	return measured
+}
+
+// answeredSweep returns a sweep in which the given nodes answered every probe at a latency
+func answeredSweep(latency time.Duration, names ...string) map[string]sweepResult {
+	measured := map[string]sweepResult{}
+	for _, name := range names {
+		measured[name] = sweepResult{Measurement: measurement{probeResult: probeResult{Latency: latency}}}
+	}
+	return measured
+}
+
+func TestApplySweepAllLoss(t *testing.T) {
+	for _, tt := range []struct {
+		action         string
+		wantCandidates int
+		wantBlocked    bool
+	}{
+		{"hold", 2, true},
+		{"off", 0, false},
+	} {
+		t.Run(tt.action, func(t *testing.T) {
+			config := testConfig(t, "probe-blocked-action: "+tt.action+"\n")
+			t.Cleanup(func() { atomic.StoreInt32(&probesBlocked, 0) })
+			candidateNodes.Set("fmt2", config.Nodes["fmt2"])
+			candidateNodes.Set("sea3", config.Nodes["sea3"])
+
+			// The very first all-loss cycle must already hold the candidates
+			applySweep(config, nil, lostSweep("fmt2", "sea3"), true)
+
+			if got := candidateNodes.Len(); got != tt.wantCandidates {
+				t.Errorf("%d candidates after an all-loss cycle, want %d", got, tt.wantCandidates)
+			}
+			if got := probeTransportBlocked(); got != tt.wantBlocked {
+				t.Errorf("probes blocked is %t, want %t", got, tt.wantBlocked)
+			}
+		})
+	}
+}
+
+func TestApplySweepAllLossWithReachableReferences(t *testing.T) {
+	config := testConfig(t, "")
+	t.Cleanup(func() { atomic.StoreInt32(&probesBlocked, 0) })
+	candidateNodes.Set("fmt2", config.Nodes["fmt2"])
+	candidateNodes.Set("sea3", config.Nodes["sea3"])
+
+	// Reference targets still answering means the nodes are really down
+	applySweep(config, nil, lostSweep("fmt2", "sea3"), false)
+
+	if probeTransportBlocked() {
+		t.Error("probes considered blocked although the reference targets answered")
+	}
+	if got := candidateNodes.Len(); got != 0 {
+		t.Errorf("%d candidates left, want 0", got)
+	}
+}
+
+func TestApplySweepSingleNodeLossIsNotBlocked(t *testing.T) {
+	config := testConfig(t, "")
+	t.Cleanup(func() { atomic.StoreInt32(&probesBlocked, 0) })
+	candidateNodes.Set("fmt2", config.Nodes["fmt2"])
+
+	applySweep(config, nil, lostSweep("fmt2"), true)
+
+	if probeTransportBlocked() {
+		t.Error("a single lost node was taken for blocked probes")
+	}
+	if _, ok := candidateNodes.Get("fmt2"); ok {
+		t.Error("lost node is still a candidate")
+	}
+}
+
+func TestApplySweepSimulatedDown(t *testing.T) {
+	config := testConfig(t, "allow-simulation: true\n")
+	applySweep(config, nil, answeredSweep(20*time.Millisecond, "fmt2", "sea3"), false)
+	if got := candidateNodes.Len(); got != 2 {
+		t.Fatalf("%d candidates, want 2", got)
+	}
+
+	// A simulated failure evicts the node at once although it still answers
+	setSimulatedDown("fmt2", true)
+	applySweep(config, nil, answeredSweep(20*time.Millisecond, "fmt2", "sea3"), false)
+	if _, ok := candidateNodes.Get("fmt2"); ok {
+		t.Error("node simulated down is still a candidate")
+	}
+	if _, ok := candidateNodes.Get("sea3"); !ok {
+		t.Error("other node was evicted too")
+	}
+
+	setSimulatedDown("fmt2", false)
+	applySweep(config, nil, answeredSweep(20*time.Millisecond, "fmt2", "sea3"), false)
+	if _, ok := candidateNodes.Get("fmt2"); !ok {
+		t.Error("node is not a candidate again after clearing the simulation")
+	}
+}
+
+func TestApplySweepJitter(t *testing.T) {
+	jittery := func() map[string]sweepResult {
+		measured := answeredSweep(20*time.Millisecond, "fmt2", "sea3")
+		r := measured["fmt2"]
+		r.Measurement.Jitter = 30 * time.Millisecond
+		measured["fmt2"] = r
+		return measured
+	}
+	for _, tt := range []struct {
+		action        string
+		wantCandidate bool
+	}{
+		{"evict", false},
+		{"deselect", true},
+	} {
+		t.Run(tt.action, func(t *testing.T) {
+			config := testConfig(t, "jitter-threshold: 10ms\njitter-action: "+tt.action+"\n")
+			applySweep(config, nil, jittery(), false)
+
+			if _, ok := candidateNodes.Get("fmt2"); ok != tt.wantCandidate {
+				t.Errorf("jittery node candidate %t, want %t", ok, tt.wantCandidate)
+			}
+			exclusions.Lock()
+			reason := exclusions.reasons["fmt2"]
+			exclusions.Unlock()
+			if reason == "" {
+				t.Error("jittery node has no exclusion reason")
+			}
+			// Either way the jittery node is never selected
+			if _, name := closestNode(config, ""); name != "sea3" {
+				t.Errorf("closest node is %q, want sea3", name)
+			}
+		})
+	}
+}
+
+func TestApplySweepActiveTargetLost(t *testing.T) {
+	config := testConfig(t, "failover-on-target-down: hold\n")
+	activeReroute(t, "fmt2")
+	candidateNodes.Set("fmt2", config.Nodes["fmt2"])
+	candidateNodes.Set("sea3", config.Nodes["sea3"])
+	lost := testutil.ToFloat64(metricActiveTargetLost)
+
+	// Evicting another node is routine churn
+	sweep := answeredSweep(20*time.Millisecond, "fmt2")
+	sweep["sea3"] = lostSweep("sea3")["sea3"]
+	applySweep(config, nil, sweep, false)
+	if got := testutil.ToFloat64(metricActiveTargetLost) - lost; got != 0 {
+		t.Errorf("active target lost counted %.0f times after evicting another node, want 0", got)
+	}
+
+	applySweep(config, nil, lostSweep("fmt2"), false)
+	if got := testutil.ToFloat64(metricActiveTargetLost) - lost; got != 1 {
+		t.Errorf("active target lost counted %.0f times, want 1", got)
+	}
+}
+
+func TestApplySweepProbeMethodCap(t *testing.T) {
+	config := testConfig(t, "metric-max-nodes: 1\n")
+	resetExportedNodes(t)
+	metricNodeProbeMethod.Reset()
+	t.Cleanup(metricNodeProbeMethod.Reset)
+	if !exportNode(config, "fmt2") {
+		t.Fatal("fmt2 not exported")
+	}
+
+	measured := answeredSweep(20*time.Millisecond, "fmt2", "sea3")
+	for name, r := range measured {
+		r.Measurement.Method = "icmp"
+		measured[name] = r
+	}
+	applySweep(config, nil, measured, false)
+
+	// Only the node within metric-max-nodes has a probe method series
+	if got := testutil.CollectAndCount(metricNodeProbeMethod); got != 1 {
+		t.Errorf("%d probe method series, want 1", got)
+	}
+	if got := testutil.ToFloat64(metricNodeProbeMethod.WithLabelValues("fmt2", "icmp")); got != 1 {
+		t.Errorf("fmt2 probe method is %.0f, want 1", got)
+	}
+}
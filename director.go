@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Director exposes the control operations shared by the HTTP and gRPC APIs
+type Director struct {
+	config *Config
+	probes *probeSet
+}
+
+// errUnknownNode is wrapped by errors for requests naming a node that isn't configured
+var errUnknownNode = errors.New("unknown node")
+
+// routeChangeError wraps a failure to change routes, as opposed to a request refused before any route was touched
+type routeChangeError struct {
+	err error
+}
+
+func (e routeChangeError) Error() string { return e.err.Error() }
+func (e routeChangeError) Unwrap() error { return e.err }
+
+// rerouteResult describes a completed or refused reroute request
+type rerouteResult struct {
+	Target    string `json:"target,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Preflight string `json:"preflight,omitempty"` // Preflight outcome, empty if preflight is disabled
+}
+
+// namedNode is a candidate node with its name
+type namedNode struct {
+	Name string `json:"name"`
+	Node
+}
+
+// Reroute reroutes all prefixes to the named node, or to the default target if to is empty. Unless force is set, the
+// reroute is refused if degradation looks systemic.
+func (d *Director) Reroute(to string, force bool) (*rerouteResult, error) {
+	var node *Node
+	result := &rerouteResult{Reason: "api"}
+	if panicking() {
+		return result, fmt.Errorf("panic mode active, use /unpanic first")
+	}
+	if to == "" {
+		var reason string
+		node, to, reason = defaultTarget(d.config)
+		if node == nil {
+			events.Add("selection", "", reason+", no candidate available for reroute")
+			return result, fmt.Errorf("no candidate nodes (%s)", reason)
+		}
+		events.Add("selection", to, reason)
+		result.Reason = reason
+	} else {
+		n, ok := d.config.Nodes[to]
+		if !ok {
+			return result, fmt.Errorf("%w %s", errUnknownNode, to)
+		}
+		node = &n
+	}
+	result.Target = to
+	// A target the prefixes have no nexthop to is refused before anything is probed or changed
+	if _, err := reroutePlan(d.config, to, node); err != nil {
+		return result, err
+	}
+
+	if err := degradedEverywhere(d.config); err != nil {
+		if !force {
+			events.Add("reroute-refused", to, err.Error())
+			return result, fmt.Errorf("%s, use force to override", err)
+		}
+		log.Warnf("Forcing reroute to %s despite systemic degradation: %s", to, err)
+	}
+
+	if d.config.ReroutePreflight != "" {
+		probe, err := preflightTarget(d.config, d.probes.Supervised, node)
+		if err != nil {
+			events.Add("preflight-fail", to, err.Error())
+			result.Preflight = fmt.Sprintf("failed: %s", err)
+			if d.config.ReroutePreflight == "refuse" {
+				log.Warnf("Refusing reroute to %s: preflight failed: %s", to, err)
+				return result, fmt.Errorf("preflight failed: %s", err)
+			}
+			log.Warnf("Preflight to %s failed, rerouting anyway: %s", to, err)
+		} else {
+			result.Preflight = fmt.Sprintf("passed (latency %s, loss %.1f%%)", probe.Latency, probe.Loss)
+		}
+	}
+
+	log.Debugf("Rerouting to %s %+v", to, node)
+	if err := rerouteTo(d.config, to, node, "api"); err != nil {
+		return result, routeChangeError{err}
+	}
+	return result, nil
+}
+
+// NodeName resolves a numeric node ID to the node's name
+func (d *Director) NodeName(id string) (string, error) {
+	n, err := strconv.ParseUint(id, 10, 8)
+	if err != nil {
+		return "", fmt.Errorf("invalid node ID %s", id)
+	}
+	for name, node := range d.config.Nodes {
+		if node.ID == uint8(n) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("%w ID %d", errUnknownNode, n)
+}
+
+// NoReroute withdraws the active reroute
+func (d *Director) NoReroute() error {
+	if panicking() {
+		return fmt.Errorf("panic mode active, use /unpanic")
+	}
+	if err := noReroute(d.config, "api"); err != nil {
+		return routeChangeError{err}
+	}
+	return nil
+}
+
+// Candidates returns the current candidate nodes sorted by name
+func (d *Director) Candidates() []namedNode {
+	return sortedCandidates()
+}
+
+// candidateExplanation is the selection breakdown of a candidate node
+type candidateExplanation struct {
+	Name           string  `json:"name"`
+	Latency        string  `json:"latency"`
+	Jitter         string  `json:"jitter"`
+	Loss           float64 `json:"loss"`
+	Degraded       bool    `json:"degraded"`
+	AutoSelectable bool    `json:"auto_selectable"`
+	Reason         string  `json:"reason,omitempty"` // Why the candidate can't be auto-selected
+	Score          float64 `json:"score"`            // Latency in seconds, lowest wins, degraded candidates last
+	Selected       bool    `json:"selected"`
+}
+
+// ExplainCandidates returns how automatic selection scores each candidate node, sorted by score
+func (d *Director) ExplainCandidates() []candidateExplanation {
+	return explainCandidates(d.config)
+}
+
+// explainCandidates returns how automatic selection scores each candidate node, sorted by score
+func explainCandidates(config *Config) []candidateExplanation {
+	_, selected := closestNode(config, "")
+	var explained []candidateExplanation
+	for _, c := range sortedCandidates() {
+		reason := selectionExclusion(config, c.Node)
+		explained = append(explained, candidateExplanation{
+			Name:           c.Name,
+			Latency:        c.Latency.String(),
+			Jitter:         c.Jitter.String(),
+			Loss:           c.Loss,
+			Degraded:       degraded(config, c.Node),
+			AutoSelectable: reason == "",
+			Reason:         reason,
+			Score:          c.Latency.Seconds(),
+			Selected:       c.Name == selected,
+		})
+	}
+	sort.SliceStable(explained, func(i, j int) bool { return explained[i].Score < explained[j].Score })
+	return explained
+}
+
+// Status returns a snapshot of the director's state
+func (d *Director) Status() statusResponse {
+	return currentStatus(d.config)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNodeName(t *testing.T) {
+	config := testConfig(t, "")
+	d := &Director{config: config}
+	for _, tt := range []struct {
+		id          string
+		want        string
+		wantUnknown bool
+		wantErr     bool
+	}{
+		{"20", "fmt2", false, false},
+		{"30", "sea3", false, false},
+		{"40", "", true, true},
+		{"fmt2", "", false, true},
+		{"256", "", false, true},
+		{"-1", "", false, true},
+	} {
+		t.Run(tt.id, func(t *testing.T) {
+			name, err := d.NodeName(tt.id)
+			if name != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("node %q error %v, want %q and error %t", name, err, tt.want, tt.wantErr)
+			}
+			if errors.Is(err, errUnknownNode) != tt.wantUnknown {
+				t.Errorf("error %v is unknown node %t, want %t", err, errors.Is(err, errUnknownNode), tt.wantUnknown)
+			}
+		})
+	}
+}
+
+func TestExplainCandidates(t *testing.T) {
+	config := testConfig(t, "reroute-max-latency: 50ms\n")
+	d := &Director{config: config}
+	latencies := map[string]time.Duration{"fmt2": 80 * time.Millisecond, "sea3": 20 * time.Millisecond}
+	for name, latency := range latencies {
+		node := config.Nodes[name]
+		node.Latency = latency
+		candidateNodes.Set(name, node)
+	}
+
+	explained := d.ExplainCandidates()
+	var names []string
+	for _, c := range explained {
+		names = append(names, c.Name)
+		if c.Name == "fmt2" && (c.AutoSelectable || c.Reason == "") {
+			t.Errorf("fmt2 %+v, want excluded by reroute-max-latency", c)
+		}
+		if c.Name != "fmt2" && !c.AutoSelectable {
+			t.Errorf("%s excluded: %s", c.Name, c.Reason)
+		}
+		if c.Selected != (c.Name == "sea3") {
+			t.Errorf("%s selected %t", c.Name, c.Selected)
+		}
+	}
+	if len(names) != 2 || names[0] != "sea3" || names[1] != "fmt2" {
+		t.Errorf("candidates ordered %v, want sorted by latency", names)
+	}
+}
@@ -0,0 +1,228 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v3.21.12
+// source: director.proto
+
+package directorpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Director_Reroute_FullMethodName    = "/fabricdirector.Director/Reroute"
+	Director_NoReroute_FullMethodName  = "/fabricdirector.Director/NoReroute"
+	Director_Candidates_FullMethodName = "/fabricdirector.Director/Candidates"
+	Director_Status_FullMethodName     = "/fabricdirector.Director/Status"
+)
+
+// DirectorClient is the client API for Director service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DirectorClient interface {
+	// Reroute reroutes all prefixes to a node, or to the default target if to is empty
+	Reroute(ctx context.Context, in *RerouteRequest, opts ...grpc.CallOption) (*RerouteResponse, error)
+	// NoReroute withdraws the active reroute
+	NoReroute(ctx context.Context, in *NoRerouteRequest, opts ...grpc.CallOption) (*NoRerouteResponse, error)
+	// Candidates lists the current candidate nodes
+	Candidates(ctx context.Context, in *CandidatesRequest, opts ...grpc.CallOption) (*CandidatesResponse, error)
+	// Status returns a snapshot of the director's state
+	Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error)
+}
+
+type directorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDirectorClient(cc grpc.ClientConnInterface) DirectorClient {
+	return &directorClient{cc}
+}
+
+func (c *directorClient) Reroute(ctx context.Context, in *RerouteRequest, opts ...grpc.CallOption) (*RerouteResponse, error) {
+	out := new(RerouteResponse)
+	err := c.cc.Invoke(ctx, Director_Reroute_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *directorClient) NoReroute(ctx context.Context, in *NoRerouteRequest, opts ...grpc.CallOption) (*NoRerouteResponse, error) {
+	out := new(NoRerouteResponse)
+	err := c.cc.Invoke(ctx, Director_NoReroute_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *directorClient) Candidates(ctx context.Context, in *CandidatesRequest, opts ...grpc.CallOption) (*CandidatesResponse, error) {
+	out := new(CandidatesResponse)
+	err := c.cc.Invoke(ctx, Director_Candidates_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *directorClient) Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error) {
+	out := new(StatusResponse)
+	err := c.cc.Invoke(ctx, Director_Status_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DirectorServer is the server API for Director service.
+// All implementations must embed UnimplementedDirectorServer
+// for forward compatibility
+type DirectorServer interface {
+	// Reroute reroutes all prefixes to a node, or to the default target if to is empty
+	Reroute(context.Context, *RerouteRequest) (*RerouteResponse, error)
+	// NoReroute withdraws the active reroute
+	NoReroute(context.Context, *NoRerouteRequest) (*NoRerouteResponse, error)
+	// Candidates lists the current candidate nodes
+	Candidates(context.Context, *CandidatesRequest) (*CandidatesResponse, error)
+	// Status returns a snapshot of the director's state
+	Status(context.Context, *StatusRequest) (*StatusResponse, error)
+	mustEmbedUnimplementedDirectorServer()
+}
+
+// UnimplementedDirectorServer must be embedded to have forward compatible implementations.
+type UnimplementedDirectorServer struct {
+}
+
+func (UnimplementedDirectorServer) Reroute(context.Context, *RerouteRequest) (*RerouteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reroute not implemented")
+}
+func (UnimplementedDirectorServer) NoReroute(context.Context, *NoRerouteRequest) (*NoRerouteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method NoReroute not implemented")
+}
+func (UnimplementedDirectorServer) Candidates(context.Context, *CandidatesRequest) (*CandidatesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Candidates not implemented")
+}
+func (UnimplementedDirectorServer) Status(context.Context, *StatusRequest) (*StatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Status not implemented")
+}
+func (UnimplementedDirectorServer) mustEmbedUnimplementedDirectorServer() {}
+
+// UnsafeDirectorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DirectorServer will
+// result in compilation errors.
+type UnsafeDirectorServer interface {
+	mustEmbedUnimplementedDirectorServer()
+}
+
+func RegisterDirectorServer(s grpc.ServiceRegistrar, srv DirectorServer) {
+	s.RegisterService(&Director_ServiceDesc, srv)
+}
+
+func _Director_Reroute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RerouteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DirectorServer).Reroute(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Director_Reroute_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DirectorServer).Reroute(ctx, req.(*RerouteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Director_NoReroute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NoRerouteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DirectorServer).NoReroute(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Director_NoReroute_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DirectorServer).NoReroute(ctx, req.(*NoRerouteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Director_Candidates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CandidatesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DirectorServer).Candidates(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Director_Candidates_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DirectorServer).Candidates(ctx, req.(*CandidatesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Director_Status_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DirectorServer).Status(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Director_Status_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DirectorServer).Status(ctx, req.(*StatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Director_ServiceDesc is the grpc.ServiceDesc for Director service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Director_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "fabricdirector.Director",
+	HandlerType: (*DirectorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Reroute",
+			Handler:    _Director_Reroute_Handler,
+		},
+		{
+			MethodName: "NoReroute",
+			Handler:    _Director_NoReroute_Handler,
+		},
+		{
+			MethodName: "Candidates",
+			Handler:    _Director_Candidates_Handler,
+		},
+		{
+			MethodName: "Status",
+			Handler:    _Director_Status_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "director.proto",
+}
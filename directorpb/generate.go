@@ -0,0 +1,4 @@
+// Package directorpb contains the generated gRPC bindings for the director control API
+package directorpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative director.proto
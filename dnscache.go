@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// dnsEntry is a cached resolution of a node hostname
+type dnsEntry struct {
+	addr       string
+	expires    time.Time
+	refreshing bool
+}
+
+// dnsCache holds the resolved addresses of node hostnames. Entries are served until refreshed, even after expiry, so
+// a slow or failing resolver never stalls tunnel maintenance or a probe cycle.
+var dnsCache = struct {
+	sync.Mutex
+	entries  map[string]*dnsEntry
+	ttl      time.Duration
+	onChange func(host, previous, addr string)
+}{entries: map[string]*dnsEntry{}}
+
+var hostnameRegex = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*\.?$`)
+
+// isHostname returns true if a node address is a hostname rather than an IP literal
+func isHostname(addr string) bool {
+	host, _ := splitZone(addr)
+	return net.ParseIP(host) == nil
+}
+
+// lookupHost resolves a hostname to a single address, preferring IPv4 so the choice is stable across lookups
+func lookupHost(host string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return "", err
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("no addresses for %s", host)
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip.String(), nil
+		}
+	}
+	return ips[0].String(), nil
+}
+
+// primeDNSCache resolves every node hostname so the first plan and probe cycle have addresses, and sets how long
+// resolutions are cached and what happens when one changes
+func primeDNSCache(config *Config, onChange func(host, previous, addr string)) error {
+	dnsCache.Lock()
+	dnsCache.ttl = config.DNSTTL
+	dnsCache.onChange = onChange
+	dnsCache.Unlock()
+	for name, node := range config.Nodes {
+		if !isHostname(node.IP) {
+			continue
+		}
+		addr, err := lookupHost(node.IP)
+		if err != nil {
+			return fmt.Errorf("error resolving node %s: %s", name, err)
+		}
+		log.Infof("Resolved node %s (%s) to %s", name, node.IP, addr)
+		dnsCache.Lock()
+		dnsCache.entries[node.IP] = &dnsEntry{addr: addr, expires: time.Now().Add(config.DNSTTL)}
+		dnsCache.Unlock()
+	}
+	return nil
+}
+
+// nodeIP returns the address to use for a node's ip, which is either an IP literal returned unchanged or a hostname
+// resolved through the cache. An expired entry is returned as is while it's refreshed in the background.
+func nodeIP(addr string) string {
+	if !isHostname(addr) {
+		return addr
+	}
+	dnsCache.Lock()
+	defer dnsCache.Unlock()
+	entry, ok := dnsCache.entries[addr]
+	if !ok {
+		// Not primed, e.g. for a config loaded without starting the director
+		return addr
+	}
+	if time.Now().After(entry.expires) && !entry.refreshing {
+		entry.refreshing = true
+		go refreshHost(addr)
+	}
+	return entry.addr
+}
+
+// refreshHost re-resolves a cached hostname, keeping the previous address if the lookup fails
+func refreshHost(host string) {
+	addr, err := lookupHost(host)
+
+	dnsCache.Lock()
+	entry := dnsCache.entries[host]
+	entry.refreshing = false
+	previous := entry.addr
+	if err != nil {
+		// Retry on the next use rather than waiting out another TTL with a possibly stale address
+		dnsCache.Unlock()
+		log.Warnf("Error refreshing %s, keeping %s: %s", host, previous, err)
+		return
+	}
+	entry.addr = addr
+	entry.expires = time.Now().Add(dnsCache.ttl)
+	onChange := dnsCache.onChange
+	dnsCache.Unlock()
+
+	if addr != previous {
+		log.Infof("Resolution of %s changed from %s to %s", host, previous, addr)
+		events.Add("dns-change", host, fmt.Sprintf("%s -> %s", previous, addr))
+		if onChange != nil {
+			onChange(host, previous, addr)
+		}
+	}
+}
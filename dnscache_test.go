@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// resetDNSCache empties the DNS cache when the test ends
+func resetDNSCache(t *testing.T) {
+	t.Cleanup(func() {
+		dnsCache.Lock()
+		defer dnsCache.Unlock()
+		dnsCache.entries = map[string]*dnsEntry{}
+		dnsCache.ttl, dnsCache.onChange = 0, nil
+	})
+}
+
+func TestNodeIP(t *testing.T) {
+	resetDNSCache(t)
+	dnsCache.Lock()
+	dnsCache.entries["fmt2.example.net"] = &dnsEntry{addr: "192.0.2.20", expires: time.Now().Add(time.Minute)}
+	dnsCache.Unlock()
+
+	for _, tt := range []struct {
+		addr string
+		want string
+	}{
+		{"192.0.2.30", "192.0.2.30"},
+		{"fe80::30%eth0", "fe80::30%eth0"},
+		{"fmt2.example.net", "192.0.2.20"},
+		// Hostnames that were never resolved are returned unchanged
+		{"sea3.example.net", "sea3.example.net"},
+	} {
+		if got := nodeIP(tt.addr); got != tt.want {
+			t.Errorf("node IP of %s is %s, want %s", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestNodeIPRefreshesExpired(t *testing.T) {
+	if _, err := lookupHost("localhost"); err != nil {
+		t.Skipf("localhost doesn't resolve: %s", err)
+	}
+	resetDNSCache(t)
+	changes := make(chan string, 1)
+	dnsCache.Lock()
+	dnsCache.ttl = time.Minute
+	dnsCache.onChange = func(host, previous, addr string) { changes <- addr }
+	dnsCache.entries["localhost"] = &dnsEntry{addr: "192.0.2.99", expires: time.Now().Add(-time.Second)}
+	dnsCache.Unlock()
+
+	// The stale address is served while the refresh runs in the background
+	if got := nodeIP("localhost"); got != "192.0.2.99" {
+		t.Errorf("node IP %s during the refresh, want the cached 192.0.2.99", got)
+	}
+	select {
+	case addr := <-changes:
+		if got := nodeIP("localhost"); got != addr {
+			t.Errorf("node IP %s after the refresh, want %s", got, addr)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("expired entry wasn't refreshed")
+	}
+	dnsCache.Lock()
+	expires := dnsCache.entries["localhost"].expires
+	dnsCache.Unlock()
+	if time.Until(expires) < 50*time.Second {
+		t.Errorf("refreshed entry expires in %s, want dns-ttl", time.Until(expires))
+	}
+}
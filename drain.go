@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// draining is set while this node drains before shutdown
+var draining int32
+
+// drainingPeers holds the names of peers whose /matrix view reports they are draining
+var drainingPeers = struct {
+	sync.Mutex
+	nodes map[string]bool
+}{nodes: map[string]bool{}}
+
+// isDraining returns true if this node is draining before shutdown
+func isDraining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+// peerDraining returns true if a peer reported that it is draining the last time the matrix was collected
+func peerDraining(name string) bool {
+	drainingPeers.Lock()
+	defer drainingPeers.Unlock()
+	return drainingPeers.nodes[name]
+}
+
+// setPeerDraining records whether a peer reported that it is draining
+func setPeerDraining(name string, peerDraining bool) {
+	drainingPeers.Lock()
+	defer drainingPeers.Unlock()
+	if peerDraining && !drainingPeers.nodes[name] {
+		log.Infof("Peer %s is draining, evicting it from candidates", name)
+		events.Add("peer-draining", name, "")
+	}
+	if peerDraining {
+		drainingPeers.nodes[name] = true
+	} else {
+		delete(drainingPeers.nodes, name)
+	}
+}
+
+// drain stops advertising this node as a reroute target to its siblings and waits up to drain-timeout for them to
+// move traffic away, returning early if another signal arrives
+func drain(config *Config, sigs <-chan os.Signal) {
+	log.Infof("Draining: advertising as draining to siblings for up to %s", config.DrainTimeout)
+	events.Add("drain", localNodeName, "")
+	atomic.StoreInt32(&draining, 1)
+	select {
+	case <-time.After(config.DrainTimeout):
+		log.Info("Drain complete")
+	case sig := <-sigs:
+		log.Warnf("Received %s while draining, shutting down now", sig)
+	}
+}
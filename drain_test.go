@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDrainAdvertisedUntilSignal(t *testing.T) {
+	config := testConfig(t, "drain-timeout: 1m\n")
+	t.Cleanup(func() { atomic.StoreInt32(&draining, 0) })
+	if localMatrixView().Draining {
+		t.Fatal("matrix view reports draining before the drain")
+	}
+
+	sigs := make(chan os.Signal, 1)
+	sigs <- syscall.SIGTERM
+	done := make(chan struct{})
+	go func() {
+		drain(config, sigs)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain didn't return on a second signal")
+	}
+
+	if !isDraining() || !localMatrixView().Draining {
+		t.Error("matrix view doesn't report draining")
+	}
+}
+
+func TestApplySweepEvictsDrainingPeer(t *testing.T) {
+	config := testConfig(t, "candidate-window: 3\ncandidate-window-pass: 1\n")
+	candidateNodes.Set("fmt2", config.Nodes["fmt2"])
+	candidateNodes.Set("sea3", config.Nodes["sea3"])
+
+	// A draining peer is evicted at once even though it still answers probes
+	setPeerDraining("fmt2", true)
+	applySweep(config, nil, answeredSweep(20*time.Millisecond, "fmt2", "sea3"), true)
+	if _, ok := candidateNodes.Get("fmt2"); ok {
+		t.Error("draining peer is still a candidate")
+	}
+	if _, ok := candidateNodes.Get("sea3"); !ok {
+		t.Error("healthy peer was evicted")
+	}
+
+	setPeerDraining("fmt2", false)
+	applySweep(config, nil, answeredSweep(20*time.Millisecond, "fmt2", "sea3"), true)
+	if _, ok := candidateNodes.Get("fmt2"); !ok {
+		t.Error("peer isn't a candidate again after it stopped draining")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// reconcileDiff is what reconciliation would change at startup, as printed by -reconcile-dry-run
+type reconcileDiff struct {
+	Tunnels []tunnelAction   `json:"tunnels"`
+	Routes  []installedRoute `json:"routes"`
+	Restore string           `json:"restore,omitempty"` // Target of a persisted reroute that would be restored
+}
+
+// previewReconcile computes the tunnel actions and route drift reconciliation would act on, without changing anything.
+// Routes are classified against the persisted reroute, since that is what the director would restore.
+func previewReconcile(config *Config, p *plan, restore *persistedState) (*reconcileDiff, error) {
+	var diff reconcileDiff
+	var err error
+	if diff.Tunnels, err = diffTunnels(config, p); err != nil {
+		return nil, err
+	}
+	if config.TeardownOnStart {
+		for i, a := range diff.Tunnels {
+			if a.Action == "keep" || a.Action == "correct" || a.Action == "recreate" {
+				diff.Tunnels[i].Action = "recreate"
+				diff.Tunnels[i].Detail = "teardown-on-start"
+			}
+		}
+	}
+	if restore != nil && restore.Active {
+		diff.Restore = restore.Target
+	}
+	if diff.Routes, err = classifyRoutes(config, diff.Restore); err != nil {
+		return nil, err
+	}
+	return &diff, nil
+}
+
+// printReconcileDiff prints a reconciliation preview as JSON or as one line per change
+func printReconcileDiff(diff *reconcileDiff, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diff)
+	}
+	var changes int
+	for _, a := range diff.Tunnels {
+		if a.Action == "keep" {
+			fmt.Printf("  keep      %s\n", a.Interface)
+			continue
+		}
+		changes++
+		fmt.Printf("  %-9s %s: %s\n", a.Action, a.Interface, a.Detail)
+	}
+	for _, r := range diff.Routes {
+		if r.Owned {
+			fmt.Printf("  route     %s via %s dev %s\n", r.Prefix, r.Gateway, r.Device)
+			continue
+		}
+		changes++
+		fmt.Printf("  drift     route %s via %s dev %s: %s\n", r.Prefix, r.Gateway, r.Device, r.Reason)
+	}
+	if diff.Restore != "" {
+		fmt.Printf("  restore   reroute to %s once candidates are known\n", diff.Restore)
+	}
+	fmt.Printf("%d tunnels, %d changes or drift\n", len(diff.Tunnels), changes)
+	return nil
+}
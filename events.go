@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// event is a single health change or reroute decision
+type event struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Node   string    `json:"node,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// eventLog is a fixed size ring buffer of the most recent events
+type eventLog struct {
+	sync.Mutex
+	events []event
+	next   int
+	full   bool
+}
+
+var events = newEventLog(100)
+
+// newEventLog creates an event log holding up to size events
+func newEventLog(size int) *eventLog {
+	if size < 1 {
+		size = 1
+	}
+	return &eventLog{events: make([]event, size)}
+}
+
+// Add records an event, overwriting the oldest event if the log is full
+func (l *eventLog) Add(eventType, node, reason string) {
+	log.Debugf("Event %s node=%s reason=%s", eventType, node, reason)
+	l.Lock()
+	defer l.Unlock()
+	l.events[l.next] = event{
+		Time:   time.Now(),
+		Type:   eventType,
+		Node:   node,
+		Reason: reason,
+	}
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Snapshot returns a copy of the logged events, oldest first
+func (l *eventLog) Snapshot() []event {
+	l.Lock()
+	defer l.Unlock()
+	if !l.full {
+		return append([]event{}, l.events[:l.next]...)
+	}
+	return append(append([]event{}, l.events[l.next:]...), l.events[:l.next]...)
+}
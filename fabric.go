@@ -0,0 +1,62 @@
+package main
+
+// fabricHealthConfig sets the thresholds of the /fabric/health roll-up
+type fabricHealthConfig struct {
+	YellowBelow        float64 `yaml:"yellow-below"`         // Healthy fraction below which the fabric is yellow
+	RedBelow           float64 `yaml:"red-below"`            // Healthy fraction below which the fabric is red
+	RerouteLevel       string  `yaml:"reroute-level"`        // Level while a reroute is active
+	LocalDegradedLevel string  `yaml:"local-degraded-level"` // Level while local health is degraded
+}
+
+// healthLevels orders the fabric health levels from best to worst
+var healthLevels = map[string]int{"green": 0, "yellow": 1, "red": 2}
+
+// fabricHealthResponse is the JSON body of the /fabric/health endpoint
+type fabricHealthResponse struct {
+	Status          string   `json:"status"`
+	HealthyFraction float64  `json:"healthy_fraction"`
+	Rerouting       bool     `json:"rerouting"`
+	LocalDegraded   bool     `json:"local_degraded"`
+	Factors         []string `json:"factors,omitempty"` // Conditions that raised the status above green
+}
+
+// worseLevel returns the worse of two health levels
+func worseLevel(a, b string) string {
+	if healthLevels[b] > healthLevels[a] {
+		return b
+	}
+	return a
+}
+
+// fabricHealth rolls up the healthy candidate fraction, reroute state and local health into a single level
+func fabricHealth(config *Config) fabricHealthResponse {
+	thresholds := config.FabricHealth
+	health := fabricHealthResponse{
+		Status:          "green",
+		HealthyFraction: healthyFraction(config),
+	}
+
+	switch {
+	case health.HealthyFraction < thresholds.RedBelow:
+		health.Status = "red"
+		health.Factors = append(health.Factors, "healthy fraction below red threshold")
+	case health.HealthyFraction < thresholds.YellowBelow:
+		health.Status = "yellow"
+		health.Factors = append(health.Factors, "healthy fraction below yellow threshold")
+	}
+
+	reroute.Lock()
+	health.Rerouting = reroute.Active
+	reroute.Unlock()
+	if health.Rerouting {
+		health.Status = worseLevel(health.Status, thresholds.RerouteLevel)
+		health.Factors = append(health.Factors, "reroute active")
+	}
+
+	if local := currentLocalHealth(config); local != nil && !local.Updated.IsZero() && !local.Healthy {
+		health.LocalDegraded = true
+		health.Status = worseLevel(health.Status, thresholds.LocalDegradedLevel)
+		health.Factors = append(health.Factors, "local health degraded")
+	}
+	return health
+}
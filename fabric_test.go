@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFabricHealth(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		extra         string
+		candidates    []string
+		rerouting     bool
+		localDegraded bool
+		want          string
+	}{
+		{"all healthy", "", []string{"fmt2", "sea3"}, false, false, "green"},
+		{"half healthy", "", []string{"fmt2"}, false, false, "yellow"},
+		{"none healthy", "", nil, false, false, "red"},
+		{"lower thresholds", "fabric-health:\n  yellow-below: 0.5\n  red-below: 0.25\n", []string{"fmt2"}, false, false, "green"},
+		{"rerouting", "", []string{"fmt2", "sea3"}, true, false, "yellow"},
+		{"rerouting at red level", "fabric-health:\n  reroute-level: red\n", []string{"fmt2", "sea3"}, true, false, "red"},
+		{"rerouting doesn't improve red", "", nil, true, false, "red"},
+		{"local degraded", "local-health-targets: [192.0.2.1]\n", []string{"fmt2", "sea3"}, false, true, "red"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, tt.extra)
+			for _, name := range tt.candidates {
+				candidateNodes.Set(name, config.Nodes[name])
+			}
+			if tt.rerouting {
+				activeReroute(t, "fmt2")
+			}
+			if tt.localDegraded {
+				local.Lock()
+				local.health = localHealth{Healthy: false, Loss: 100, Updated: time.Now()}
+				local.Unlock()
+				t.Cleanup(func() {
+					local.Lock()
+					local.health = localHealth{}
+					local.Unlock()
+				})
+			}
+
+			health := fabricHealth(config)
+			if health.Status != tt.want {
+				t.Errorf("status %s (%v), want %s", health.Status, health.Factors, tt.want)
+			}
+			if health.Rerouting != tt.rerouting || health.LocalDegraded != tt.localDegraded {
+				t.Errorf("rerouting %t local degraded %t, want %t and %t", health.Rerouting, health.LocalDegraded, tt.rerouting, tt.localDegraded)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timeWindow is a daily time-of-day range in the host's local time, as offsets from midnight. The window wraps past
+// midnight if End is before Start.
+type timeWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// parseTimeWindow parses a time-of-day range such as 02:00-04:30
+func parseTimeWindow(s string) (timeWindow, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return timeWindow{}, fmt.Errorf("invalid time window %s (must be HH:MM-HH:MM)", s)
+	}
+	var offsets [2]time.Duration
+	for i, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return timeWindow{}, fmt.Errorf("invalid time window %s (must be HH:MM-HH:MM)", s)
+		}
+		offsets[i] = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	if offsets[0] == offsets[1] {
+		return timeWindow{}, fmt.Errorf("invalid time window %s (start and end are equal)", s)
+	}
+	return timeWindow{Start: offsets[0], End: offsets[1]}, nil
+}
+
+// next returns t if it falls within the window, otherwise the start of the next window after t
+func (w timeWindow) next(t time.Time) time.Time {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	inside := offset >= w.Start && offset < w.End
+	if w.End < w.Start {
+		inside = offset >= w.Start || offset < w.End
+	}
+	if inside {
+		return t
+	}
+	start := midnight.Add(w.Start)
+	if !start.After(t) {
+		start = midnight.AddDate(0, 0, 1).Add(w.Start)
+	}
+	return start
+}
+
+// failbackAt returns when a revert pending since the given time may happen: once revert-hold has elapsed, and only
+// within failback-window if one is set
+func failbackAt(config *Config, pendingSince, now time.Time) time.Time {
+	at := pendingSince.Add(config.RevertHold)
+	if at.Before(now) {
+		at = now
+	}
+	if config.FailbackWindow == "" {
+		return at
+	}
+	// Validated by loadConfig
+	window, _ := parseTimeWindow(config.FailbackWindow)
+	return window.next(at)
+}
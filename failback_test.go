@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeWindow(t *testing.T) {
+	for _, tt := range []struct {
+		s       string
+		want    timeWindow
+		wantErr bool
+	}{
+		{"02:00-04:30", timeWindow{Start: 2 * time.Hour, End: 4*time.Hour + 30*time.Minute}, false},
+		{"22:00 - 02:00", timeWindow{Start: 22 * time.Hour, End: 2 * time.Hour}, false},
+		{"02:00", timeWindow{}, true},
+		{"02:00-25:00", timeWindow{}, true},
+		{"2am-4am", timeWindow{}, true},
+		{"03:00-03:00", timeWindow{}, true},
+	} {
+		got, err := parseTimeWindow(tt.s)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%q parsed as %+v error %v, want %+v error %t", tt.s, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestTimeWindowNext(t *testing.T) {
+	day := func(hour, minute int) time.Time { return time.Date(2026, 3, 10, hour, minute, 0, 0, time.UTC) }
+	nextDay := func(hour, minute int) time.Time { return day(hour, minute).AddDate(0, 0, 1) }
+	night := timeWindow{Start: 2 * time.Hour, End: 4 * time.Hour}
+	wrapping := timeWindow{Start: 22 * time.Hour, End: 2 * time.Hour}
+	for _, tt := range []struct {
+		name   string
+		window timeWindow
+		t      time.Time
+		want   time.Time
+	}{
+		{"before", night, day(1, 0), day(2, 0)},
+		{"at start", night, day(2, 0), day(2, 0)},
+		{"inside", night, day(3, 15), day(3, 15)},
+		{"at end", night, day(4, 0), nextDay(2, 0)},
+		{"after", night, day(12, 0), nextDay(2, 0)},
+		{"wrapping before", wrapping, day(12, 0), day(22, 0)},
+		{"wrapping late", wrapping, day(23, 0), day(23, 0)},
+		{"wrapping early", wrapping, day(1, 0), day(1, 0)},
+		{"wrapping after end", wrapping, day(2, 0), day(22, 0)},
+	} {
+		if got := tt.window.next(tt.t); !got.Equal(tt.want) {
+			t.Errorf("%s: next of %s is %s, want %s", tt.name, tt.t.Format("15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestFailbackAt(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	config := testConfig(t, "revert-hold: 10m\n")
+	if got, want := failbackAt(config, now, now), now.Add(10*time.Minute); !got.Equal(want) {
+		t.Errorf("failback at %s without a window, want %s", got, want)
+	}
+	// A revert whose hold elapsed long ago happens now
+	if got := failbackAt(config, now.Add(-time.Hour), now); !got.Equal(now) {
+		t.Errorf("failback at %s after the hold, want now", got)
+	}
+
+	config = testConfig(t, "revert-hold: 10m\nfailback-window: 02:00-04:00\n")
+	want := time.Date(2026, 3, 11, 2, 0, 0, 0, time.Local)
+	if got := failbackAt(config, now, now); !got.Equal(want) {
+		t.Errorf("failback at %s with a window, want %s", got, want)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+
+	pb "github.com/packetframe/fabric-director/directorpb"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// grpcServer adapts the Director to the generated gRPC service
+type grpcServer struct {
+	pb.UnimplementedDirectorServer
+	d *Director
+}
+
+// grpcErrorCode returns the status code of an error from a routing change, like apiErrorStatus does for the HTTP API:
+// Internal if changing the routes failed and FailedPrecondition if the director refused the change in its current
+// state
+func grpcErrorCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, errMonitorOnly):
+		return codes.FailedPrecondition
+	case errors.Is(err, errUnknownNode):
+		return codes.InvalidArgument
+	case errors.As(err, &routeChangeError{}):
+		return codes.Internal
+	}
+	return codes.FailedPrecondition
+}
+
+// Reroute reroutes all prefixes to a node, or to the default target if none is given
+func (s *grpcServer) Reroute(_ context.Context, req *pb.RerouteRequest) (*pb.RerouteResponse, error) {
+	result, err := s.d.Reroute(req.To, req.Force)
+	if err != nil {
+		return nil, status.Error(grpcErrorCode(err), err.Error())
+	}
+	return &pb.RerouteResponse{
+		Target:    result.Target,
+		Reason:    result.Reason,
+		Preflight: result.Preflight,
+	}, nil
+}
+
+// NoReroute withdraws the active reroute
+func (s *grpcServer) NoReroute(context.Context, *pb.NoRerouteRequest) (*pb.NoRerouteResponse, error) {
+	if err := s.d.NoReroute(); err != nil {
+		return nil, status.Error(grpcErrorCode(err), err.Error())
+	}
+	return &pb.NoRerouteResponse{}, nil
+}
+
+// Candidates lists the current candidate nodes
+func (s *grpcServer) Candidates(context.Context, *pb.CandidatesRequest) (*pb.CandidatesResponse, error) {
+	var resp pb.CandidatesResponse
+	for _, c := range s.d.Candidates() {
+		resp.Candidates = append(resp.Candidates, &pb.Candidate{
+			Name:      c.Name,
+			Id:        uint32(c.ID),
+			Ip:        c.IP,
+			LatencyNs: int64(c.Latency),
+			JitterNs:  int64(c.Jitter),
+		})
+	}
+	return &resp, nil
+}
+
+// Status returns a snapshot of the director's state
+func (s *grpcServer) Status(context.Context, *pb.StatusRequest) (*pb.StatusResponse, error) {
+	st := s.d.Status()
+	full, err := json.Marshal(st)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &pb.StatusResponse{
+		Node:            st.Node,
+		Rerouting:       st.Rerouting,
+		Target:          st.Target,
+		Candidates:      int32(st.Candidates),
+		HealthyFraction: st.Healthy,
+		Json:            string(full),
+	}
+	if st.Since != nil {
+		resp.SinceUnix = st.Since.Unix()
+	}
+	return resp, nil
+}
+
+// startGRPCServer starts the gRPC API on the listener of the configured address, over TLS if tlsConfig is set
+func startGRPCServer(config *Config, d *Director, listener net.Listener, tlsConfig *tls.Config) *grpc.Server {
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(grpcTokenInterceptor(config))}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server := grpc.NewServer(opts...)
+	pb.RegisterDirectorServer(server, &grpcServer{d: d})
+	addr, withTLS := config.GRPCListen, ""
+	if tlsConfig != nil {
+		withTLS = " with TLS"
+	}
+	go func() {
+		log.Infof("Starting gRPC API on %s%s", addr, withTLS)
+		if err := server.Serve(listener); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	return server
+}
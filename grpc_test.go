@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+)
+
+func TestGRPCErrorCode(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want codes.Code
+	}{
+		{fmt.Errorf("panic mode active, use /unpanic"), codes.FailedPrecondition},
+		{fmt.Errorf("no candidate available"), codes.FailedPrecondition},
+		{errMonitorOnly, codes.FailedPrecondition},
+		{routeChangeError{errMonitorOnly}, codes.FailedPrecondition},
+		{fmt.Errorf("%w %s", errUnknownNode, "lax9"), codes.InvalidArgument},
+		{routeChangeError{errors.New("netlink: file exists")}, codes.Internal},
+	} {
+		if got := grpcErrorCode(tt.err); got != tt.want {
+			t.Errorf("grpcErrorCode(%q) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runNodeHook runs the on-node-up or on-node-down command for a node in the background. The placeholders {node},
+// {state}, {latency} and {loss} are substituted in each argument, and the same values are passed in the FD_NODE,
+// FD_STATE, FD_LATENCY and FD_LOSS environment variables.
+func runNodeHook(config *Config, name, state string, latency time.Duration, loss float64) {
+	command := config.OnNodeUp
+	if state == "down" {
+		command = config.OnNodeDown
+	}
+	if len(command) == 0 {
+		return
+	}
+
+	replacer := strings.NewReplacer(
+		"{node}", name,
+		"{state}", state,
+		"{latency}", latency.String(),
+		"{loss}", fmt.Sprintf("%.1f", loss),
+	)
+	args := make([]string, len(command))
+	for i, arg := range command {
+		args[i] = replacer.Replace(arg)
+	}
+
+	timeout := config.HookTimeout
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Env = append(os.Environ(),
+			"FD_NODE="+name,
+			"FD_STATE="+state,
+			"FD_LATENCY="+latency.String(),
+			fmt.Sprintf("FD_LOSS=%.1f", loss),
+		)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			log.Warnf("Node %s hook for %s failed: %s: %s", state, name, err, strings.TrimSpace(string(out)))
+			return
+		}
+		log.Debugf("Node %s hook for %s finished: %s", state, name, strings.TrimSpace(string(out)))
+	}()
+}
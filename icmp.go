@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// bindToDeviceControl returns a dialer control function that binds the socket to an interface with SO_BINDTODEVICE
+func bindToDeviceControl(device string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		if err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, device)
+		}); err != nil {
+			return err
+		}
+		if sockErr != nil {
+			return fmt.Errorf("error binding to %s: %s", device, sockErr)
+		}
+		return nil
+	}
+}
+
+// listenICMP opens an unprivileged ICMP datagram socket, optionally bound to an interface, with a firewall mark and with
+// the don't fragment bit set on outgoing packets
+func listenICMP(device string, mark int, ipv6, dontFragment bool) (net.PacketConn, error) {
+	family, proto := syscall.AF_INET, syscall.IPPROTO_ICMP
+	if ipv6 {
+		family, proto = syscall.AF_INET6, syscall.IPPROTO_ICMPV6
+	}
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM, proto)
+	if err != nil {
+		return nil, err
+	}
+	if device != "" {
+		if err := syscall.SetsockoptString(fd, syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, device); err != nil {
+			_ = syscall.Close(fd)
+			return nil, fmt.Errorf("error binding to %s: %s", device, err)
+		}
+	}
+	if mark != 0 {
+		if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_MARK, mark); err != nil {
+			_ = syscall.Close(fd)
+			return nil, fmt.Errorf("error setting mark: %s", err)
+		}
+	}
+	if dontFragment {
+		// Probe mode sets DF and ignores the cached path MTU so oversized probes are dropped instead of fragmented
+		level, opt, val := syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_PROBE
+		if ipv6 {
+			level, opt, val = syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_PROBE
+		}
+		if err := syscall.SetsockoptInt(fd, level, opt, val); err != nil {
+			_ = syscall.Close(fd)
+			return nil, fmt.Errorf("error setting don't fragment: %s", err)
+		}
+	}
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
+
+// echo sends an ICMP echo request with size bytes of payload and waits until deadline for the matching reply. It
+// returns the RTT and false if no reply arrived in time. The kernel sets the echo identifier and checksum on ping
+// sockets.
+func echo(conn net.PacketConn, dst net.IP, seq, size int, deadline time.Time) (time.Duration, bool, error) {
+	ipv6 := dst.To4() == nil
+	echoRequest, echoReply := byte(8), byte(0)
+	if ipv6 {
+		echoRequest, echoReply = 128, 129
+	}
+
+	msg := make([]byte, 8+size)
+	msg[0] = echoRequest
+	binary.BigEndian.PutUint16(msg[6:], uint16(seq))
+	start := time.Now()
+	if _, err := conn.WriteTo(msg, &net.UDPAddr{IP: dst}); err != nil {
+		if errors.Is(err, syscall.EMSGSIZE) {
+			// Larger than the local interface MTU
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return 0, false, err
+	}
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return 0, false, nil
+			}
+			return 0, false, err
+		}
+		if n >= 8 && buf[0] == echoReply && binary.BigEndian.Uint16(buf[6:]) == uint16(seq) {
+			return time.Since(start), true, nil
+		}
+	}
+}
+
+// probeDevice pings a host from a socket bound to the target's device so the probe can't leave via another interface,
+// or from a socket with the target's mark so it follows the pinned path
+func (p *icmpProber) probeDevice(target probeTarget) (probeResult, error) {
+	log.Debugf("Pinging %s via %s mark %d", target.Dst, target.Device, target.Mark)
+	dst := net.ParseIP(target.Dst)
+	if dst == nil {
+		return probeResult{}, fmt.Errorf("invalid destination %s", target.Dst)
+	}
+	conn, err := listenICMP(target.Device, target.Mark, dst.To4() == nil, false)
+	if err != nil {
+		return probeResult{}, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(p.wait())
+	var rtts []time.Duration
+	for seq := 0; seq < p.Count && time.Now().Before(deadline); seq++ {
+		if seq > 0 && p.Interval != 0 {
+			time.Sleep(p.Interval)
+		}
+		rtt, ok, err := echo(conn, dst, seq, 0, deadline)
+		if err != nil {
+			return probeResult{}, err
+		}
+		if ok {
+			rtts = append(rtts, rtt)
+		}
+	}
+	p.countLate(rtts)
+	return summarizeRtts(rtts, p.Count), nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tunnelState holds the names of nodes whose tunnel keepalive has failed keepalive-failures times in a row
+var tunnelState = struct {
+	sync.Mutex
+	failures map[string]int
+	down     map[string]bool
+}{failures: map[string]int{}, down: map[string]bool{}}
+
+// tunnelDown returns true if a node's tunnel keepalive has declared the tunnel down
+func tunnelDown(name string) bool {
+	tunnelState.Lock()
+	defer tunnelState.Unlock()
+	return tunnelState.down[name]
+}
+
+// recordKeepalive records a keepalive result for a node's tunnel and updates the tunnel up gauge once the failure
+// count is reached or the tunnel recovers
+func recordKeepalive(config *Config, name string, ok bool) {
+	tunnelState.Lock()
+	defer tunnelState.Unlock()
+	if ok {
+		tunnelState.failures[name] = 0
+		if tunnelState.down[name] {
+			log.Infof("Tunnel keepalive to %s recovered", name)
+			events.Add("tunnel-up", name, "keepalive recovered")
+		}
+		delete(tunnelState.down, name)
+		metricTunnelUp.WithLabelValues(name).Set(1)
+		return
+	}
+	tunnelState.failures[name]++
+	if tunnelState.failures[name] >= config.KeepaliveFailures && !tunnelState.down[name] {
+		log.Warnf("Tunnel keepalive to %s failed %d times, marking tunnel down", name, tunnelState.failures[name])
+		events.Add("tunnel-down", name, "keepalive failed")
+		tunnelState.down[name] = true
+		metricTunnelUp.WithLabelValues(name).Set(0)
+	}
+}
+
+// keepalive sends a single echo request to a node's overlay address through its tunnel and returns true if it was
+// answered before timeout
+func keepalive(t tunnelPlan, dst string, timeout time.Duration) (bool, error) {
+	conn, err := listenICMP(t.Interface, 0, false, false)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	_, ok, err := echo(conn, net.ParseIP(dst), 0, 0, time.Now().Add(timeout))
+	return ok, err
+}
+
+// keepaliveLoop periodically checks each tunnel with a keepalive through the overlay so a dead remote is detected
+// faster than the latency thresholds and candidate window would
+func keepaliveLoop(config *Config) {
+	ticker := time.NewTicker(config.KeepaliveInterval)
+	for range ticker.C {
+		config, p := configSnapshot(config)
+		var wg sync.WaitGroup
+		for _, t := range p.Tunnels {
+			t := t
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				dst := internalIP(config.Prefix4, config.LocalID, config.Nodes[t.Node].ID, 0)
+				ok, err := keepalive(t, dst, config.KeepaliveInterval)
+				if err != nil {
+					log.Debugf("Error sending keepalive to %s: %s", t.Node, err)
+				}
+				recordKeepalive(config, t.Node, ok)
+			}()
+		}
+		wg.Wait()
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// kvState is the reroute state written to the KV store while a reroute is active
+type kvState struct {
+	Node   string    `json:"node"`
+	Target string    `json:"target"`
+	Since  time.Time `json:"since"`
+}
+
+var kvClient = &http.Client{Timeout: 5 * time.Second}
+
+// kvUpdates serializes KV writes so transitions land in order. A nil state clears the key.
+var kvUpdates = make(chan *kvState, 16)
+
+// kvKey returns the configured KV key with the {node} placeholder substituted
+func kvKey(config *Config) string {
+	return strings.ReplaceAll(config.KVKey, "{node}", localNodeName)
+}
+
+// kvRequest sends a request to the KV backend and checks the response status
+func kvRequest(method, url string, body []byte) error {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp, err := kvClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s returned %s", method, url, resp.Status)
+	}
+	return nil
+}
+
+// writeKV puts or, if state is nil, deletes the reroute state key using the Consul KV or etcd v3 JSON gateway API
+func writeKV(config *Config, state *kvState) error {
+	key := kvKey(config)
+	address := strings.TrimSuffix(config.KVAddress, "/")
+	var value []byte
+	if state != nil {
+		var err error
+		if value, err = json.Marshal(state); err != nil {
+			return err
+		}
+	}
+
+	switch config.KVBackend {
+	case "consul":
+		if state == nil {
+			return kvRequest(http.MethodDelete, address+"/v1/kv/"+key, nil)
+		}
+		return kvRequest(http.MethodPut, address+"/v1/kv/"+key, value)
+	case "etcd":
+		encodedKey := base64.StdEncoding.EncodeToString([]byte(key))
+		if state == nil {
+			body, _ := json.Marshal(map[string]string{"key": encodedKey})
+			return kvRequest(http.MethodPost, address+"/v3/kv/deleterange", body)
+		}
+		body, _ := json.Marshal(map[string]string{
+			"key":   encodedKey,
+			"value": base64.StdEncoding.EncodeToString(value),
+		})
+		return kvRequest(http.MethodPost, address+"/v3/kv/put", body)
+	}
+	return fmt.Errorf("unknown kv-backend %s", config.KVBackend)
+}
+
+// kvLoop writes queued reroute state updates to the KV store
+func kvLoop(config *Config) {
+	for state := range kvUpdates {
+		snapshot, _ := configSnapshot(config)
+		if err := writeKV(snapshot, state); err != nil {
+			log.Warnf("Error exporting reroute state to %s: %s", config.KVBackend, err)
+		}
+	}
+}
+
+// exportKVStateLocked queues the current reroute state for export to the KV store without blocking. The caller must
+// hold the reroute lock.
+func exportKVStateLocked(config *Config) {
+	if config.KVBackend == "" {
+		return
+	}
+	var state *kvState
+	if reroute.Active {
+		state = &kvState{Node: localNodeName, Target: reroute.Target, Since: reroute.Since}
+	}
+	select {
+	case kvUpdates <- state:
+	default:
+		log.Warnf("KV export queue full, dropping reroute state update")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// watchTargetLink subscribes to link state changes and applies the failover policy as soon as the active reroute
+// target's tunnel interface goes down or is deleted, instead of waiting for probes to fail for target-down-cycles
+func watchTargetLink(config *Config) {
+	for {
+		updates := make(chan netlink.LinkUpdate)
+		if err := netlink.LinkSubscribe(updates, nil); err != nil {
+			log.Warnf("Error subscribing to link updates: %s", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+		for update := range updates {
+			iface := update.Attrs().Name
+			if !strings.HasPrefix(iface, "fd-") {
+				continue
+			}
+			if update.Header.Type != syscall.RTM_DELLINK && update.Attrs().Flags&net.FlagUp != 0 &&
+				update.Attrs().OperState != netlink.OperDown {
+				continue
+			}
+			configLock.RLock()
+			targetLinkDown(config, strings.TrimPrefix(iface, "fd-"), iface)
+			configLock.RUnlock()
+		}
+		log.Warn("Link update subscription closed, resubscribing")
+	}
+}
+
+// targetLinkDown fails over from a node if it is the active reroute target
+func targetLinkDown(config *Config, name, iface string) {
+	reroute.Lock()
+	defer reroute.Unlock()
+	if !reroute.Active || reroute.Target != name {
+		return
+	}
+	if reroute.Panic {
+		log.Errorf("Tunnel interface %s of panic target %s went down, holding until unpanic", iface, name)
+		return
+	}
+	failoverLocked(config, name, fmt.Sprintf("tunnel interface %s went down", iface))
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTargetLinkDown(t *testing.T) {
+	config := testConfig(t, "failover-on-target-down: hold\n")
+	activeReroute(t, "fmt2")
+	downs := testutil.ToFloat64(metricTargetDown)
+
+	// Tunnels to other nodes going down don't affect the reroute
+	targetLinkDown(config, "sea3", "fd-sea3")
+	if got := testutil.ToFloat64(metricTargetDown) - downs; got != 0 {
+		t.Errorf("target down counted %.0f times for another node's tunnel, want 0", got)
+	}
+
+	targetLinkDown(config, "fmt2", "fd-fmt2")
+	if got := testutil.ToFloat64(metricTargetDown) - downs; got != 1 {
+		t.Errorf("target down counted %.0f times, want 1", got)
+	}
+
+	// A panic target is held without applying the failover policy
+	reroute.Lock()
+	reroute.Panic = true
+	reroute.Unlock()
+	targetLinkDown(config, "fmt2", "fd-fmt2")
+	if got := testutil.ToFloat64(metricTargetDown) - downs; got != 1 {
+		t.Errorf("target down counted %.0f times with a panic target, want 1", got)
+	}
+	if !reroute.Active || reroute.Target != "fmt2" {
+		t.Errorf("reroute active %t to %s, want held on fmt2", reroute.Active, reroute.Target)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// localHealth is the health of this node's own egress path, measured by probing the configured local health targets
+type localHealth struct {
+	Healthy bool          `json:"healthy"`
+	Latency time.Duration `json:"latency"`
+	Loss    float64       `json:"loss"`
+	Updated time.Time     `json:"updated"`
+}
+
+var local = struct {
+	sync.Mutex
+	health localHealth
+}{}
+
+// measureLocal probes each local health target and returns the average latency and loss across them. A target that
+// errors counts as total loss.
+func measureLocal(config *Config, prober Prober) localHealth {
+	var health localHealth
+	var totalLatency time.Duration
+	var totalLoss float64
+	for _, target := range config.LocalHealthTargets {
+		result, err := prober.Probe(probeTarget{Dst: target})
+		if err != nil {
+			log.Debugf("Error probing local health target %s: %s", target, err)
+			result = probeResult{Loss: 100}
+		}
+		totalLatency += result.Latency
+		totalLoss += result.Loss
+	}
+	n := len(config.LocalHealthTargets)
+	health.Latency = totalLatency / time.Duration(n)
+	health.Loss = totalLoss / float64(n)
+	health.Healthy = health.Loss < config.LossThreshold && health.Latency <= config.LatencyThreshold
+	health.Updated = time.Now()
+	return health
+}
+
+// updateLocalHealth measures and records local health, returning the new measurement
+func updateLocalHealth(config *Config, prober Prober) localHealth {
+	health := measureLocal(config, prober)
+	local.Lock()
+	defer local.Unlock()
+	if health.Healthy != local.health.Healthy && !local.health.Updated.IsZero() {
+		log.Infof("Local health changed to healthy=%t (latency %s, loss %.1f%%)", health.Healthy, health.Latency, health.Loss)
+	}
+	local.health = health
+	return health
+}
+
+// currentLocalHealth returns the latest local health measurement, or nil if local health isn't monitored
+func currentLocalHealth(config *Config) *localHealth {
+	if len(config.LocalHealthTargets) == 0 {
+		return nil
+	}
+	local.Lock()
+	defer local.Unlock()
+	health := local.health
+	return &health
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMeasureLocal(t *testing.T) {
+	config := testConfig(t, "local-health-targets: [192.0.2.1, 192.0.2.2]\n")
+	for _, tt := range []struct {
+		name        string
+		prober      *fakeProber
+		wantLatency time.Duration
+		wantLoss    float64
+		wantHealthy bool
+	}{
+		{"healthy", &fakeProber{result: probeResult{Latency: 20 * time.Millisecond}}, 20 * time.Millisecond, 0, true},
+		{"slow", &fakeProber{result: probeResult{Latency: 150 * time.Millisecond}}, 150 * time.Millisecond, 0, false},
+		{"lossy", &fakeProber{result: probeResult{Latency: 20 * time.Millisecond, Loss: 20}}, 20 * time.Millisecond, 20, false},
+		{"errors count as loss", &fakeProber{err: errors.New("network is unreachable")}, 0, 100, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			health := measureLocal(config, tt.prober)
+			if health.Latency != tt.wantLatency || health.Loss != tt.wantLoss || health.Healthy != tt.wantHealthy {
+				t.Errorf("health %+v, want latency %s loss %.1f healthy %t", health, tt.wantLatency, tt.wantLoss, tt.wantHealthy)
+			}
+			if tt.prober.probes != 2 {
+				t.Errorf("%d targets probed, want 2", tt.prober.probes)
+			}
+		})
+	}
+}
+
+func TestSuperviseRevertHold(t *testing.T) {
+	config := testConfig(t, "local-health-targets: [192.0.2.1]\nauto-revert: true\nrevert-hold: 1m\n")
+	activeReroute(t, "fmt2")
+	healthy, unhealthy := localHealth{Healthy: true}, localHealth{Healthy: false, Loss: 100}
+	confirm := &fakeProber{result: probeResult{Loss: 100}}
+
+	superviseRevert(config, healthy, confirm)
+	if reroute.RevertPendingSince.IsZero() {
+		t.Fatal("no revert pending after local health recovered")
+	}
+
+	// The hold hasn't elapsed, so nothing is confirmed yet
+	superviseRevert(config, healthy, confirm)
+	if confirm.probes != 0 {
+		t.Errorf("confirmation probed %d times during the hold", confirm.probes)
+	}
+
+	superviseRevert(config, unhealthy, confirm)
+	if !reroute.RevertPendingSince.IsZero() {
+		t.Error("revert still pending after local health degraded during the hold")
+	}
+
+	// A failed confirmation once the hold has elapsed cancels the revert and keeps the reroute
+	superviseRevert(config, healthy, confirm)
+	reroute.RevertPendingSince = time.Now().Add(-2 * time.Minute)
+	superviseRevert(config, healthy, confirm)
+	if confirm.probes != 1 {
+		t.Errorf("confirmation probed %d times, want 1", confirm.probes)
+	}
+	if !reroute.RevertPendingSince.IsZero() || !reroute.Active {
+		t.Errorf("after a failed confirmation revert pending since %s and reroute active %t, want cancelled and active",
+			reroute.RevertPendingSince, reroute.Active)
+	}
+}
+
+func TestSuperviseRevertPanic(t *testing.T) {
+	config := testConfig(t, "local-health-targets: [192.0.2.1]\nauto-revert: true\n")
+	activeReroute(t, "fmt2")
+	reroute.Panic = true
+
+	superviseRevert(config, localHealth{Healthy: true}, &fakeProber{})
+	if !reroute.RevertPendingSince.IsZero() {
+		t.Error("revert scheduled for a panic reroute")
+	}
+}
@@ -1,22 +1,24 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/go-ping/ping"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
-	"gopkg.in/yaml.v3"
+	"google.golang.org/grpc"
 )
 
 var version = "dev"
@@ -24,48 +26,35 @@ var version = "dev"
 var (
 	configFile = flag.String("c", "config.yml", "Configuration file")
 	down       = flag.Bool("d", false, "Teardown tunnels and exit")
+	yes        = flag.Bool("yes", false, "Teardown without asking for confirmation")
 	verbose    = flag.Bool("v", false, "Verbose output")
+	dryRun     = flag.Bool("reconcile-dry-run", false, "Print what reconciliation would change and exit")
+	jsonOutput = flag.Bool("json", false, "Print -reconcile-dry-run or -check output as JSON")
+	check      = flag.Bool("check", false, "Validate the config, print the tunnel and route plan and exit")
+	wgGenKey   = flag.Bool("wg-genkey", false, "Generate a WireGuard key pair for the config and exit")
 )
 
-var candidateNodes = map[string]Node{} // Node name to node
-
-type Config struct {
-	LocalID          uint8           `yaml:"local-id"`
-	Prefix4          string          `yaml:"prefix4"`
-	Prefix6          string          `yaml:"prefix6"`
-	PingInterval     time.Duration   `yaml:"ping-interval"`
-	LatencyThreshold time.Duration   `yaml:"latency-threshold"`
-	LossThreshold    float64         `yaml:"loss-threshold"`
-	Listen           string          `yaml:"listen"`
-	Prefixes         []string        `yaml:"prefixes"`
-	Nodes            map[string]Node `yaml:"nodes"`
-}
-
-var (
-	metricIsRerouting = promauto.NewGauge(prometheus.GaugeOpts{
-		Name: "fabric_director_is_rerouting",
-		Help: "Is this node rerouting?",
-	})
-
-	metricCandidateNodes = promauto.NewGauge(prometheus.GaugeOpts{
-		Name: "fabric_director_candidate_nodes",
-		Help: "Number of candidate nodes",
-	})
-
-	metricNodeLatency = promauto.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "fabric_director_node_latency",
-			Help: "Latency from node to node",
-		},
-		[]string{"src", "dst"},
-	)
-)
+var localNodeName string
 
 // Node represents an edge node
 type Node struct {
-	ID      uint8  `yaml:"id"`
-	IP      string `yaml:"ip"`
-	Latency time.Duration
+	ID           uint8             `yaml:"id"`
+	IP           string            `yaml:"ip"`
+	Tags         map[string]string `yaml:"tags"`
+	ProbeNexthop string            `yaml:"probe-nexthop"`     // Underlay next hop probes are pinned to with probe-pinning
+	ProbeType    string            `yaml:"probe-type"`        // Overrides the global probe-type for this node
+	TunnelType   string            `yaml:"tunnel-type"`       // Overrides the global tunnel-type for this node
+	PublicKey    string            `yaml:"public-key"`        // WireGuard public key, required for wireguard tunnels
+	Underlay     string            `yaml:"underlay"`          // Address family tunnels to this node run over, ipv4 or ipv6
+	IP6          string            `yaml:"ip6"`               // IPv6 underlay address, used for tunnels to IPv6 only nodes
+	PingInterval time.Duration     `yaml:"ping-interval"`     // Overrides the global ping-interval, no shorter than it
+	PingCount    int               `yaml:"ping-count"`        // Overrides the number of probes per measurement
+	ProbeTimeout time.Duration     `yaml:"probe-timeout"`     // Overrides the timeout of each probe
+	LatencyMax   time.Duration     `yaml:"latency-threshold"` // Overrides the global latency thresholds of both families
+	LossMax      float64           `yaml:"loss-threshold"`    // Overrides loss-threshold
+	Latency      time.Duration
+	Jitter       time.Duration
+	Loss         float64
 }
 
 // parseCIDR parses a CIDR string into an IPNet preserving the last octet
@@ -80,6 +69,35 @@ func parseCIDR(cidr string) (net.IPNet, error) {
 	return full, nil
 }
 
+// splitZone splits an IP address string into the address and its IPv6 zone, if any
+func splitZone(addr string) (string, string) {
+	if i := strings.LastIndex(addr, "%"); i != -1 {
+		return addr[:i], addr[i+1:]
+	}
+	return addr, ""
+}
+
+// parseZonedIP parses an IP address with an optional IPv6 zone (e.g. fe80::1%eth0), returning the IP and the index of
+// the zone interface (0 if no zone is set)
+func parseZonedIP(addr string) (net.IP, int, error) {
+	host, zone := splitZone(addr)
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, 0, fmt.Errorf("invalid IP address %s", addr)
+	}
+	if zone == "" {
+		return ip, 0, nil
+	}
+	if ip.To4() != nil {
+		return nil, 0, fmt.Errorf("zone not allowed on IPv4 address %s", addr)
+	}
+	iface, err := net.InterfaceByName(zone)
+	if err != nil {
+		return nil, 0, fmt.Errorf("zone interface %s of %s: %s", zone, addr, err)
+	}
+	return ip, iface.Index, nil
+}
+
 // internalIP returns the GRE internal IP of a node
 func internalIP(prefix string, octet3, octet4, mask uint8) string {
 	var out string
@@ -94,65 +112,136 @@ func internalIP(prefix string, octet3, octet4, mask uint8) string {
 	return out
 }
 
-// addGRE adds a GRE tunnel and returns the interface index
-func addGRE(name, local, remote, ip4, ip6 string) (int, error) {
+// ipv6Disabled returns true if IPv6 is disabled on an interface, either by sysctl or at boot
+func ipv6Disabled(name string) bool {
+	for _, path := range []string{"/proc/sys/net/ipv6/conf/all/disable_ipv6", "/proc/sys/net/ipv6/conf/" + name + "/disable_ipv6"} {
+		b, err := os.ReadFile(path)
+		if os.IsNotExist(err) && strings.HasSuffix(path, "/all/disable_ipv6") {
+			return true // ipv6.disable=1 removes the IPv6 sysctl tree entirely
+		}
+		if err == nil && strings.TrimSpace(string(b)) == "1" {
+			return true
+		}
+	}
+	return false
+}
+
+// warnIPv6Disabled makes sure the IPv6 disabled warning is only logged once rather than for every tunnel
+var warnIPv6Disabled sync.Once
+
+// tunnelIPv6Skipped is set once a tunnel came up without its IPv6 address because IPv6 is disabled on the host, so
+// reroutes don't use IPv6 nexthops that aren't reachable
+var tunnelIPv6Skipped int32
+
+// addGRE adds a GRE tunnel and returns the interface index. An empty ip6 skips IPv6 assignment. If requireIPv6 is false
+// and IPv6 is disabled on the host, the IPv6 address is skipped with a warning instead of failing the tunnel.
+func addGRE(name, local, remote, ip4, ip6 string, mtu int, requireIPv6 bool) (int, error) {
 	log.Debugf("Adding GRE tunnel %s from %s to %s and adding %s and %s", name, local, remote, ip4, ip6)
 
+	localIP, localZone, err := parseZonedIP(local)
+	if err != nil {
+		return -1, fmt.Errorf("error parsing local address for GRE tunnel %s: %s", name, err)
+	}
+	remoteIP, remoteZone, err := parseZonedIP(remote)
+	if err != nil {
+		return -1, fmt.Errorf("error parsing remote address for GRE tunnel %s: %s", name, err)
+	}
+
 	// Create GRE interface
 	la := netlink.NewLinkAttrs()
 	la.Name = name
-	la.MTU = 1436 // 1500 - 20 byte TCP header - 20 byte IP header - 24 byte GRE header + IP header
+	la.MTU = mtu
 	gre := &netlink.Gretun{
-		Local:     net.ParseIP(local),
-		Remote:    net.ParseIP(remote),
+		Local:     localIP,
+		Remote:    remoteIP,
 		LinkAttrs: la,
 	}
+	// Link-local endpoints must be bound to the interface they're scoped to
+	if remoteZone != 0 {
+		gre.Link = uint32(remoteZone)
+	} else if localZone != 0 {
+		gre.Link = uint32(localZone)
+	}
 	if err := netlink.LinkAdd(gre); err != nil {
 		return -1, fmt.Errorf("error adding GRE tunnel %s: %s", name, err)
 	}
+	return setupTunnelLink(gre, "GRE", ip4, ip6, requireIPv6)
+}
 
-	// Add IP address to interface
+// setupTunnelLink assigns the internal addresses to a newly added tunnel interface of the given kind, brings it up
+// and returns its index. An empty ip6 skips IPv6 assignment.
+func setupTunnelLink(link netlink.Link, kind, ip4, ip6 string, requireIPv6 bool) (int, error) {
+	name := link.Attrs().Name
 	ipNet4, err := parseCIDR(ip4)
 	if err != nil {
-		return -1, fmt.Errorf("error parsing IPv4 %s for GRE interface %s: %s", ip4, name, err)
+		return -1, fmt.Errorf("error parsing IPv4 %s for %s interface %s: %s", ip4, kind, name, err)
 	}
-	ipNet6, err := parseCIDR(ip6)
-	if err != nil {
-		return -1, fmt.Errorf("error parsing IPv6 %s for GRE interface %s: %s", ip6, name, err)
+	if err := nl.AddrAdd(link, &netlink.Addr{IPNet: &ipNet4}); err != nil {
+		return -1, fmt.Errorf("error adding IPv4 %s to %s interface %s: %s", ip4, kind, name, err)
 	}
-	if err := netlink.AddrAdd(gre, &netlink.Addr{IPNet: &ipNet4}); err != nil {
-		return -1, fmt.Errorf("error adding IPv4 %s to GRE interface %s: %s", ip4, name, err)
+	if ip6 != "" {
+		ipNet6, err := parseCIDR(ip6)
+		if err != nil {
+			return -1, fmt.Errorf("error parsing IPv6 %s for %s interface %s: %s", ip6, kind, name, err)
+		}
+		if err := nl.AddrAdd(link, &netlink.Addr{IPNet: &ipNet6}); err != nil {
+			if requireIPv6 || !nl.IPv6Disabled(name) {
+				return -1, fmt.Errorf("error adding IPv6 %s to %s interface %s: %s", ip6, kind, name, err)
+			}
+			atomic.StoreInt32(&tunnelIPv6Skipped, 1)
+			warnIPv6Disabled.Do(func() {
+				log.Warnf("IPv6 is disabled on this host, tunnels will be IPv4 only (set address-family: ipv4 to silence or dual to require IPv6)")
+			})
+		}
 	}
-	if err := netlink.AddrAdd(gre, &netlink.Addr{IPNet: &ipNet6}); err != nil {
-		return -1, fmt.Errorf("error adding IPv6 %s to GRE interface %s: %s", ip6, name, err)
+	if err := nl.LinkSetUp(link); err != nil {
+		return -1, fmt.Errorf("error bringing up %s interface %s: %s", kind, name, err)
+	}
+	return link.Attrs().Index, nil
+}
+
+// rerouteNexthops returns the IPv4 and IPv6 nexthops used to reroute traffic to a node. In overlay mode the nexthops are
+// the node's internal GRE IPs, without the IPv6 one if tunnels came up IPv4 only. In underlay mode the node's underlay
+// IP is used for its address family.
+func rerouteNexthops(config *Config, node *Node) (string, string) {
+	if config.RerouteVia == "underlay" {
+		addr := nodeUnderlayIP(config, *node)
+		host, _ := splitZone(addr)
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return "", ""
+		}
+		if ip.To4() != nil {
+			return addr, ""
+		}
+		return "", addr
 	}
-	if err := netlink.LinkSetUp(gre); err != nil {
-		return -1, fmt.Errorf("error bringing up GRE interface %s: %s", name, err)
+	if config.AddressFamily == "ipv4" || atomic.LoadInt32(&tunnelIPv6Skipped) == 1 {
+		return internalIP(config.Prefix4, config.LocalID, node.ID, 0), ""
 	}
-	return gre.Attrs().Index, nil
+	return internalIP(config.Prefix4, config.LocalID, node.ID, 0), internalIP(config.Prefix6, config.LocalID, node.ID, 0)
 }
 
-// addRoute adds a static route from a prefix to an interface
-func addRoute(prefix, nexthop4, nexthop6 string) error {
-	_, ipNet, err := net.ParseCIDR(prefix)
+// addRoute installs a planned reroute route, replacing any route to the prefix with the same table and priority
+func addRoute(r plannedRoute) error {
+	_, ipNet, err := net.ParseCIDR(r.Prefix)
 	if err != nil {
 		return err
 	}
-
-	var nexthop string
-	if ipNet.IP.To4() != nil {
-		nexthop = nexthop4
-	} else {
-		nexthop = nexthop6
+	gw, linkIndex, err := parseZonedIP(r.Proposed)
+	if err != nil {
+		return err
 	}
 
-	log.Debugf("Adding route %s via %s", prefix, nexthop)
+	log.Debugf("Adding route %s via %s", r.Prefix, r.Proposed)
 	route := &netlink.Route{
-		Dst:      ipNet,
-		Gw:       net.ParseIP(nexthop),
-		Priority: 1,
+		Dst:       ipNet,
+		Gw:        gw,
+		LinkIndex: linkIndex,
+		Table:     r.Table,
+		Priority:  r.Priority,
 	}
-	return netlink.RouteAdd(route)
+	return nl.RouteReplace(route)
 }
 
 // setPFNet controls the pf-net service state
@@ -160,29 +249,30 @@ func setPFNet(state bool) error {
 	if state {
 		return exec.Command("/opt/packetframe/net.sh").Run()
 	} else {
-		return netlink.LinkDel(&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "local"}})
+		return nl.LinkDel(&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "local"}})
 	}
 }
 
-// setReroute controls the rerouting state
-func setReroute(reroute bool, prefixes []string, nexthop4, nexthop6 string) error {
+// setReroute controls the rerouting state. Rerouting installs the planned routes, withdrawing deletes the routes to
+// the planned routes' prefixes.
+func setReroute(reroute bool, routes []plannedRoute) error {
 	if reroute {
 		metricIsRerouting.Set(1)
 		if err := setPFNet(false); err != nil {
 			return err
 		}
-		for _, prefix := range prefixes {
-			if err := addRoute(prefix, nexthop4, nexthop6); err != nil {
+		for _, r := range routes {
+			if err := addRoute(r); err != nil {
 				return err
 			}
 		}
 	} else {
-		for _, prefix := range prefixes {
-			_, ipNet, err := net.ParseCIDR(prefix)
+		for _, r := range routes {
+			_, ipNet, err := net.ParseCIDR(r.Prefix)
 			if err != nil {
 				return err
 			}
-			if err := netlink.RouteDel(&netlink.Route{Dst: ipNet, Scope: netlink.SCOPE_UNIVERSE}); err != nil {
+			if err := nl.RouteDel(&netlink.Route{Dst: ipNet, Scope: netlink.SCOPE_UNIVERSE}); err != nil {
 				return err
 			}
 		}
@@ -194,191 +284,353 @@ func setReroute(reroute bool, prefixes []string, nexthop4, nexthop6 string) erro
 	return nil
 }
 
-// closestNode returns the node with the lowest latency
-func closestNode() (*Node, string) {
+// autoSelectable returns true if a candidate node may be chosen by automatic target selection
+func autoSelectable(config *Config, node Node) bool {
+	return selectionExclusion(config, node) == ""
+}
+
+// selectionExclusion returns why a candidate node can't be chosen by automatic target selection, or an empty string
+// if it can
+func selectionExclusion(config *Config, node Node) string {
+	if config.RerouteMaxLatency != 0 && node.Latency > config.RerouteMaxLatency {
+		return fmt.Sprintf("latency %s exceeds reroute-max-latency %s", node.Latency, config.RerouteMaxLatency)
+	}
+	if config.JitterAction == "deselect" && config.JitterThreshold != 0 && node.Jitter > config.JitterThreshold {
+		return fmt.Sprintf("jitter %s exceeds %s", node.Jitter, config.JitterThreshold)
+	}
+	if config.DegradedAction == "exclude" && degraded(config, node) {
+		return fmt.Sprintf("loss %.1f%% is in the degraded band", node.Loss)
+	}
+	return ""
+}
+
+// degraded returns true if a candidate node's loss is at or above degraded-loss, passing the loss threshold but
+// clearly troubled
+func degraded(config *Config, node Node) bool {
+	return config.DegradedLoss != 0 && node.Loss >= config.DegradedLoss
+}
+
+// closestNode returns the auto-selectable candidate node with the lowest latency, excluding the given node name.
+// Degraded candidates are only chosen if no other candidate is available, and none is chosen until reroute-min-cycles
+// probe cycles have completed. The previously returned node is kept unless another is faster by more than
+// closest-hysteresis.
+func closestNode(config *Config, exclude string) (*Node, string) {
+	if insufficientData(config) != "" {
+		return nil, ""
+	}
 	var closest *Node
 	var closestName string
-	for name, node := range candidateNodes {
-		if closest == nil || node.Latency < closest.Latency {
+	candidates := candidateNodes.Snapshot()
+	for name, node := range candidates {
+		node := node
+		if name == exclude || !autoSelectable(config, node) {
+			continue
+		}
+		if closest == nil || degraded(config, *closest) && !degraded(config, node) ||
+			degraded(config, *closest) == degraded(config, node) && node.Latency < closest.Latency {
 			closest = &node
 			closestName = name
 		}
 	}
-	return closest, closestName
+	return stickyClosest(config, candidates, exclude, closest, closestName)
 }
 
 // teardownGRE deletes all GRE interfaces
 func teardownGRE() error {
-	links, err := netlink.LinkList()
+	links, err := fabricLinks()
 	if err != nil {
 		return err
 	}
 	for _, iface := range links {
-		if strings.HasPrefix(iface.Attrs().Name, "fd-") {
-			log.Debugf("Deleting interface %s", iface.Attrs().Name)
-			if err := netlink.LinkDel(iface); err != nil {
-				return err
-			}
+		log.Debugf("Deleting interface %s", iface.Attrs().Name)
+		if err := netlink.LinkDel(iface); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-// icmpLatency uses ICMP pings to measure the latency of a remote host
-func icmpLatency(src, dst string) (time.Duration, float64, error) {
-	log.Debugf("Pinging %s from %s", dst, src)
-	pinger, err := ping.NewPinger(dst)
-	if err != nil {
-		return 0, 0, err
-	}
-	pinger.Source = src
-	pinger.Count = 3
-	pinger.Timeout = 500 * time.Millisecond
-	pinger.SetPrivileged(false)
-	err = pinger.Run()
-	if err != nil {
-		return 0, 0, err
-	}
-	stats := pinger.Statistics()
-	return stats.AvgRtt, stats.PacketLoss, nil
-}
-
 func main() {
 	flag.Parse()
 	if *verbose {
 		log.SetLevel(log.DebugLevel)
 	}
+	if *wgGenKey {
+		if err := printWireguardKey(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *check {
+		if err := checkConfig(*configFile, *jsonOutput); err != nil {
+			log.Fatalf("%s in %s", err, *configFile)
+		}
+		return
+	}
 	log.Infof("Starting fabric-director %s", version)
 
 	// Load configuration
-	yamlBytes, err := os.ReadFile(*configFile)
+	config, err := loadConfig(*configFile)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	var config Config
-	if err = yaml.Unmarshal(yamlBytes, &config); err != nil {
+	log.Infof("Loaded %d nodes from %s", len(config.Nodes), *configFile)
+	events = newEventLog(config.EventLogSize)
+
+	probes, err := newProbeSet(config)
+	if err != nil {
 		log.Fatal(err)
 	}
 
-	log.Infof("Loaded %d nodes from %s", len(config.Nodes), *configFile)
+	var restore *persistedState
+	if config.StateFile != "" {
+		restore, err = loadState(config.StateFile)
+		if err != nil {
+			log.Warnf("Error loading state from %s: %s", config.StateFile, err)
+		}
+	}
 
-	if err := teardownGRE(); err != nil {
-		log.Errorf("Error tearing down interfaces: %s", err)
+	if *dryRun {
+		if err := primeDNSCache(config, nil); err != nil {
+			log.Fatal(err)
+		}
+		p, err := buildPlan(config)
+		if err != nil {
+			log.Fatalf("%s in %s", err, *configFile)
+		}
+		diff, err := previewReconcile(config, p, restore)
+		if err != nil {
+			log.Fatalf("Error previewing reconciliation: %s", err)
+		}
+		if err := printReconcileDiff(diff, *jsonOutput); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
+	// Bind the API listeners before touching the kernel so a port conflict doesn't leave a half-initialized node
+	listeners := map[string]net.Listener{}
+	if !*down {
+		if err := bindListeners(config, listeners, true); err != nil {
+			log.Fatalf("Error binding API listeners: %s", err)
+		}
+	}
+
+	if *down {
+		count, err := logTeardownPlan(restore)
+		if err != nil {
+			log.Fatalf("Error listing interfaces: %s", err)
+		}
+		if err := confirmTeardown(config, count); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if config.TeardownOnStart || *down {
+		if err := teardownGRE(); err != nil {
+			log.Errorf("Error tearing down interfaces: %s", err)
+		}
 	}
 	if *down {
+		if config.ProbePinning {
+			cleanupProbePins(config)
+		}
 		log.Info("Teardown complete")
 		os.Exit(0)
 	}
 
-	// Find local node from nodes file
-	var localNodeName, localNodeIP string
-	for name, node := range config.Nodes {
-		if node.ID == config.LocalID {
-			localNodeName = name
-			localNodeIP = node.IP
-			log.Infof("Found local node %s (%s)", name, localNodeIP)
-			break
-		}
+	if err := registerNodeMetrics(config); err != nil {
+		log.Fatal(err)
 	}
-	if localNodeIP == "" || localNodeName == "" {
-		log.Fatalf("Could not find local node %d in %s", config.LocalID, *configFile)
+	prometheus.MustRegister(tunnelStatsCollector{})
+	candidateInfo, err := newCandidateInfoCollector(config)
+	if err != nil {
+		log.Fatal(err)
 	}
+	prometheus.MustRegister(candidateInfo)
+	mode := "full"
+	if config.MonitorOnly {
+		log.Info("Running in monitor-only mode, routing will not be changed")
+		mode = "monitor-only"
+	}
+	metricBuildInfo.WithLabelValues(version, mode).Set(1)
 
-	// Create GRE tunnels
-	for name, node := range config.Nodes {
-		// Skip local node
-		if node.ID == config.LocalID {
-			continue
+	// Resolve node hostnames, updating the tunnel endpoints whenever a resolution changes
+	err = primeDNSCache(config, func(host, previous, addr string) {
+		configLock.Lock()
+		defer configLock.Unlock()
+		p, err := buildPlan(config)
+		if err != nil {
+			log.Errorf("Error planning tunnels after %s changed: %s", host, err)
+			return
+		}
+		runningPlan = p
+		if err := reconcileTunnels(config, p); err != nil {
+			log.Errorf("Error reconciling tunnels after %s changed: %s", host, err)
 		}
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
 
-		log.Infof("Adding GRE tunnel to %s", name)
-		_, err := addGRE(
-			"fd-"+name,
-			localNodeIP,
-			node.IP,
-			internalIP(config.Prefix4, node.ID, config.LocalID, 24),
-			internalIP(config.Prefix6, node.ID, config.LocalID, 112),
-		)
+	// Find local node and compute tunnels from nodes file
+	p, err := buildPlan(config)
+	if err != nil {
+		log.Fatalf("%s in %s", err, *configFile)
+	}
+	localNodeName = p.LocalNode
+	runningPlan = p
+	log.Infof("Found local node %s (%s)", p.LocalNode, p.LocalIP)
+	logPlan(config, p)
+
+	// Create GRE tunnels, keeping any that already match the plan
+	if err := reconcileTunnels(config, p); err != nil {
+		log.Errorf("Error reconciling tunnels: %s", err)
+	}
+
+	if config.PathMTUProbe {
+		go pathMTULoop(config)
+	}
+	if config.KeepaliveInterval > 0 {
+		go keepaliveLoop(config)
+	}
+	if config.LinkWatch {
+		go watchTargetLink(config)
+	}
+	shutdownTracing := func(context.Context) error { return nil }
+	if config.OTLPEndpoint != "" {
+		shutdownTracing, err = setupTracing(config)
 		if err != nil {
-			log.Warn(err)
+			log.Fatalf("Error setting up tracing: %s", err)
 		}
 	}
+	if config.ProbePinning {
+		if err := setupProbePins(config); err != nil {
+			log.Fatalf("Error setting up probe pinning: %s", err)
+		}
+	}
+	if config.PartitionDetection {
+		go matrixLoop(config)
+	}
+	if config.KVBackend != "" {
+		go kvLoop(config)
+	}
+	if config.StandbyPeer != "" {
+		go standbyLoop(config)
+	}
 
-	// Start API server
+	// Start API servers and shut them down cleanly on termination
+	director := &Director{config: config, probes: probes}
+	var handler http.Handler = requireToken(config, lockConfig(newAPIMux(config, director)))
+	if config.APIAccessLog {
+		handler = accessLog(handler)
+	}
+	// Listeners on the overlay can only be bound now that the tunnels are up
+	if err := bindListeners(config, listeners, false); err != nil {
+		log.Fatalf("Error binding API listeners: %s", err)
+	}
+	tlsConfig, err := serverTLSConfig(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := setupPeerTLS(config); err != nil {
+		log.Fatal(err)
+	}
+	servers := startAPIServers(config, handler, listeners, tlsConfig)
+	var grpcServer *grpc.Server
+	if config.GRPCListen != "" {
+		grpcServer = startGRPCServer(config, director, listeners[config.GRPCListen], tlsConfig)
+	}
 	go func() {
-		log.Infof("Starting API on %s", config.Listen)
-
-		http.HandleFunc("/reroute", func(w http.ResponseWriter, r *http.Request) {
-			var node *Node
-			to := r.URL.Query().Get("to")
-			if to == "" {
-				node, to = closestNode()
-			} else {
-				n := config.Nodes[to]
-				node = &n
-			}
-			log.Debugf("Rerouting to %s %+v", to, node)
-			if err := setReroute(
-				true,
-				config.Prefixes,
-				internalIP(config.Prefix4, config.LocalID, node.ID, 0),
-				internalIP(config.Prefix6, config.LocalID, node.ID, 0),
-			); err != nil {
-				_, _ = fmt.Fprintf(w, "Error rerouting to %s: %s\n", to, err)
-				return
-			}
-			_, _ = fmt.Fprintf(w, "Rerouting to %s\n", to)
-			return
-		})
-
-		http.HandleFunc("/noreroute", func(w http.ResponseWriter, r *http.Request) {
-			if err := setReroute(false, config.Prefixes, "", ""); err != nil {
-				_, _ = fmt.Fprintf(w, "Error disabling reroute: %s\n", err)
-				return
-			}
-			_, _ = fmt.Fprintf(w, "Reroute disabled\n")
-		})
-
-		http.HandleFunc("/candidates", func(w http.ResponseWriter, r *http.Request) {
-			for name, node := range candidateNodes {
-				_, _ = fmt.Fprintf(w, "%s %+v\n", name, node)
-			}
-		})
-
-		http.Handle("/metrics", promhttp.Handler())
-		log.Fatal(http.ListenAndServe(config.Listen, nil))
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+		sig := <-sigs
+		log.Infof("Received %s, shutting down", sig)
+		// The probe loop may still reload the config until it is stopped
+		if snapshot, _ := configSnapshot(config); snapshot.DrainTimeout > 0 {
+			drain(snapshot, sigs)
+		}
+		stopProbing(5 * time.Second)
+		shutdownAPIServers(servers, 5*time.Second)
+		if grpcServer != nil {
+			grpcServer.GracefulStop()
+		}
+		quiesceRoutes(config)
+		if config.ProbePinning {
+			cleanupProbePins(config)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := shutdownTracing(ctx); err != nil {
+			log.Warnf("Error flushing traces: %s", err)
+		}
+		cancel()
+		os.Exit(0)
 	}()
 
 	// Start ICMP pinger in a new ticker
 	ticker := time.NewTicker(config.PingInterval)
-	for range ticker.C {
-		for name, node := range config.Nodes {
-			// Skip local node
-			if node.ID == config.LocalID {
-				continue
+	cycle := func() {
+		// Measure every due node before acting on any result, so a cycle in which most nodes fail at once can be
+		// recognized as a probe blackout
+		measured := probes.sweep(config)
+
+		// The reference targets tell blocked probes from a fabric-wide outage, so local health is measured before
+		// any node's result is acted on
+		referencesFailed := true
+		var health localHealth
+		if len(config.LocalHealthTargets) > 0 {
+			health = updateLocalHealth(config, probes.Primary)
+			referencesFailed = health.Loss >= 100
+		}
+		applySweep(config, probes.Primary, measured, referencesFailed)
+
+		if len(config.LocalHealthTargets) > 0 {
+			if config.AutoReroute {
+				superviseAutoReroute(config, health)
 			}
+			if config.AutoRevert {
+				superviseRevert(config, health, probes.Supervised)
+			}
+		}
 
-			log.Debugf("Pinging %s %+v", name, node)
+		setReady()
 
-			// Ping node
-			latency, loss, err := icmpLatency(internalIP(config.Prefix4, node.ID, config.LocalID, 0), internalIP(config.Prefix4, config.LocalID, node.ID, 0))
-			if err != nil {
-				log.Warnf("Error pinging %s: %s", name, err)
-			}
-			if latency <= config.LatencyThreshold && loss < config.LossThreshold {
-				node.Latency = latency
-				log.Debugf("Adding candidate node %+v", node)
-				candidateNodes[name] = node
-			} else {
-				delete(candidateNodes, name)
+		// Restore a persisted reroute once enough cycles have run for nodes to become candidates and be selected
+		cycles := atomic.AddInt64(&completedCycles, 1)
+		if restore != nil && cycles >= int64(config.CandidateWindowPass) && insufficientData(config) == "" {
+			restoreReroute(config, restore)
+			restore = nil
+		}
+	}
+
+	// Reload the config on SIGHUP
+	go func() {
+		hups := make(chan os.Signal, 1)
+		signal.Notify(hups, syscall.SIGHUP)
+		for range hups {
+			log.Info("Received SIGHUP, reloading configuration")
+			if err := requestReload(context.Background()); err != nil {
+				log.Errorf("Error reloading configuration: %s", err)
 			}
+		}
+	}()
 
-			metricCandidateNodes.Set(float64(len(candidateNodes)))
-			metricNodeLatency.With(prometheus.Labels{
-				"src": localNodeName,
-				"dst": name,
-			}).Set(latency.Seconds())
+	// Run each cycle under the watchdog so a panicking prober can't stop measurement for good. Candidates and reroute
+	// state live outside the cycle, so they carry over to the next one. Reloads are applied between cycles.
+	for {
+		select {
+		case <-ticker.C:
+			runProbeCycle(config, cycle)
+		case done := <-reloadRequests:
+			err := reloadConfig(config, probes)
+			if err == nil {
+				ticker.Reset(config.PingInterval)
+			}
+			done <- err
+		case <-probeStopRequests:
+			// The shutdown goroutine exits the process once it has cleaned up
+			ticker.Stop()
+			select {}
 		}
 	}
 }
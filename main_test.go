@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	log.SetLevel(log.FatalLevel)
+	// The per-node metrics are only created once the metric labels are known, as main does after loading the config
+	if err := registerNodeMetrics(&Config{}); err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestRerouteNexthops(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		extra     string
+		node      Node
+		wantNext4 string
+		wantNext6 string
+	}{
+		{"overlay", "", Node{ID: 20, IP: "192.0.2.20"}, "172.16.10.20", "fd00::10:10:20"},
+		{"overlay ipv4 only", "address-family: ipv4\n", Node{ID: 20, IP: "192.0.2.20"}, "172.16.10.20", ""},
+		{"underlay ipv4", "reroute-via: underlay\n", Node{ID: 20, IP: "192.0.2.20"}, "192.0.2.20", ""},
+		{"underlay ipv6", "reroute-via: underlay\n", Node{ID: 40, IP: "2001:db8::40", Underlay: "ipv6"}, "", "2001:db8::40"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, tt.extra)
+			next4, next6 := rerouteNexthops(config, &tt.node)
+			if next4 != tt.wantNext4 || next6 != tt.wantNext6 {
+				t.Errorf("nexthops %q and %q, want %q and %q", next4, next6, tt.wantNext4, tt.wantNext6)
+			}
+		})
+	}
+}
+
+func TestRerouteMaxLatency(t *testing.T) {
+	config := testConfig(t, "reroute-max-latency: 50ms\n")
+	fmt2, sea3 := config.Nodes["fmt2"], config.Nodes["sea3"]
+	fmt2.Latency, sea3.Latency = 60*time.Millisecond, 40*time.Millisecond
+	if reason := selectionExclusion(config, fmt2); reason == "" {
+		t.Error("node above reroute-max-latency is selectable")
+	}
+	if reason := selectionExclusion(config, sea3); reason != "" {
+		t.Errorf("node below reroute-max-latency is excluded: %s", reason)
+	}
+
+	// A node over the cap stays a candidate but isn't chosen, even when it is the only one
+	candidateNodes.Set("fmt2", fmt2)
+	if _, name := closestNode(config, ""); name != "" {
+		t.Errorf("closest node is %s, want none", name)
+	}
+	candidateNodes.Set("sea3", sea3)
+	if _, name := closestNode(config, ""); name != "sea3" {
+		t.Errorf("closest node is %q, want sea3", name)
+	}
+}
+
+func TestParseZonedIP(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface: %s", err)
+	}
+	for _, tt := range []struct {
+		addr      string
+		wantIP    string
+		wantIndex int
+		wantErr   bool
+	}{
+		{"192.0.2.20", "192.0.2.20", 0, false},
+		{"2001:db8::20", "2001:db8::20", 0, false},
+		{"fe80::20%lo", "fe80::20", lo.Index, false},
+		{"fe80::20%fd-missing0", "", 0, true},
+		{"192.0.2.20%lo", "", 0, true},
+		{"not-an-ip", "", 0, true},
+	} {
+		t.Run(tt.addr, func(t *testing.T) {
+			ip, index, err := parseZonedIP(tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !ip.Equal(net.ParseIP(tt.wantIP)) || index != tt.wantIndex {
+				t.Errorf("parsed %s with zone index %d, want %s and %d", ip, index, tt.wantIP, tt.wantIndex)
+			}
+		})
+	}
+}
+
+func TestDegradedSelection(t *testing.T) {
+	for _, tt := range []struct {
+		action     string
+		candidates []string
+		want       string
+	}{
+		{"deprioritize", []string{"fmt2", "sea3"}, "sea3"},
+		{"deprioritize", []string{"fmt2"}, "fmt2"},
+		{"exclude", []string{"fmt2", "sea3"}, "sea3"},
+		{"exclude", []string{"fmt2"}, ""},
+	} {
+		t.Run(tt.action, func(t *testing.T) {
+			config := testConfig(t, "degraded-loss: 5\ndegraded-action: "+tt.action+"\n")
+			// fmt2 is closer but its loss is in the degraded band
+			fmt2, sea3 := config.Nodes["fmt2"], config.Nodes["sea3"]
+			fmt2.Latency, fmt2.Loss = 20*time.Millisecond, 5
+			sea3.Latency, sea3.Loss = 40*time.Millisecond, 4
+			nodes := map[string]Node{"fmt2": fmt2, "sea3": sea3}
+			for _, name := range tt.candidates {
+				candidateNodes.Set(name, nodes[name])
+			}
+			if !degraded(config, fmt2) || degraded(config, sea3) {
+				t.Errorf("degraded fmt2 %t and sea3 %t, want only fmt2", degraded(config, fmt2), degraded(config, sea3))
+			}
+
+			if _, name := closestNode(config, ""); name != tt.want {
+				t.Errorf("with candidates %v closest node is %q, want %q", tt.candidates, name, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// matrixView is one node's view of which peers it can reach, served on /matrix
+type matrixView struct {
+	Node      string          `json:"node"`
+	Reachable map[string]bool `json:"reachable"`
+	Draining  bool            `json:"draining,omitempty"` // Shutting down, peers should stop using the node as a target
+}
+
+// brokenPair is a pair of nodes where Src can't reach Dst
+type brokenPair struct {
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+}
+
+// partitionReport is the JSON body of the /partitions endpoint
+type partitionReport struct {
+	Detected bool         `json:"detected"`
+	Pairs    []brokenPair `json:"pairs"`
+	Missing  []string     `json:"missing,omitempty"` // Nodes whose view couldn't be collected
+	Updated  time.Time    `json:"updated"`
+}
+
+var reachability = struct {
+	sync.Mutex
+	nodes map[string]bool
+}{nodes: map[string]bool{}}
+
+var partitions = struct {
+	sync.Mutex
+	report partitionReport
+}{}
+
+var matrixClient = &http.Client{Timeout: 5 * time.Second}
+
+// setReachable records whether the last probe of a node got any replies
+func setReachable(name string, reachable bool) {
+	reachability.Lock()
+	defer reachability.Unlock()
+	reachability.nodes[name] = reachable
+}
+
+// localMatrixView returns this node's current view of peer reachability
+func localMatrixView() matrixView {
+	reachability.Lock()
+	defer reachability.Unlock()
+	view := matrixView{Node: localNodeName, Reachable: map[string]bool{}, Draining: isDraining()}
+	for name, reachable := range reachability.nodes {
+		view.Reachable[name] = reachable
+	}
+	return view
+}
+
+// matrixURL returns the /matrix URL of a peer from the matrix-url template
+func matrixURL(config *Config, name string, node Node) string {
+	host, _ := splitZone(nodeUnderlayIP(config, node))
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return strings.NewReplacer("{node}", name, "{ip}", host).Replace(config.MatrixURL)
+}
+
+// fetchMatrixView retrieves a peer's view of the fabric
+func fetchMatrixView(url, token string) (*matrixView, error) {
+	resp, err := getMatrix(matrixClient, url, token)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMatrixView(resp)
+}
+
+// getMatrix requests a /matrix view, with the matrix token as bearer token if one is set
+func getMatrix(client *http.Client, url, token string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return client.Do(req)
+}
+
+// decodeMatrixView decodes and closes a /matrix response
+func decodeMatrixView(resp *http.Response) (*matrixView, error) {
+	defer resp.Body.Close()
+	url := resp.Request.URL.String()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	var view matrixView
+	if err := json.NewDecoder(resp.Body).Decode(&view); err != nil {
+		return nil, fmt.Errorf("error decoding view from %s: %s", url, err)
+	}
+	return &view, nil
+}
+
+// findPartitions returns the configured node pairs that can't reach each other according to the collected views
+func findPartitions(config *Config, views map[string]*matrixView) []brokenPair {
+	var pairs []brokenPair
+	for src, view := range views {
+		for dst, reachable := range view.Reachable {
+			if _, ok := config.Nodes[dst]; !ok || reachable || dst == src {
+				continue
+			}
+			pairs = append(pairs, brokenPair{Src: src, Dst: dst})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Src != pairs[j].Src {
+			return pairs[i].Src < pairs[j].Src
+		}
+		return pairs[i].Dst < pairs[j].Dst
+	})
+	return pairs
+}
+
+// collectMatrix gathers every node's view of the fabric and updates the partition report. Peers that don't serve
+// /matrix are reported as missing rather than treated as partitioned.
+func collectMatrix(config *Config) {
+	view := localMatrixView()
+	views := map[string]*matrixView{localNodeName: &view}
+	var missing []string
+	for name, node := range config.Nodes {
+		if name == localNodeName {
+			continue
+		}
+		peerView, err := fetchMatrixView(matrixURL(config, name, node), config.MatrixToken)
+		if err != nil {
+			log.Debugf("Error collecting connectivity matrix from %s: %s", name, err)
+			missing = append(missing, name)
+			continue
+		}
+		views[name] = peerView
+		setPeerDraining(name, peerView.Draining)
+	}
+	sort.Strings(missing)
+
+	pairs := findPartitions(config, views)
+	partitions.Lock()
+	wasDetected := partitions.report.Detected
+	partitions.report = partitionReport{
+		Detected: len(pairs) > 0,
+		Pairs:    pairs,
+		Missing:  missing,
+		Updated:  time.Now(),
+	}
+	partitions.Unlock()
+
+	if len(pairs) > 0 {
+		metricPartitionDetected.Set(1)
+		if !wasDetected {
+			log.Warnf("Partial partition detected: %d node pairs can't reach each other", len(pairs))
+			events.Add("partition", "", fmt.Sprintf("%d broken pairs", len(pairs)))
+		}
+	} else {
+		metricPartitionDetected.Set(0)
+		if wasDetected {
+			log.Info("Partial partition resolved")
+			events.Add("partition-resolved", "", "")
+		}
+	}
+}
+
+// matrixLoop periodically collects the connectivity matrix
+func matrixLoop(config *Config) {
+	ticker := time.NewTicker(config.MatrixInterval)
+	for range ticker.C {
+		snapshot, _ := configSnapshot(config)
+		collectMatrix(snapshot)
+	}
+}
+
+// currentPartitions returns the latest partition report
+func currentPartitions() partitionReport {
+	partitions.Lock()
+	defer partitions.Unlock()
+	return partitions.report
+}
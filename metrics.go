@@ -0,0 +1,450 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	metricBuildInfo = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fabric_director_build_info",
+			Help: "Version and mode (full or monitor-only) of the running director",
+		},
+		[]string{"version", "mode"},
+	)
+
+	metricIsRerouting = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "fabric_director_is_rerouting",
+		Help: "Is this node rerouting?",
+	})
+
+	metricCandidateNodes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "fabric_director_candidate_nodes",
+		Help: "Number of candidate nodes",
+	})
+
+	metricTargetDown = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fabric_director_target_down_total",
+		Help: "Number of times the active reroute target was confirmed down",
+	})
+
+	metricPrefixRerouted = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fabric_director_prefix_rerouted",
+			Help: "Whether a prefix is rerouted to a target",
+		},
+		[]string{"prefix", "target"},
+	)
+
+	metricTunnelRecreated = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fabric_director_tunnel_recreated_total",
+			Help: "Tunnels recreated after repeated probe failures while the node's underlay answered",
+		},
+		[]string{"node"},
+	)
+	metricProbePanics = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fabric_director_probe_panics_total",
+		Help: "Probe cycles that panicked and were restarted",
+	})
+	metricProbeSweepTimeouts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fabric_director_probe_sweep_timeouts_total",
+		Help: "Probe sweeps that reached their deadline before every node was measured",
+	})
+	metricProbeBlackouts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fabric_director_probe_blackouts_total",
+		Help: "Probe cycles skipped because most nodes failed at once",
+	})
+	metricLateReplies = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fabric_director_probe_late_replies_total",
+		Help: "Probe replies received within probe-grace after the nominal timeout",
+	})
+	metricStandbyMirror = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fabric_director_standby_mirror_total",
+			Help: "Reroute state updates mirrored to the standby director by result (success or failure)",
+		},
+		[]string{"result"},
+	)
+	metricWebhookDelivery = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fabric_director_webhook_delivery_total",
+			Help: "Webhook delivery attempts by result (success, retry, or failure)",
+		},
+		[]string{"result"},
+	)
+
+	metricPanic = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "fabric_director_panic",
+		Help: "Whether panic mode is active",
+	})
+
+	metricActiveTargetLost = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fabric_director_active_target_lost_total",
+		Help: "Number of times the active reroute target was evicted from the candidates",
+	})
+
+	metricNodesDropped = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "fabric_director_metric_nodes_dropped_total",
+			Help: "Per-node metric updates skipped because metric-max-nodes was reached",
+		},
+	)
+
+	metricReady = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "fabric_director_ready",
+		Help: "Whether the first probe cycle has completed",
+	})
+
+	metricProbesBlocked = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "fabric_director_probes_blocked",
+		Help: "Whether every node and reference target shows total loss, suggesting probes are blocked",
+	})
+
+	metricPartitionDetected = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "fabric_director_partition_detected",
+		Help: "Whether any pair of nodes can't reach each other",
+	})
+
+	metricPathMTU = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fabric_director_path_mtu",
+			Help: "Measured underlay path MTU to a node",
+		},
+		[]string{"dst"},
+	)
+
+	metricNodeConsecutiveFailures = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fabric_director_node_consecutive_failures",
+			Help: "Number of consecutive failed probe cycles of a node",
+		},
+		[]string{"dst"},
+	)
+
+	metricNodeSourceLatency = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fabric_director_node_source_latency",
+			Help: "Underlay latency to a node via each configured source interface",
+		},
+		[]string{"dst", "source"},
+	)
+
+	metricNodeProbeSource = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fabric_director_node_probe_source",
+			Help: "Source selection strategy that last yielded replies from a node",
+		},
+		[]string{"dst", "strategy"},
+	)
+
+	metricTunnelMTUOK = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fabric_director_tunnel_mtu_ok",
+			Help: "Whether the measured path MTU to a node can carry full size tunnel packets",
+		},
+		[]string{"dst"},
+	)
+
+	metricTunnelUp = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fabric_director_tunnel_up",
+			Help: "Whether the tunnel keepalive to a node is answered",
+		},
+		[]string{"dst"},
+	)
+
+	metricTerminalPolicy = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fabric_director_terminal_policy_total",
+			Help: "Number of times the terminal policy was applied because no replacement target was available",
+		},
+		[]string{"policy"},
+	)
+
+	metricReroutedSeconds = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fabric_director_rerouted_seconds_total",
+		Help: "Total time spent rerouted, counted when each reroute ends",
+	})
+
+	metricNodeProbeMethod = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fabric_director_node_probe_method",
+			Help: "Probe method that produced the latest reading for a node",
+		},
+		[]string{"dst", "method"},
+	)
+)
+
+// The per-node latency metrics are created by registerNodeMetrics once the
+// configured metric labels are known
+var (
+	metricNodeLatency, metricNodeLatency6, metricNodeJitter *prometheus.GaugeVec
+	metricNodeLatencyHistogram                              *prometheus.HistogramVec // Only with latency-metric-type histogram
+	metricNodeLatencySummary                                *prometheus.SummaryVec   // Only with latency-metric-type summary
+)
+
+// metricRerouteDuration is created by registerNodeMetrics once the configured buckets are known
+var metricRerouteDuration prometheus.Histogram
+
+var labelNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// registerNodeMetrics registers the per-node latency metrics with the src and dst labels plus any node tags promoted
+// to labels by the metric-labels config, and the other metrics that depend on the config
+func registerNodeMetrics(config *Config) error {
+	labels := []string{"src", "dst"}
+	for _, tag := range config.MetricLabels {
+		if !labelNameRegex.MatchString(tag) || tag == "src" || tag == "dst" || tag == config.MetricNodeLabel {
+			return fmt.Errorf("invalid metric label %s", tag)
+		}
+		labels = append(labels, tag)
+	}
+	if config.MetricNodeLabel != "" && !labelNameRegex.MatchString(config.MetricNodeLabel) {
+		return fmt.Errorf("invalid metric node label %s", config.MetricNodeLabel)
+	}
+
+	metricNodeLatency = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fabric_director_node_latency",
+			Help: "Latency from node to node",
+		},
+		labels,
+	)
+	metricNodeLatency6 = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fabric_director_node_latency_v6",
+			Help: "IPv6 latency from node to node",
+		},
+		labels,
+	)
+	metricNodeJitter = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fabric_director_node_jitter",
+			Help: "Standard deviation of latency from node to node",
+		},
+		labels,
+	)
+
+	metricRerouteDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "fabric_director_reroute_duration_seconds",
+		Help:    "Duration of completed reroutes",
+		Buckets: config.RerouteDurationBuckets,
+	})
+
+	// Only one latency distribution type is registered to bound the number of series
+	switch config.LatencyMetricType {
+	case "histogram":
+		metricNodeLatencyHistogram = promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "fabric_director_node_latency_seconds",
+				Help:    "Distribution of latency from node to node",
+				Buckets: prometheus.ExponentialBuckets(0.001, 2, 12),
+			},
+			labels,
+		)
+	case "summary":
+		metricNodeLatencySummary = promauto.NewSummaryVec(
+			prometheus.SummaryOpts{
+				Name:       "fabric_director_node_latency_seconds",
+				Help:       "Per-packet latency quantiles from node to node",
+				Objectives: config.LatencySummaryObjectives,
+			},
+			labels,
+		)
+	}
+	return nil
+}
+
+// nodeLabelGatherer adds a constant label with the local node name to every gathered series, so series from many
+// directors scraped into one Prometheus can be told apart. Series that already have the label are left unchanged.
+type nodeLabelGatherer struct {
+	prometheus.Gatherer
+	label string
+}
+
+func (g nodeLabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			var labeled bool
+			for _, pair := range metric.Label {
+				if pair.GetName() == g.label {
+					labeled = true
+					break
+				}
+			}
+			if labeled {
+				continue
+			}
+			name, value := g.label, localNodeName
+			metric.Label = append(metric.Label, &dto.LabelPair{Name: &name, Value: &value})
+			sort.Slice(metric.Label, func(i, j int) bool { return metric.Label[i].GetName() < metric.Label[j].GetName() })
+		}
+	}
+	return families, err
+}
+
+// observeLatency records a cycle's latency in the configured latency distribution metric. Histograms observe the
+// cycle's average, attaching the probe time as an exemplar if exemplars are enabled, and summaries observe each
+// per-packet RTT.
+func observeLatency(config *Config, name string, latency time.Duration, samples []time.Duration, probeTime time.Time) {
+	if metricNodeLatencySummary != nil {
+		observer := metricNodeLatencySummary.With(nodeLabels(config, name))
+		for _, rtt := range samples {
+			observer.Observe(rtt.Seconds())
+		}
+		return
+	}
+	if metricNodeLatencyHistogram == nil {
+		return
+	}
+	observer := metricNodeLatencyHistogram.With(nodeLabels(config, name))
+	if config.MetricExemplars {
+		observer.(prometheus.ExemplarObserver).ObserveWithExemplar(latency.Seconds(), prometheus.Labels{
+			"probe_time": strconv.FormatInt(probeTime.UnixNano(), 10),
+		})
+		return
+	}
+	observer.Observe(latency.Seconds())
+}
+
+// nodeLabels returns the per-node metric labels for a destination node
+func nodeLabels(config *Config, name string) prometheus.Labels {
+	labels := prometheus.Labels{
+		"src": localNodeName,
+		"dst": name,
+	}
+	for _, tag := range config.MetricLabels {
+		labels[tag] = config.Nodes[name].Tags[tag]
+	}
+	return labels
+}
+
+// exportedNodes holds the labels of each node with exported per-node series so they can be deleted after the node
+// leaves the config, and the nodes refused by the cardinality cap
+var exportedNodes = struct {
+	sync.Mutex
+	labels  map[string]prometheus.Labels
+	dropped map[string]bool
+}{labels: map[string]prometheus.Labels{}, dropped: map[string]bool{}}
+
+// ready is set once the first probe cycle has completed
+var ready int32
+
+// setReady marks the first probe cycle as complete
+func setReady() {
+	if atomic.CompareAndSwapInt32(&ready, 0, 1) {
+		log.Info("First probe cycle complete, metrics ready")
+		metricReady.Set(1)
+	}
+}
+
+// warmingUp returns true if per-node health series should be withheld because metrics-warmup is set and the first
+// probe cycle hasn't completed
+func warmingUp(config *Config) bool {
+	return config.MetricsWarmup && atomic.LoadInt32(&ready) == 0
+}
+
+// exportNode returns true if per-node series may be exported for a node. Nodes are admitted until MetricMaxNodes
+// distinct nodes have series, after which new nodes are dropped and counted.
+func exportNode(config *Config, name string) bool {
+	if warmingUp(config) {
+		return false
+	}
+	exportedNodes.Lock()
+	defer exportedNodes.Unlock()
+	if _, ok := exportedNodes.labels[name]; ok {
+		return true
+	}
+	if config.MetricMaxNodes > 0 && len(exportedNodes.labels) >= config.MetricMaxNodes {
+		if !exportedNodes.dropped[name] {
+			log.Warnf("Not exporting metrics for %s, metric-max-nodes limit of %d reached", name, config.MetricMaxNodes)
+			exportedNodes.dropped[name] = true
+		}
+		metricNodesDropped.Inc()
+		return false
+	}
+	exportedNodes.labels[name] = nodeLabels(config, name)
+	return true
+}
+
+// deleteNodeMetrics deletes all per-node series of a node
+func deleteNodeMetrics(config *Config, name string) {
+	exportedNodes.Lock()
+	labels, ok := exportedNodes.labels[name]
+	delete(exportedNodes.labels, name)
+	delete(exportedNodes.dropped, name)
+	exportedNodes.Unlock()
+	log.Debugf("Deleting metric series for %s", name)
+	if ok {
+		metricNodeLatency.Delete(labels)
+		metricNodeLatency6.Delete(labels)
+		metricNodeJitter.Delete(labels)
+		if metricNodeLatencyHistogram != nil {
+			metricNodeLatencyHistogram.Delete(labels)
+		}
+		if metricNodeLatencySummary != nil {
+			metricNodeLatencySummary.Delete(labels)
+		}
+	}
+	for _, method := range []string{nodeProbeType(config, config.Nodes[name]), config.ProbeFallback} {
+		metricNodeProbeMethod.DeleteLabelValues(name, method)
+	}
+	deleteSourceSeries(name)
+	metricNodeConsecutiveFailures.DeleteLabelValues(name)
+	metricPathMTU.DeleteLabelValues(name)
+	metricTunnelMTUOK.DeleteLabelValues(name)
+	metricTunnelUp.DeleteLabelValues(name)
+}
+
+// pruneNodeMetrics deletes the per-node series of nodes no longer in the config, freeing their slots under the
+// cardinality cap
+func pruneNodeMetrics(config *Config) {
+	stale := map[string]bool{}
+	exportedNodes.Lock()
+	for name := range exportedNodes.labels {
+		stale[name] = true
+	}
+	for name := range exportedNodes.dropped {
+		stale[name] = true
+	}
+	exportedNodes.Unlock()
+	// Source series are exported for every probed node, including those not yet exported while warming up
+	for _, name := range sourceSeriesNodes() {
+		stale[name] = true
+	}
+	for name := range stale {
+		if _, ok := config.Nodes[name]; !ok {
+			deleteNodeMetrics(config, name)
+		}
+	}
+}
+
+// setPrefixRerouted moves each prefix's rerouted series from the node it was rerouted to for the previous target to
+// the one for the new target, or clears them if target is empty, so there is at most one series per configured prefix.
+// Prefixes in prefix-targets are labelled with their own node and prefixes in reroute-deny have no series.
+func setPrefixRerouted(config *Config, previous, target string) {
+	for _, prefix := range config.Prefixes {
+		if previous != "" {
+			if node := prefixTarget(config, prefix, previous); node != "" {
+				metricPrefixRerouted.DeleteLabelValues(prefix, node)
+			}
+		}
+		if target != "" {
+			if node := prefixTarget(config, prefix, target); node != "" {
+				metricPrefixRerouted.WithLabelValues(prefix, node).Set(1)
+			}
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// resetExportedNodes forgets which nodes have per-node series, before and after the test
+func resetExportedNodes(t *testing.T) {
+	reset := func() {
+		exportedNodes.Lock()
+		defer exportedNodes.Unlock()
+		exportedNodes.labels = map[string]prometheus.Labels{}
+		exportedNodes.dropped = map[string]bool{}
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestExportNodeCap(t *testing.T) {
+	config := testConfig(t, "metric-max-nodes: 1\n")
+	resetExportedNodes(t)
+	dropped := testutil.ToFloat64(metricNodesDropped)
+
+	for _, tt := range []struct {
+		name string
+		want bool
+	}{
+		{"fmt2", true},
+		{"sea3", false},
+		{"fmt2", true},
+		{"sea3", false},
+	} {
+		if got := exportNode(config, tt.name); got != tt.want {
+			t.Errorf("exporting %s is %t, want %t", tt.name, got, tt.want)
+		}
+	}
+	if got := testutil.ToFloat64(metricNodesDropped) - dropped; got != 2 {
+		t.Errorf("%.0f drops counted, want 2", got)
+	}
+}
+
+func TestPruneNodeMetrics(t *testing.T) {
+	config := testConfig(t, "")
+	resetExportedNodes(t)
+	metricNodeLatency.Reset()
+	metricNodeConsecutiveFailures.Reset()
+	for _, name := range []string{"fmt2", "sea3"} {
+		if !exportNode(config, name) {
+			t.Fatalf("%s not exported", name)
+		}
+		metricNodeLatency.With(nodeLabels(config, name)).Set(0.02)
+		metricNodeConsecutiveFailures.WithLabelValues(name).Set(1)
+	}
+
+	// sea3 leaves the config
+	next := testConfig(t, "")
+	delete(next.Nodes, "sea3")
+	pruneNodeMetrics(next)
+
+	if got := testutil.CollectAndCount(metricNodeLatency); got != 1 {
+		t.Errorf("%d latency series, want 1", got)
+	}
+	if got := testutil.CollectAndCount(metricNodeConsecutiveFailures); got != 1 {
+		t.Errorf("%d consecutive failure series, want 1", got)
+	}
+	exportedNodes.Lock()
+	_, fmt2 := exportedNodes.labels["fmt2"]
+	_, sea3 := exportedNodes.labels["sea3"]
+	exportedNodes.Unlock()
+	if !fmt2 || sea3 {
+		t.Errorf("exported fmt2 %t and sea3 %t, want only fmt2", fmt2, sea3)
+	}
+}
+
+func TestPruneNodeSourceMetrics(t *testing.T) {
+	testConfig(t, "")
+	resetExportedNodes(t)
+	metricNodeProbeSource.Reset()
+	metricNodeSourceLatency.Reset()
+	// sea3 is probed via its sources but never exported, like a node removed while warming up
+	recordSourceStrategy("sea3", "interface")
+	setSourceLatency("sea3", "eth0", measurement{probeResult: probeResult{Latency: 20 * time.Millisecond}})
+	setSourceLatency("sea3", "eth1", measurement{probeResult: probeResult{Latency: 30 * time.Millisecond}})
+	setSourceLatency("fmt2", "eth0", measurement{probeResult: probeResult{Latency: 20 * time.Millisecond}})
+	// sea3 leaving the config also forgets its selected source before the metrics are pruned
+	forgetNode("sea3")
+
+	next := testConfig(t, "")
+	delete(next.Nodes, "sea3")
+	pruneNodeMetrics(next)
+
+	if got := testutil.CollectAndCount(metricNodeProbeSource); got != 0 {
+		t.Errorf("%d probe source series, want 0", got)
+	}
+	if got := testutil.CollectAndCount(metricNodeSourceLatency); got != 1 {
+		t.Errorf("%d source latency series, want only fmt2's", got)
+	}
+	if nodes := sourceSeriesNodes(); len(nodes) != 1 || nodes[0] != "fmt2" {
+		t.Errorf("source series of %v, want only fmt2", nodes)
+	}
+	deleteSourceSeries("fmt2")
+}
+
+func TestObserveLatencySummary(t *testing.T) {
+	config := testConfig(t, "latency-metric-type: summary\nlatency-summary-objectives: {0.5: 0.05}\n")
+	// The registered distribution depends on the config, so observe into an unregistered one of the configured type
+	summary := prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Name:       "fabric_director_node_latency_seconds",
+		Objectives: config.LatencySummaryObjectives,
+	}, []string{"src", "dst"})
+	metricNodeLatencySummary = summary
+	t.Cleanup(func() { metricNodeLatencySummary = nil })
+
+	samples := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond}
+	observeLatency(config, "fmt2", 20*time.Millisecond, samples, time.Now())
+
+	var m dto.Metric
+	if err := summary.With(nodeLabels(config, "fmt2")).(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	// Every per-packet RTT is observed rather than the cycle's average
+	if got := m.GetSummary().GetSampleCount(); got != 3 {
+		t.Errorf("%d observations, want 3", got)
+	}
+	quantiles := m.GetSummary().GetQuantile()
+	if len(quantiles) != 1 || quantiles[0].GetQuantile() != 0.5 || quantiles[0].GetValue() != 0.02 {
+		t.Errorf("quantiles %v, want the median of 0.02", quantiles)
+	}
+}
+
+func TestObserveRerouteDuration(t *testing.T) {
+	rerouted := testutil.ToFloat64(metricReroutedSeconds)
+	var before dto.Metric
+	if err := metricRerouteDuration.Write(&before); err != nil {
+		t.Fatal(err)
+	}
+
+	observeRerouteDuration(time.Now().Add(-90 * time.Second))
+	// A reroute without a start time isn't counted
+	observeRerouteDuration(time.Time{})
+
+	var after dto.Metric
+	if err := metricRerouteDuration.Write(&after); err != nil {
+		t.Fatal(err)
+	}
+	if got := after.GetHistogram().GetSampleCount() - before.GetHistogram().GetSampleCount(); got != 1 {
+		t.Errorf("observed %d reroute durations, want 1", got)
+	}
+	if got := after.GetHistogram().GetSampleSum() - before.GetHistogram().GetSampleSum(); got < 90 || got > 100 {
+		t.Errorf("observed a %.0fs reroute, want 90s", got)
+	}
+	if got := testutil.ToFloat64(metricReroutedSeconds) - rerouted; got < 90 || got > 100 {
+		t.Errorf("rerouted seconds increased by %.0f, want 90", got)
+	}
+}
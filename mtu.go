@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// greOverhead is the outer IP and GRE header size added to each tunnelled packet over an IPv4 underlay
+const greOverhead = 24
+
+// measurePathMTU finds the largest packet (including IP header) that reaches dst without fragmentation by binary
+// searching DF-set ICMP echo sizes between the protocol minimum and max
+func measurePathMTU(dst string, max int, timeout time.Duration) (int, error) {
+	host, _ := splitZone(dst)
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return 0, fmt.Errorf("invalid destination %s", dst)
+	}
+	ipv6 := ip.To4() == nil
+	headers, lo := 20+8, 576
+	if ipv6 {
+		headers, lo = 40+8, 1280
+	}
+
+	conn, err := listenICMP("", 0, ipv6, true)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	seq := 0
+	fits := func(size int) (bool, error) {
+		// Retry once so a single lost packet doesn't shrink the result
+		for attempt := 0; attempt < 2; attempt++ {
+			seq++
+			_, ok, err := echo(conn, ip, seq, size-headers, time.Now().Add(timeout))
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+
+	ok, err := fits(lo)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("%s unreachable with %d byte packets", dst, lo)
+	}
+	hi := max + 1 // Smallest size known not to fit
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		ok, err := fits(mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// pathMTULoop periodically measures the underlay path MTU to each tunnel's remote and warns when it can't carry full
+// size tunnel packets
+func pathMTULoop(config *Config) {
+	ticker := time.NewTicker(config.PathMTUInterval)
+	for ; true; <-ticker.C {
+		config, p := configSnapshot(config)
+		for _, t := range p.Tunnels {
+			mtu, err := measurePathMTU(t.Remote, config.TunnelMTU+greOverhead, time.Second)
+			if err != nil {
+				log.Warnf("Error measuring path MTU to %s: %s", t.Node, err)
+				continue
+			}
+			log.Debugf("Path MTU to %s is %d", t.Node, mtu)
+			metricPathMTU.WithLabelValues(t.Node).Set(float64(mtu))
+			if mtu-greOverhead < config.TunnelMTU {
+				log.Warnf("Path MTU to %s is %d, too small for tunnel MTU %d (needs %d)", t.Node, mtu, config.TunnelMTU, config.TunnelMTU+greOverhead)
+				metricTunnelMTUOK.WithLabelValues(t.Node).Set(0)
+			} else {
+				metricTunnelMTUOK.WithLabelValues(t.Node).Set(1)
+			}
+		}
+	}
+}
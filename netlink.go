@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/vishvananda/netlink"
+)
+
+// routeLayer is the part of netlink that tunnel addresses and reroute routes are changed through, so tests can
+// replace the kernel with a fake
+type routeLayer interface {
+	LinkList() ([]netlink.Link, error)
+	LinkSetUp(link netlink.Link) error
+	LinkDel(link netlink.Link) error
+	AddrList(link netlink.Link, family int) ([]netlink.Addr, error)
+	AddrAdd(link netlink.Link, addr *netlink.Addr) error
+	AddrDel(link netlink.Link, addr *netlink.Addr) error
+	RouteReplace(route *netlink.Route) error
+	RouteDel(route *netlink.Route) error
+	RouteListFiltered(family int, filter *netlink.Route, filterMask uint64) ([]netlink.Route, error)
+	IPv6Disabled(name string) bool
+}
+
+// kernelRouteLayer applies changes to the host's kernel
+type kernelRouteLayer struct {
+	*netlink.Handle
+}
+
+func (kernelRouteLayer) IPv6Disabled(name string) bool {
+	return ipv6Disabled(name)
+}
+
+// nl is the route layer the director changes tunnels and routes through
+var nl routeLayer = kernelRouteLayer{&netlink.Handle{}}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+// fakeRouteLayer records the changes made through it instead of applying them to the kernel
+type fakeRouteLayer struct {
+	sync.Mutex
+	ipv6Disabled bool
+	ipv6Err      error // Returned when adding an IPv6 address, if set
+	links        []netlink.Link
+	addrs        map[string][]netlink.Addr // Addresses by interface name
+	routes       []netlink.Route
+	calls        []string // Changes made, in order
+}
+
+// useFakeRouteLayer replaces the kernel with a fake route layer for the test
+func useFakeRouteLayer(t *testing.T) *fakeRouteLayer {
+	fake := &fakeRouteLayer{addrs: map[string][]netlink.Addr{}}
+	previous := nl
+	nl = fake
+	t.Cleanup(func() {
+		nl = previous
+		atomic.StoreInt32(&tunnelIPv6Skipped, 0)
+	})
+	return fake
+}
+
+func (f *fakeRouteLayer) record(format string, args ...interface{}) {
+	f.Lock()
+	defer f.Unlock()
+	f.calls = append(f.calls, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeRouteLayer) LinkList() ([]netlink.Link, error) {
+	f.Lock()
+	defer f.Unlock()
+	return f.links, nil
+}
+
+func (f *fakeRouteLayer) LinkSetUp(link netlink.Link) error {
+	f.record("link-up %s", link.Attrs().Name)
+	return nil
+}
+
+func (f *fakeRouteLayer) LinkDel(link netlink.Link) error {
+	f.record("link-del %s", link.Attrs().Name)
+	return nil
+}
+
+func (f *fakeRouteLayer) AddrList(link netlink.Link, _ int) ([]netlink.Addr, error) {
+	f.Lock()
+	defer f.Unlock()
+	return f.addrs[link.Attrs().Name], nil
+}
+
+func (f *fakeRouteLayer) AddrAdd(link netlink.Link, addr *netlink.Addr) error {
+	if addr.IP.To4() == nil && f.ipv6Err != nil {
+		return f.ipv6Err
+	}
+	f.record("addr-add %s %s", link.Attrs().Name, addr.IPNet)
+	f.Lock()
+	defer f.Unlock()
+	f.addrs[link.Attrs().Name] = append(f.addrs[link.Attrs().Name], *addr)
+	return nil
+}
+
+func (f *fakeRouteLayer) AddrDel(link netlink.Link, addr *netlink.Addr) error {
+	f.record("addr-del %s %s", link.Attrs().Name, addr.IPNet)
+	f.Lock()
+	defer f.Unlock()
+	name := link.Attrs().Name
+	for i, a := range f.addrs[name] {
+		if a.IPNet.String() == addr.IPNet.String() {
+			f.addrs[name] = append(f.addrs[name][:i], f.addrs[name][i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeRouteLayer) IPv6Disabled(string) bool {
+	return f.ipv6Disabled
+}
+
+func (f *fakeRouteLayer) RouteReplace(route *netlink.Route) error {
+	f.record("route-replace %s via %s table %d priority %d", route.Dst, route.Gw, route.Table, route.Priority)
+	f.Lock()
+	defer f.Unlock()
+	for i, r := range f.routes {
+		if r.Dst.String() == route.Dst.String() && r.Table == route.Table && r.Priority == route.Priority {
+			f.routes[i] = *route
+			return nil
+		}
+	}
+	f.routes = append(f.routes, *route)
+	return nil
+}
+
+func (f *fakeRouteLayer) RouteDel(route *netlink.Route) error {
+	f.record("route-del %s", route.Dst)
+	f.Lock()
+	defer f.Unlock()
+	for i, r := range f.routes {
+		if r.Dst.String() == route.Dst.String() {
+			f.routes = append(f.routes[:i], f.routes[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("no such process")
+}
+
+func (f *fakeRouteLayer) RouteListFiltered(_ int, filter *netlink.Route, _ uint64) ([]netlink.Route, error) {
+	f.Lock()
+	defer f.Unlock()
+	var routes []netlink.Route
+	for _, r := range f.routes {
+		if r.Dst.String() == filter.Dst.String() {
+			routes = append(routes, r)
+		}
+	}
+	return routes, nil
+}
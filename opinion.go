@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var opinionClient = &http.Client{Timeout: time.Second}
+
+// siblingReaches asks each sibling director in second-opinion for its /matrix view and returns the first sibling
+// that can still reach a node, or an empty string if none can. Unreachable siblings are skipped.
+func siblingReaches(config *Config, name string) string {
+	for _, url := range config.SecondOpinion {
+		resp, err := getMatrix(opinionClient, url, config.MatrixToken)
+		if err != nil {
+			log.Debugf("Error asking %s for a second opinion on %s: %s", url, name, err)
+			continue
+		}
+		view, err := decodeMatrixView(resp)
+		if err != nil {
+			log.Debugf("Error asking %s for a second opinion on %s: %s", url, name, err)
+			continue
+		}
+		if view.Reachable[name] {
+			return view.Node
+		}
+	}
+	return ""
+}
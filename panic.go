@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// panicAuthorized returns true if a request carries the configured panic confirmation token, which is deliberately
+// separate from the admin token
+func panicAuthorized(config *Config, r *http.Request) bool {
+	return bearerAuthorized(r, config.PanicToken)
+}
+
+// panicReroute reroutes all prefixes to the panic target regardless of its measured health and suspends automatic
+// failover and revert until unpanic
+func panicReroute(config *Config) error {
+	node := config.Nodes[config.PanicTarget]
+	reroute.Lock()
+	defer reroute.Unlock()
+	log.Errorf("PANIC MODE: rerouting all prefixes to %s, bypassing health checks", config.PanicTarget)
+	if err := rerouteToLocked(config, config.PanicTarget, &node, "panic"); err != nil {
+		return routeChangeError{err}
+	}
+	reroute.Panic = true
+	metricPanic.Set(1)
+	return nil
+}
+
+// unpanic leaves panic mode and withdraws the panic reroute
+func unpanic(config *Config) error {
+	reroute.Lock()
+	defer reroute.Unlock()
+	if !reroute.Panic {
+		return fmt.Errorf("not in panic mode")
+	}
+	log.Warnf("Leaving panic mode, withdrawing reroute to %s", reroute.Target)
+	if err := noRerouteLocked(config, "unpanic"); err != nil {
+		return routeChangeError{err}
+	}
+	return nil
+}
+
+// panicking returns true if panic mode is active
+func panicking() bool {
+	reroute.Lock()
+	defer reroute.Unlock()
+	return reroute.Panic
+}
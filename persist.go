@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// persistedState is the reroute state saved to the state file so it survives restarts
+type persistedState struct {
+	Active bool      `json:"active"`
+	Target string    `json:"target,omitempty"`
+	Since  time.Time `json:"since,omitempty"`
+	Auto   bool      `json:"auto,omitempty"` // Made by auto-reroute, which withdraws it once local health recovers
+}
+
+// saveStateLocked writes the current reroute state to the state file. The caller must hold the reroute lock.
+func saveStateLocked(config *Config) {
+	if config.StateFile == "" {
+		return
+	}
+	b, err := json.Marshal(persistedState{
+		Active: reroute.Active,
+		Target: reroute.Target,
+		Since:  reroute.Since,
+		Auto:   reroute.Auto,
+	})
+	if err != nil {
+		log.Warnf("Error encoding state: %s", err)
+		return
+	}
+	// Write to a temporary file and rename so a crash can't leave a truncated state file
+	tmp := filepath.Join(filepath.Dir(config.StateFile), "."+filepath.Base(config.StateFile)+".tmp")
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		log.Warnf("Error writing state file: %s", err)
+		return
+	}
+	if err := os.Rename(tmp, config.StateFile); err != nil {
+		log.Warnf("Error writing state file: %s", err)
+	}
+}
+
+// loadState reads the persisted reroute state, returning nil if there is no state file
+func loadState(path string) (*persistedState, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state persistedState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// restoreReroute re-establishes a reroute that was active before a restart
+func restoreReroute(config *Config, state *persistedState) {
+	if state == nil || !state.Active {
+		return
+	}
+	if config.MonitorOnly {
+		log.Warnf("Reroute to %s was active before restart, not restoring in monitor-only mode", state.Target)
+		return
+	}
+	node, name, reason := restoreTarget(config, state)
+	if node == nil {
+		log.Errorf("Reroute to %s was active before restart but no candidate is available, staying local", state.Target)
+		return
+	}
+	log.Infof("Restoring reroute to %s (previously %s)", name, state.Target)
+	if err := restoreTo(config, name, node, reason, state.Auto); err != nil {
+		log.Errorf("Error restoring reroute to %s: %s", name, err)
+	}
+}
+
+// restoreTarget returns the node a restored reroute goes to and why. With restart-target previous the persisted target
+// is kept if it's still a candidate, otherwise (or with restart-target closest) the closest candidate is used.
+func restoreTarget(config *Config, state *persistedState) (*Node, string, string) {
+	if config.RestartTarget == "previous" {
+		if node, ok := candidateNodes.Get(state.Target); ok {
+			return &node, state.Target, "restored previous target"
+		}
+		log.Warnf("Previous reroute target %s is not a candidate, selecting closest", state.Target)
+	}
+	node, name := closestNode(config, "")
+	return node, name, "restored to closest candidate"
+}
+
+// restoreTo reroutes to a node for a restored reroute, which auto-reroute may withdraw again if it made the reroute
+// before the restart
+func restoreTo(config *Config, name string, node *Node, reason string, auto bool) error {
+	reroute.Lock()
+	defer reroute.Unlock()
+	return rerouteToAutoLocked(config, name, node, reason, auto)
+}
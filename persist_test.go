@@ -0,0 +1,75 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveStateRoundTrip(t *testing.T) {
+	config := testConfig(t, "")
+	config.StateFile = filepath.Join(t.TempDir(), "state.json")
+	since := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+
+	reroute.Lock()
+	reroute.Active, reroute.Target, reroute.Since, reroute.Auto = true, "fmt2", since, true
+	saveStateLocked(config)
+	reroute.Active, reroute.Target, reroute.Since, reroute.Auto = false, "", time.Time{}, false
+	reroute.Unlock()
+
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := persistedState{Active: true, Target: "fmt2", Since: since, Auto: true}
+	if state == nil || !state.Since.Equal(want.Since) || state.Active != want.Active || state.Target != want.Target ||
+		state.Auto != want.Auto {
+		t.Errorf("loaded state %+v, want %+v", state, want)
+	}
+}
+
+func TestLoadStateMissingFile(t *testing.T) {
+	state, err := loadState(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil || state != nil {
+		t.Errorf("loadState of a missing file returned %+v, %v, want nil, nil", state, err)
+	}
+}
+
+func TestRestoreTarget(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		extra      string
+		candidates []string
+		wantTarget string
+	}{
+		{"previous still a candidate", "", []string{"fmt2", "sea3"}, "fmt2"},
+		{"previous gone", "", []string{"sea3"}, "sea3"},
+		{"closest", "restart-target: closest\n", []string{"fmt2", "sea3"}, "sea3"},
+		{"no candidate", "", nil, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, tt.extra)
+			latencies := map[string]time.Duration{"fmt2": 40 * time.Millisecond, "sea3": 20 * time.Millisecond}
+			for _, name := range tt.candidates {
+				node := config.Nodes[name]
+				node.Latency = latencies[name]
+				candidateNodes.Set(name, node)
+			}
+
+			node, name, _ := restoreTarget(config, &persistedState{Active: true, Target: "fmt2"})
+			if name != tt.wantTarget || (node == nil) != (tt.wantTarget == "") {
+				t.Errorf("restoring to %q, want %q", name, tt.wantTarget)
+			}
+		})
+	}
+}
+
+func TestRestoreRerouteMonitorOnly(t *testing.T) {
+	config := testConfig(t, "monitor-only: true\n")
+	candidateNodes.Set("fmt2", config.Nodes["fmt2"])
+
+	restoreReroute(config, &persistedState{Active: true, Target: "fmt2"})
+	if reroute.Active {
+		t.Error("reroute restored in monitor-only mode")
+	}
+}
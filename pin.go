@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// probePinMark is the firewall mark of pinned probes and the number of the routing table holding the pinned routes
+const probePinMark = 0x4644
+
+// pinRule returns the policy routing rule that sends marked probe packets to the pinning table
+func pinRule(family int) *netlink.Rule {
+	rule := netlink.NewRule()
+	rule.Family = family
+	rule.Mark = probePinMark
+	rule.Table = probePinMark
+	return rule
+}
+
+// pinRoute returns the route that pins probes to a node through its probe-nexthop
+func pinRoute(config *Config, node Node) (*netlink.Route, error) {
+	dst, _, err := parseZonedIP(nodeUnderlayIP(config, node))
+	if err != nil {
+		return nil, err
+	}
+	gw, linkIndex, err := parseZonedIP(node.ProbeNexthop)
+	if err != nil {
+		return nil, err
+	}
+	bits := 32
+	if dst.To4() == nil {
+		bits = 128
+	}
+	return &netlink.Route{
+		Dst:       &net.IPNet{IP: dst, Mask: net.CIDRMask(bits, bits)},
+		Gw:        gw,
+		LinkIndex: linkIndex,
+		Table:     probePinMark,
+	}, nil
+}
+
+// setupProbePins installs a host route via each node's probe-nexthop in a dedicated table, and a rule directing
+// packets with the probe mark to it, so marked probes take the pinned underlay path while other traffic is unaffected
+func setupProbePins(config *Config) error {
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		_ = netlink.RuleDel(pinRule(family)) // Left over from an unclean exit
+		if err := netlink.RuleAdd(pinRule(family)); err != nil {
+			return fmt.Errorf("error adding probe pinning rule: %s", err)
+		}
+	}
+	for name, node := range config.Nodes {
+		if node.ProbeNexthop == "" || node.ID == config.LocalID {
+			continue
+		}
+		route, err := pinRoute(config, node)
+		if err != nil {
+			return fmt.Errorf("node %s: %s", name, err)
+		}
+		log.Debugf("Pinning probes to %s via %s", name, node.ProbeNexthop)
+		if err := netlink.RouteReplace(route); err != nil {
+			return fmt.Errorf("error adding probe pinning route to %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// cleanupProbePins removes the probe pinning rules and routes
+func cleanupProbePins(config *Config) {
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		if err := netlink.RuleDel(pinRule(family)); err != nil {
+			log.Debugf("Error deleting probe pinning rule: %s", err)
+		}
+	}
+	for name, node := range config.Nodes {
+		if node.ProbeNexthop == "" || node.ID == config.LocalID {
+			continue
+		}
+		route, err := pinRoute(config, node)
+		if err != nil {
+			continue
+		}
+		if err := netlink.RouteDel(route); err != nil {
+			log.Debugf("Error deleting probe pinning route to %s: %s", name, err)
+		}
+	}
+}
+
+// markControl returns a dialer control function that sets SO_MARK on the socket
+func markControl(mark int) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		if err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, mark)
+		}); err != nil {
+			return err
+		}
+		if sockErr != nil {
+			return fmt.Errorf("error setting mark: %s", sockErr)
+		}
+		return nil
+	}
+}
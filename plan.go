@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tunnelPlan is a tunnel the director will create to a remote node
+type tunnelPlan struct {
+	Node       string `json:"node"`
+	Interface  string `json:"interface"`
+	Type       string `json:"type"`
+	Local      string `json:"local"`
+	Remote     string `json:"remote"`
+	Internal4  string `json:"internal4"`
+	Internal6  string `json:"internal6"`
+	ListenPort int    `json:"listen_port,omitempty"` // WireGuard only
+	RemotePort int    `json:"remote_port,omitempty"` // WireGuard and VXLAN only
+	PublicKey  string `json:"public_key,omitempty"`  // WireGuard only
+	VNI        int    `json:"vni,omitempty"`         // VXLAN only
+}
+
+// String returns a compact one line description of the tunnel
+func (t tunnelPlan) String() string {
+	switch t.Type {
+	case "wireguard":
+		return fmt.Sprintf("%s wireguard :%d->%s:%d %s %s", t.Interface, t.ListenPort, t.Remote, t.RemotePort, t.Internal4, t.Internal6)
+	case "vxlan":
+		return fmt.Sprintf("%s vxlan %d %s->%s:%d %s %s", t.Interface, t.VNI, t.Local, t.Remote, t.RemotePort, t.Internal4, t.Internal6)
+	}
+	return fmt.Sprintf("%s %s->%s %s %s", t.Interface, t.Local, t.Remote, t.Internal4, t.Internal6)
+}
+
+// plan is the tunnels, prefixes and effective thresholds the director will apply for a config
+type plan struct {
+	LocalNode string       `json:"local_node"`
+	LocalIP   string       `json:"local_ip"`
+	Tunnels   []tunnelPlan `json:"tunnels"`
+	Prefixes  []string     `json:"prefixes"`
+}
+
+// buildPlan computes the plan for a config without touching the system
+func buildPlan(config *Config) (*plan, error) {
+	var p plan
+	for name, node := range config.Nodes {
+		if node.ID == config.LocalID {
+			p.LocalNode = name
+			p.LocalIP = nodeIP(node.IP)
+			break
+		}
+	}
+	if p.LocalNode == "" || p.LocalIP == "" {
+		return nil, fmt.Errorf("could not find local node %d", config.LocalID)
+	}
+
+	for name, node := range config.Nodes {
+		// Skip local node
+		if node.ID == config.LocalID {
+			continue
+		}
+		t := tunnelPlan{
+			Node:      name,
+			Interface: "fd-" + name,
+			Type:      nodeTunnelType(config, node),
+			Local:     underlayAddr(config.Nodes[p.LocalNode], underlayFamily(config, node)),
+			Remote:    nodeUnderlayIP(config, node),
+			Internal4: internalIP(config.Prefix4, node.ID, config.LocalID, 24),
+		}
+		switch t.Type {
+		case "wireguard":
+			t.ListenPort = wireguardPort(config, node.ID)
+			t.RemotePort = wireguardPort(config, config.LocalID)
+			t.PublicKey = node.PublicKey
+		case "vxlan":
+			t.VNI = vxlanVNI(config, node.ID, config.LocalID)
+			t.RemotePort = config.VxlanPort
+		}
+		if config.AddressFamily != "ipv4" {
+			t.Internal6 = internalIP(config.Prefix6, node.ID, config.LocalID, 112)
+		}
+		p.Tunnels = append(p.Tunnels, t)
+	}
+	sort.Slice(p.Tunnels, func(i, j int) bool { return p.Tunnels[i].Node < p.Tunnels[j].Node })
+	p.Prefixes = config.Prefixes
+	return &p, nil
+}
+
+// logPlan logs a single structured summary of the plan and effective thresholds
+func logPlan(config *Config, p *plan) {
+	tunnels := make([]string, len(p.Tunnels))
+	for i, t := range p.Tunnels {
+		tunnels[i] = t.String()
+	}
+	log.WithFields(log.Fields{
+		"local":                p.LocalNode,
+		"tunnels":              tunnels,
+		"prefixes":             p.Prefixes,
+		"ping_interval":        config.PingInterval.String(),
+		"latency_threshold":    config.LatencyThreshold.String(),
+		"latency_threshold_v4": config.latencyThreshold(false).String(),
+		"latency_threshold_v6": config.latencyThreshold(true).String(),
+		"loss_threshold":       config.LossThreshold,
+		"jitter_threshold":     config.JitterThreshold.String(),
+		"candidate_window":     fmt.Sprintf("%d/%d", config.CandidateWindowPass, config.CandidateWindow),
+		"reroute_via":          config.RerouteVia,
+		"probe":                config.ProbeType,
+	}).Info("Startup plan")
+}
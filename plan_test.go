@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestBuildPlanAddressFamily(t *testing.T) {
+	for _, tt := range []struct {
+		family string
+		want   []tunnelPlan
+	}{
+		{"auto", []tunnelPlan{
+			{Node: "fmt2", Interface: "fd-fmt2", Type: "gre", Local: "192.0.2.10", Remote: "192.0.2.20", Internal4: "172.16.20.10/24", Internal6: "fd00::10:20:10/112"},
+			{Node: "sea3", Interface: "fd-sea3", Type: "gre", Local: "192.0.2.10", Remote: "192.0.2.30", Internal4: "172.16.30.10/24", Internal6: "fd00::10:30:10/112"},
+		}},
+		{"ipv4", []tunnelPlan{
+			{Node: "fmt2", Interface: "fd-fmt2", Type: "gre", Local: "192.0.2.10", Remote: "192.0.2.20", Internal4: "172.16.20.10/24"},
+			{Node: "sea3", Interface: "fd-sea3", Type: "gre", Local: "192.0.2.10", Remote: "192.0.2.30", Internal4: "172.16.30.10/24"},
+		}},
+	} {
+		t.Run(tt.family, func(t *testing.T) {
+			config := testConfig(t, "address-family: "+tt.family+"\n")
+			p, err := buildPlan(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.LocalNode != "pdx1" || p.LocalIP != "192.0.2.10" {
+				t.Errorf("local node %s at %s, want pdx1 at 192.0.2.10", p.LocalNode, p.LocalIP)
+			}
+			if !reflect.DeepEqual(p.Tunnels, tt.want) {
+				t.Errorf("tunnels %+v, want %+v", p.Tunnels, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetupTunnelLinkIPv6Disabled(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		ipv6Disabled bool
+		requireIPv6  bool
+		wantErr      bool
+	}{
+		{"disabled", true, false, false},
+		{"disabled but required", true, true, true},
+		{"enabled", false, false, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, "")
+			fake := useFakeRouteLayer(t)
+			fake.ipv6Disabled = tt.ipv6Disabled
+			fake.ipv6Err = errors.New("permission denied")
+			link := &netlink.Gretun{LinkAttrs: netlink.LinkAttrs{Name: "fd-fmt2", Index: 7}}
+
+			index, err := setupTunnelLink(link, "GRE", "172.16.20.10/24", "fd00::10:20:10/112", tt.requireIPv6)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %t", err, tt.wantErr)
+			}
+
+			fmt2 := config.Nodes["fmt2"]
+			_, nexthop6 := rerouteNexthops(config, &fmt2)
+			if tt.wantErr {
+				// The tunnel isn't brought up and IPv6 reroutes are unaffected
+				if want := []string{"addr-add fd-fmt2 172.16.20.10/24"}; !reflect.DeepEqual(fake.calls, want) {
+					t.Errorf("calls %q, want %q", fake.calls, want)
+				}
+				if nexthop6 == "" {
+					t.Error("no IPv6 nexthop after a failed tunnel")
+				}
+				return
+			}
+			// The tunnel comes up IPv4 only and reroutes no longer use the unreachable IPv6 nexthop
+			if index != 7 {
+				t.Errorf("index %d, want 7", index)
+			}
+			if want := []string{"addr-add fd-fmt2 172.16.20.10/24", "link-up fd-fmt2"}; !reflect.DeepEqual(fake.calls, want) {
+				t.Errorf("calls %q, want %q", fake.calls, want)
+			}
+			if nexthop6 != "" {
+				t.Errorf("IPv6 nexthop %s on IPv4 only tunnels", nexthop6)
+			}
+		})
+	}
+}
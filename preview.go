@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+// plannedRoute is a route change a reroute would make
+type plannedRoute struct {
+	Prefix   string `json:"prefix"`
+	Target   string `json:"target"`                    // Node the prefix is rerouted to
+	Current  string `json:"current_nexthop,omitempty"` // Empty if no route is installed
+	Proposed string `json:"proposed_nexthop"`
+	Table    int    `json:"table"`
+	Priority int    `json:"priority"`
+	Action   string `json:"action,omitempty"` // add, replace or unchanged
+}
+
+// reroutePreview is the JSON body of the /reroute/preview endpoint
+type reroutePreview struct {
+	Target    string         `json:"target"`
+	Reason    string         `json:"reason,omitempty"`
+	StopLocal bool           `json:"stop_local"` // Whether local service would be stopped because no reroute is active yet
+	Routes    []plannedRoute `json:"routes"`
+}
+
+// rerouteDenied returns true if a prefix is in reroute-deny
+func rerouteDenied(config *Config, prefix string) bool {
+	for _, denied := range config.RerouteDeny {
+		if denied == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixTarget returns the node a prefix is rerouted to when rerouting to target, or an empty string if the prefix is
+// never rerouted
+func prefixTarget(config *Config, prefix, target string) string {
+	if rerouteDenied(config, prefix) {
+		return ""
+	}
+	if name, ok := config.PrefixTargets[prefix]; ok {
+		return name
+	}
+	return target
+}
+
+// reroutedPrefixes returns the prefixes a reroute changes, which is every prefix not in reroute-deny
+func reroutedPrefixes(config *Config) []string {
+	var prefixes []string
+	for _, prefix := range config.Prefixes {
+		if !rerouteDenied(config, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// reroutePlan returns the routes a reroute to the named node installs. Prefixes in reroute-deny are left out and
+// prefixes in prefix-targets are routed to their own node. Both reroutes and previews use the plan, so a preview shows
+// exactly the routes a reroute installs.
+func reroutePlan(config *Config, name string, node *Node) ([]plannedRoute, error) {
+	var routes []plannedRoute
+	for _, prefix := range config.Prefixes {
+		target := prefixTarget(config, prefix, name)
+		if target == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(prefix)
+		if err != nil {
+			return nil, err
+		}
+		via := node
+		if target != name {
+			n := config.Nodes[target]
+			via = &n
+		}
+		nexthop4, nexthop6 := rerouteNexthops(config, via)
+		r := plannedRoute{Prefix: prefix, Target: target, Proposed: nexthop4, Table: syscall.RT_TABLE_MAIN, Priority: 1}
+		if ipNet.IP.To4() == nil {
+			r.Proposed = nexthop6
+		}
+		if r.Proposed == "" {
+			return nil, fmt.Errorf("no nexthop for %s via %s", prefix, target)
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// PreviewReroute returns the route changes a reroute to the named node, or to the default target if to is empty,
+// would make without applying them
+func (d *Director) PreviewReroute(to string) (*reroutePreview, error) {
+	preview := &reroutePreview{Target: to}
+	var node *Node
+	if to == "" {
+		node, preview.Target, preview.Reason = defaultTarget(d.config)
+		if node == nil {
+			return nil, fmt.Errorf("no candidate nodes (%s)", preview.Reason)
+		}
+	} else {
+		n, ok := d.config.Nodes[to]
+		if !ok {
+			return nil, fmt.Errorf("%w %s", errUnknownNode, to)
+		}
+		node = &n
+	}
+	preview.StopLocal = activeTarget() == ""
+
+	routes, err := reroutePlan(d.config, preview.Target, node)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range routes {
+		_, ipNet, err := net.ParseCIDR(r.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		family := netlink.FAMILY_V4
+		if ipNet.IP.To4() == nil {
+			family = netlink.FAMILY_V6
+		}
+		installed, err := nl.RouteListFiltered(family, &netlink.Route{Dst: ipNet}, netlink.RT_FILTER_DST)
+		if err != nil {
+			return nil, fmt.Errorf("error listing routes for %s: %s", r.Prefix, err)
+		}
+		proposed, _, err := parseZonedIP(r.Proposed)
+		if err != nil {
+			return nil, err
+		}
+		r.Current, r.Action = routeAction(installed, proposed, r.Priority)
+		preview.Routes = append(preview.Routes, r)
+	}
+	return preview, nil
+}
+
+// routeAction returns the current nexthop of the installed route a reroute would change and whether the reroute adds,
+// replaces or leaves it unchanged
+func routeAction(routes []netlink.Route, proposed net.IP, priority int) (string, string) {
+	current, action := "", "add"
+	for _, route := range routes {
+		if route.Table != syscall.RT_TABLE_MAIN || route.Priority != priority {
+			continue
+		}
+		action = "replace"
+		if route.Gw != nil {
+			current = route.Gw.String()
+			if route.Gw.Equal(proposed) {
+				action = "unchanged"
+			}
+		}
+	}
+	return current, action
+}
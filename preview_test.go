@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestRouteAction(t *testing.T) {
+	proposed := net.ParseIP("172.16.10.20")
+	for _, tt := range []struct {
+		name        string
+		routes      []netlink.Route
+		wantCurrent string
+		wantAction  string
+	}{
+		{"no route", nil, "", "add"},
+		{"other table", []netlink.Route{{Table: 100, Priority: 1, Gw: net.ParseIP("172.16.10.30")}}, "", "add"},
+		{"other priority", []netlink.Route{{Table: syscall.RT_TABLE_MAIN, Priority: 100, Gw: net.ParseIP("172.16.10.30")}}, "", "add"},
+		{"other nexthop", []netlink.Route{{Table: syscall.RT_TABLE_MAIN, Priority: 1, Gw: net.ParseIP("172.16.10.30")}}, "172.16.10.30", "replace"},
+		{"without nexthop", []netlink.Route{{Table: syscall.RT_TABLE_MAIN, Priority: 1}}, "", "replace"},
+		{"same nexthop", []netlink.Route{{Table: syscall.RT_TABLE_MAIN, Priority: 1, Gw: net.ParseIP("172.16.10.20")}}, "172.16.10.20", "unchanged"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			current, action := routeAction(tt.routes, proposed, 1)
+			if current != tt.wantCurrent || action != tt.wantAction {
+				t.Errorf("current %q action %s, want %q %s", current, action, tt.wantCurrent, tt.wantAction)
+			}
+		})
+	}
+}
+
+func TestPreviewRerouteErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		extra    string
+		prefixes string
+		to       string
+		wantErr  string
+	}{
+		{"unknown node", "", "198.51.100.0/24", "lax9", "unknown node lax9"},
+		{"no candidates", "", "198.51.100.0/24", "", "no candidate nodes"},
+		{"no IPv6 nexthop", "reroute-via: underlay\n", "2001:db8::/48", "fmt2", "no nexthop for 2001:db8::/48"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := strings.Replace(testConfigYAML, "198.51.100.0/24", tt.prefixes, 1) + tt.extra
+			config, err := loadTestConfig(t, yaml)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := &Director{config: config}
+			if _, err := d.PreviewReroute(tt.to); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPreviewMatchesReroute(t *testing.T) {
+	// 203.0.113.0/24 always goes to sea3 and 100.64.0.0/24 is never rerouted
+	yaml := strings.Replace(testConfigYAML, "prefixes: [198.51.100.0/24]",
+		"prefixes: [198.51.100.0/24, 2001:db8::/48, 203.0.113.0/24, 100.64.0.0/24]", 1) +
+		"prefix-targets: {203.0.113.0/24: sea3}\nreroute-deny: [100.64.0.0/24]\n"
+	config, err := loadTestConfig(t, yaml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := useFakeRouteLayer(t)
+	t.Cleanup(func() {
+		reroute.Lock()
+		defer reroute.Unlock()
+		reroute.Active, reroute.Target, reroute.Since = false, "", time.Time{}
+		metricIsRerouting.Set(0)
+		metricPrefixRerouted.Reset()
+	})
+	d := &Director{config: config}
+
+	preview, err := d.PreviewReroute("fmt2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.calls) != 0 {
+		t.Fatalf("preview changed %q", fake.calls)
+	}
+	fmt2 := config.Nodes["fmt2"]
+	if err := rerouteTo(config, "fmt2", &fmt2, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	var previewed, installed []string
+	for _, r := range preview.Routes {
+		if r.Action != "add" {
+			t.Errorf("%s action %s, want add", r.Prefix, r.Action)
+		}
+		previewed = append(previewed, fmt.Sprintf("%s via %s table %d priority %d", r.Prefix, r.Proposed, r.Table, r.Priority))
+	}
+	for _, r := range fake.routes {
+		installed = append(installed, fmt.Sprintf("%s via %s table %d priority %d", r.Dst, r.Gw, r.Table, r.Priority))
+	}
+	want := []string{
+		"198.51.100.0/24 via 172.16.10.20 table 254 priority 1",
+		"2001:db8::/48 via fd00::10:10:20 table 254 priority 1",
+		"203.0.113.0/24 via 172.16.10.30 table 254 priority 1",
+	}
+	if !reflect.DeepEqual(previewed, want) {
+		t.Errorf("previewed %q, want %q", previewed, want)
+	}
+	if !reflect.DeepEqual(installed, previewed) {
+		t.Errorf("rerouting installed %q, previewed %q", installed, previewed)
+	}
+
+	// Once rerouted the same preview changes nothing
+	preview, err = d.PreviewReroute("fmt2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range preview.Routes {
+		if r.Action != "unchanged" {
+			t.Errorf("%s action %s after rerouting, want unchanged", r.Prefix, r.Action)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-ping/ping"
+	log "github.com/sirupsen/logrus"
+)
+
+// probeTarget describes where a probe is sent from and to
+type probeTarget struct {
+	Src    string // Source IP
+	Dst    string // Destination IP
+	Device string // Interface to bind the probe socket to, or empty to bind to Src
+	Mark   int    // Firewall mark selecting the pinned path, or zero
+}
+
+// probeResult is the outcome of probing a host
+type probeResult struct {
+	Latency time.Duration   // Average RTT
+	Jitter  time.Duration   // Standard deviation of RTTs
+	Loss    float64         // Percent of probes lost
+	Samples []time.Duration // Individual RTTs of the probes that got replies
+}
+
+// Prober measures the latency and packet loss to a remote host
+type Prober interface {
+	Probe(target probeTarget) (probeResult, error)
+}
+
+// summarizeRtts returns the probe result for a set of RTTs received out of sent probes
+func summarizeRtts(rtts []time.Duration, sent int) probeResult {
+	if len(rtts) == 0 || sent == 0 {
+		return probeResult{Loss: 100}
+	}
+	var total time.Duration
+	for _, rtt := range rtts {
+		total += rtt
+	}
+	mean := total / time.Duration(len(rtts))
+	var variance float64
+	for _, rtt := range rtts {
+		d := float64(rtt - mean)
+		variance += d * d
+	}
+	variance /= float64(len(rtts))
+	return probeResult{
+		Latency: mean,
+		Jitter:  time.Duration(math.Sqrt(variance)),
+		Loss:    float64(sent-len(rtts)) / float64(sent) * 100,
+		Samples: rtts,
+	}
+}
+
+// probeOptions controls how many samples a prober takes and how quickly
+type probeOptions struct {
+	Count    int
+	Interval time.Duration // Zero uses the prober's default
+	Timeout  time.Duration
+	Grace    time.Duration // Extra time to collect replies after Timeout, late replies still count as received
+}
+
+// wait returns how long a prober collects replies
+func (o probeOptions) wait() time.Duration {
+	return o.Timeout + o.Grace
+}
+
+// countLate counts the replies that only arrived within the grace period after the nominal timeout
+func (o probeOptions) countLate(rtts []time.Duration) {
+	if o.Grace == 0 {
+		return
+	}
+	for _, rtt := range rtts {
+		if rtt > o.Timeout {
+			metricLateReplies.Inc()
+		}
+	}
+}
+
+// defaultProbeOptions are used for routine probing of every node
+var defaultProbeOptions = probeOptions{Count: 3, Timeout: 500 * time.Millisecond}
+
+// icmpProber probes a host with ICMP echo requests
+type icmpProber struct {
+	probeOptions
+}
+
+// Probe uses ICMP pings to measure the latency of a remote host
+func (p *icmpProber) Probe(target probeTarget) (probeResult, error) {
+	if target.Device != "" || target.Mark != 0 {
+		return p.probeDevice(target)
+	}
+
+	log.Debugf("Pinging %s from %s", target.Dst, target.Src)
+	pinger, err := ping.NewPinger(target.Dst)
+	if err != nil {
+		return probeResult{}, err
+	}
+	pinger.Source = target.Src
+	pinger.Count = p.Count
+	pinger.Timeout = p.wait()
+	if p.Interval != 0 {
+		pinger.Interval = p.Interval
+	}
+	pinger.SetPrivileged(false)
+	err = pinger.Run()
+	if err != nil {
+		return probeResult{}, err
+	}
+	stats := pinger.Statistics()
+	p.countLate(stats.Rtts)
+	return probeResult{
+		Latency: stats.AvgRtt,
+		Jitter:  stats.StdDevRtt,
+		Loss:    stats.PacketLoss,
+		Samples: stats.Rtts,
+	}, nil
+}
+
+// tcpProber probes a host by timing TCP connection setup to a port
+type tcpProber struct {
+	probeOptions
+	Port uint16
+}
+
+// Probe measures the latency of a remote host by opening TCP connections to it. A refused connection still counts as a
+// reply since the remote host answered with a RST.
+func (p *tcpProber) Probe(target probeTarget) (probeResult, error) {
+	dialer := net.Dialer{Timeout: p.wait()}
+	if target.Mark != 0 {
+		log.Debugf("TCP probing %s port %d with mark %d", target.Dst, p.Port, target.Mark)
+		dialer.Control = markControl(target.Mark)
+	} else if target.Device != "" {
+		log.Debugf("TCP probing %s port %d via %s", target.Dst, p.Port, target.Device)
+		dialer.Control = bindToDeviceControl(target.Device)
+	} else {
+		log.Debugf("TCP probing %s port %d from %s", target.Dst, p.Port, target.Src)
+		srcHost, srcZone := splitZone(target.Src)
+		dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(srcHost), Zone: srcZone}
+	}
+	addr := net.JoinHostPort(target.Dst, strconv.Itoa(int(p.Port)))
+
+	var rtts []time.Duration
+	for i := 0; i < p.Count; i++ {
+		if i > 0 && p.Interval != 0 {
+			time.Sleep(p.Interval)
+		}
+		start := time.Now()
+		conn, err := dialer.Dial("tcp", addr)
+		rtt := time.Since(start)
+		if err == nil {
+			_ = conn.Close()
+		} else if !errors.Is(err, syscall.ECONNREFUSED) {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return probeResult{}, err
+		}
+		rtts = append(rtts, rtt)
+	}
+	p.countLate(rtts)
+	return summarizeRtts(rtts, p.Count), nil
+}
+
+// newProber returns a prober for the given probe type
+func newProber(probeType string, config *Config, opts probeOptions) (Prober, error) {
+	switch probeType {
+	case "", "icmp":
+		return &icmpProber{probeOptions: opts}, nil
+	case "tcp":
+		if config.ProbePort == 0 {
+			return nil, fmt.Errorf("tcp probe requires probe-port to be set")
+		}
+		return &tcpProber{probeOptions: opts, Port: config.ProbePort}, nil
+	default:
+		return nil, fmt.Errorf("unknown probe type %s", probeType)
+	}
+}
+
+// probeWithFallback probes a host with the primary prober, retrying with the fallback prober (if set) when the primary
+// errors. It returns the name of the method that produced the reading.
+func probeWithFallback(primary, fallback Prober, primaryName, fallbackName string, target probeTarget) (probeResult, string, error) {
+	result, err := primary.Probe(target)
+	if err == nil || fallback == nil {
+		return result, primaryName, err
+	}
+
+	log.Debugf("Primary %s probe of %s failed (%s), falling back to %s", primaryName, target.Dst, err, fallbackName)
+	result, fallbackErr := fallback.Probe(target)
+	if fallbackErr != nil {
+		return probeResult{}, fallbackName, fmt.Errorf("%s probe: %s, %s probe: %s", primaryName, err, fallbackName, fallbackErr)
+	}
+	return result, fallbackName, nil
+}
+
+// probeSet holds the probers used by the ping loop
+type probeSet struct {
+	Primary    Prober
+	Supervised Prober // Primary prober with extra samples for the active reroute target and fallbacks
+	Fallback   Prober
+	byProfile  map[probeProfile]*probeSet // Primary and supervised probers of the per-node probe overrides
+	workers    sync.WaitGroup             // Sweep workers still running, including those a timed out sweep left behind
+	leftover   int32                      // Number of those workers, for logging
+}
+
+// probeProfile is the probe type and options a node is probed with
+type probeProfile struct {
+	Type    string
+	Count   int
+	Timeout time.Duration
+}
+
+// globalProbeProfile returns the probe profile of nodes that don't override any probe setting
+func globalProbeProfile(config *Config) probeProfile {
+	return probeProfile{Type: config.ProbeType, Count: defaultProbeOptions.Count, Timeout: defaultProbeOptions.Timeout}
+}
+
+// nodeProbeProfile returns the probe profile of a node, the global one with the node's overrides applied
+func nodeProbeProfile(config *Config, node Node) probeProfile {
+	profile := globalProbeProfile(config)
+	profile.Type = nodeProbeType(config, node)
+	if node.PingCount != 0 {
+		profile.Count = node.PingCount
+	}
+	if node.ProbeTimeout != 0 {
+		profile.Timeout = node.ProbeTimeout
+	}
+	return profile
+}
+
+// newProbeSet creates the probers for a config, including those of any probe profile a node overrides the global one
+// with
+func newProbeSet(config *Config) (*probeSet, error) {
+	probes, err := newProfileProbers(config, globalProbeProfile(config))
+	if err != nil {
+		return nil, err
+	}
+	if config.ProbeFallback != "" {
+		opts := defaultProbeOptions
+		opts.Grace = config.ProbeGrace
+		probes.Fallback, err = newProber(config.ProbeFallback, config, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	probes.byProfile = map[probeProfile]*probeSet{}
+	if err := probes.addNodeProbers(config); err != nil {
+		return nil, err
+	}
+	return probes, nil
+}
+
+// addNodeProbers creates the probers of every node probe profile that differs from the global one and doesn't have
+// probers yet. It must not run concurrently with measure.
+func (p *probeSet) addNodeProbers(config *Config) error {
+	for name, node := range config.Nodes {
+		profile := nodeProbeProfile(config, node)
+		if _, ok := p.byProfile[profile]; ok || profile == globalProbeProfile(config) {
+			continue
+		}
+		profileProbes, err := newProfileProbers(config, profile)
+		if err != nil {
+			return fmt.Errorf("node %s: %s", name, err)
+		}
+		p.byProfile[profile] = profileProbes
+	}
+	return nil
+}
+
+// newProfileProbers creates the primary and supervised probers of a probe profile
+func newProfileProbers(config *Config, profile probeProfile) (*probeSet, error) {
+	var probes probeSet
+	var err error
+	probes.Primary, err = newProber(profile.Type, config, probeOptions{
+		Count:   profile.Count,
+		Timeout: profile.Timeout,
+		Grace:   config.ProbeGrace,
+	})
+	if err != nil {
+		return nil, err
+	}
+	probes.Supervised, err = newProber(profile.Type, config, probeOptions{
+		Count:    config.TargetProbeCount,
+		Interval: config.TargetProbeInterval,
+		Timeout:  profile.Timeout,
+		Grace:    config.ProbeGrace,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &probes, nil
+}
+
+// nodeProbeType returns the probe type of a node, which is the global probe-type unless the node overrides it
+func nodeProbeType(config *Config, node Node) string {
+	if node.ProbeType != "" {
+		return node.ProbeType
+	}
+	return config.ProbeType
+}
+
+// measurement is the result of probing a node over one address family
+type measurement struct {
+	probeResult
+	Method   string
+	Err      error
+	NotReady bool // Skipped because no source was ready, the node's health is unknown rather than down
+}
+
+// measure probes a node's internal IP over IPv4 or IPv6, taking extra samples if it's the active reroute target or a
+// configured fallback. Each configured source strategy is tried in order until one yields replies. If source interfaces
+// are configured, IPv4 probes go to the node's underlay address via each interface instead, and with probe pinning they
+// go to the node's underlay address via its probe-nexthop.
+func (p *probeSet) measure(config *Config, name string, node Node, ipv6 bool) measurement {
+	probeType := nodeProbeType(config, node)
+	probers := p
+	if profileProbes, ok := p.byProfile[nodeProbeProfile(config, node)]; ok {
+		probers = profileProbes
+	}
+	prober := probers.Primary
+	if isSupervised(config, name) {
+		prober = probers.Supervised
+	}
+	prefix := config.Prefix4
+	if ipv6 {
+		prefix = config.Prefix6
+	}
+
+	if len(config.SourceInterfaces) > 0 && !ipv6 {
+		return p.measureSources(config, name, node, prober, probeType)
+	}
+	if config.ProbePinning && node.ProbeNexthop != "" && !ipv6 {
+		// Probe the underlay address so the probe itself takes the pinned path rather than the tunnel
+		var m measurement
+		target := probeTarget{Dst: nodeUnderlayIP(config, node), Mark: probePinMark}
+		m.probeResult, m.Method, m.Err = probeWithFallback(prober, p.Fallback, probeType, config.ProbeFallback, target)
+		return m
+	}
+
+	m := measurement{NotReady: true}
+	for i, strategy := range config.ProbeSources {
+		target := sourceTarget(config, name, node, prefix, strategy)
+		if strategy == "source" && config.UnreadySource == "skip" && !sourceReady(target.Src) {
+			log.Debugf("Source %s for %s not ready, skipping probe", target.Src, name)
+			continue
+		}
+		m.NotReady = false
+		m.probeResult, m.Method, m.Err = probeWithFallback(prober, p.Fallback, probeType, config.ProbeFallback, target)
+		if m.Err == nil && m.Loss < 100 {
+			if !ipv6 {
+				recordSourceStrategy(name, strategy)
+			}
+			break
+		}
+		if i < len(config.ProbeSources)-1 {
+			log.Debugf("No replies from %s using %s source strategy, trying next", name, strategy)
+		}
+	}
+	return m
+}
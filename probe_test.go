@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeProber returns a fixed result and error and records the targets it probed
+type fakeProber struct {
+	result  probeResult
+	err     error
+	probes  int
+	targets []probeTarget
+}
+
+func (p *fakeProber) Probe(target probeTarget) (probeResult, error) {
+	p.probes++
+	p.targets = append(p.targets, target)
+	return p.result, p.err
+}
+
+// proberFunc adapts a function to a Prober
+type proberFunc func(probeTarget) (probeResult, error)
+
+func (f proberFunc) Probe(target probeTarget) (probeResult, error) {
+	return f(target)
+}
+
+func TestProbeWithFallback(t *testing.T) {
+	answered := probeResult{Latency: 20 * time.Millisecond}
+	failed := errors.New("socket: operation not permitted")
+	for _, tt := range []struct {
+		name          string
+		primary       *fakeProber
+		fallback      *fakeProber
+		wantMethod    string
+		wantErr       bool
+		wantFallbacks int
+	}{
+		{"primary answers", &fakeProber{result: answered}, &fakeProber{result: answered}, "icmp", false, 0},
+		{"primary lost without error", &fakeProber{result: probeResult{Loss: 100}}, &fakeProber{result: answered}, "icmp", false, 0},
+		{"primary errors", &fakeProber{err: failed}, &fakeProber{result: answered}, "tcp", false, 1},
+		{"both error", &fakeProber{err: failed}, &fakeProber{err: failed}, "tcp", true, 1},
+		{"no fallback", &fakeProber{err: failed}, nil, "icmp", true, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var fallback Prober
+			if tt.fallback != nil {
+				fallback = tt.fallback
+			}
+			_, method, err := probeWithFallback(tt.primary, fallback, "icmp", "tcp", probeTarget{Dst: "192.0.2.20"})
+			if method != tt.wantMethod {
+				t.Errorf("method %s, want %s", method, tt.wantMethod)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error %v, want error %t", err, tt.wantErr)
+			}
+			if tt.fallback != nil && tt.fallback.probes != tt.wantFallbacks {
+				t.Errorf("fallback probed %d times, want %d", tt.fallback.probes, tt.wantFallbacks)
+			}
+		})
+	}
+}
+
+func TestSummarizeRtts(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		rtts        []time.Duration
+		sent        int
+		wantLatency time.Duration
+		wantJitter  time.Duration
+		wantLoss    float64
+	}{
+		{"all answered", []time.Duration{10 * time.Millisecond, 30 * time.Millisecond}, 2, 20 * time.Millisecond, 10 * time.Millisecond, 0},
+		{"partial loss", []time.Duration{10 * time.Millisecond}, 4, 10 * time.Millisecond, 0, 75},
+		{"all lost", nil, 3, 0, 0, 100},
+		{"nothing sent", nil, 0, 0, 0, 100},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := summarizeRtts(tt.rtts, tt.sent)
+			if got.Latency != tt.wantLatency || got.Jitter != tt.wantJitter || got.Loss != tt.wantLoss {
+				t.Errorf("got latency %s jitter %s loss %.1f, want %s %s %.1f",
+					got.Latency, got.Jitter, got.Loss, tt.wantLatency, tt.wantJitter, tt.wantLoss)
+			}
+		})
+	}
+}
+
+func TestProbeGrace(t *testing.T) {
+	ms := time.Millisecond
+	rtts := []time.Duration{80 * ms, 120 * ms, 140 * ms}
+	for _, tt := range []struct {
+		name     string
+		opts     probeOptions
+		wantWait time.Duration
+		wantLate float64
+	}{
+		{"without grace", probeOptions{Timeout: 100 * ms}, 100 * ms, 0},
+		{"with grace", probeOptions{Timeout: 100 * ms, Grace: 50 * ms}, 150 * ms, 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.wait(); got != tt.wantWait {
+				t.Errorf("waits %s, want %s", got, tt.wantWait)
+			}
+			late := testutil.ToFloat64(metricLateReplies)
+			tt.opts.countLate(rtts)
+			if got := testutil.ToFloat64(metricLateReplies) - late; got != tt.wantLate {
+				t.Errorf("counted %.0f late replies, want %.0f", got, tt.wantLate)
+			}
+		})
+	}
+}
+
+func TestNewProbeSetGrace(t *testing.T) {
+	config := testConfig(t, "probe-type: tcp\nprobe-port: 443\nprobe-fallback: tcp\nprobe-grace: 200ms\n")
+	probes, err := newProbeSet(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, prober := range map[string]Prober{"primary": probes.Primary, "supervised": probes.Supervised, "fallback": probes.Fallback} {
+		if tcp, ok := prober.(*tcpProber); !ok || tcp.Grace != 200*time.Millisecond {
+			t.Errorf("%s prober %+v, want a TCP prober with probe-grace", name, prober)
+		}
+	}
+}
+
+func TestNodeProbeTypeOverride(t *testing.T) {
+	config := testConfig(t, "probe-port: 443\nunready-source: probe\n")
+	fmt2 := config.Nodes["fmt2"]
+	fmt2.ProbeType = "tcp"
+	config.Nodes["fmt2"] = fmt2
+	if got := nodeProbeType(config, fmt2); got != "tcp" {
+		t.Errorf("fmt2 probe type %s, want its override tcp", got)
+	}
+	if got := nodeProbeType(config, config.Nodes["sea3"]); got != "icmp" {
+		t.Errorf("sea3 probe type %s, want the global icmp", got)
+	}
+
+	probes, err := newProbeSet(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcpProfile := nodeProbeProfile(config, fmt2)
+	if profileProbes, ok := probes.byProfile[tcpProfile]; !ok || len(probes.byProfile) != 1 {
+		t.Errorf("probers by profile %+v, want only fmt2's", probes.byProfile)
+	} else if _, ok := profileProbes.Primary.(*tcpProber); !ok {
+		t.Errorf("fmt2 prober %+v, want a TCP prober", profileProbes.Primary)
+	}
+
+	answered := probeResult{Latency: 20 * time.Millisecond}
+	icmp, tcp := &fakeProber{result: answered}, &fakeProber{result: answered}
+	p := &probeSet{Primary: icmp, Supervised: icmp, byProfile: map[probeProfile]*probeSet{tcpProfile: {Primary: tcp, Supervised: tcp}}}
+	if m := p.measure(config, "fmt2", config.Nodes["fmt2"], false); m.Method != "tcp" || tcp.probes != 1 {
+		t.Errorf("fmt2 measured by %s, want tcp", m.Method)
+	}
+	if m := p.measure(config, "sea3", config.Nodes["sea3"], false); m.Method != "icmp" || icmp.probes != 1 {
+		t.Errorf("sea3 measured by %s, want icmp", m.Method)
+	}
+
+	fmt2.ProbeType = "http"
+	config.Nodes["fmt2"] = fmt2
+	if _, err := newProbeSet(config); err == nil {
+		t.Error("unknown node probe type accepted")
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// tunnelMatches returns an empty string if an existing link has a planned tunnel's type, endpoints and MTU, or a
+// description of the first difference found. Addresses are checked separately since they can be corrected in place.
+func tunnelMatches(config *Config, link netlink.Link, t tunnelPlan) string {
+	switch t.Type {
+	case "wireguard":
+		return wireguardMatches(config, link, t)
+	case "vxlan":
+		return vxlanMatches(config, link, t)
+	}
+	gre, ok := link.(*netlink.Gretun)
+	if !ok {
+		return fmt.Sprintf("type is %s, not gre", link.Type())
+	}
+	local, _, err := parseZonedIP(t.Local)
+	if err != nil || !gre.Local.Equal(local) {
+		return fmt.Sprintf("local is %s, not %s", gre.Local, t.Local)
+	}
+	remote, _, err := parseZonedIP(t.Remote)
+	if err != nil || !gre.Remote.Equal(remote) {
+		return fmt.Sprintf("remote is %s, not %s", gre.Remote, t.Remote)
+	}
+	if gre.Attrs().MTU != config.TunnelMTU {
+		return fmt.Sprintf("mtu is %d, not %d", gre.Attrs().MTU, config.TunnelMTU)
+	}
+	return ""
+}
+
+// addrDrift returns the internal addresses on an existing tunnel that aren't planned and the planned addresses that
+// are missing
+func addrDrift(link netlink.Link, t tunnelPlan) ([]netlink.Addr, []string, error) {
+	addrs, err := nl.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing addresses: %s", err)
+	}
+	stale, missing := diffAddrs(addrs, t)
+	return stale, missing, nil
+}
+
+// diffAddrs compares a tunnel's addresses with its planned internal addresses. Addresses are compared parsed, so the
+// same address and prefix length written differently, such as an IPv4 address in its IPv6-mapped form, isn't drift.
+func diffAddrs(addrs []netlink.Addr, t tunnelPlan) ([]netlink.Addr, []string) {
+	want := map[string]net.IPNet{}
+	for _, addr := range []string{t.Internal4, t.Internal6} {
+		if addr == "" {
+			continue
+		}
+		ipNet, err := parseCIDR(addr)
+		if err != nil {
+			continue // Not an address the kernel can hold, so adding it reports the error
+		}
+		want[addr] = ipNet
+	}
+
+	var stale []netlink.Addr
+	for _, addr := range addrs {
+		if addr.IP.IsLinkLocalUnicast() {
+			continue // Assigned by the kernel, not by us
+		}
+		planned := ""
+		for name, ipNet := range want {
+			if addr.IPNet != nil && ipNetEqual(*addr.IPNet, ipNet) {
+				planned = name
+				break
+			}
+		}
+		if planned != "" {
+			delete(want, planned)
+			continue
+		}
+		stale = append(stale, addr)
+	}
+	var missing []string
+	for _, addr := range []string{t.Internal4, t.Internal6} {
+		if _, ok := want[addr]; ok {
+			missing = append(missing, addr)
+		}
+	}
+	sort.Strings(missing)
+	return stale, missing
+}
+
+// ipNetEqual returns true if two networks have the same address and prefix length
+func ipNetEqual(a, b net.IPNet) bool {
+	aOnes, aBits := a.Mask.Size()
+	bOnes, bBits := b.Mask.Size()
+	if a.IP.To4() != nil && b.IP.To4() != nil {
+		// An IPv4 mask may be held in its 16 byte form
+		aOnes, aBits = aOnes-(aBits-32), 32
+		bOnes, bBits = bOnes-(bBits-32), 32
+	}
+	return a.IP.Equal(b.IP) && aOnes == bOnes && aBits == bBits
+}
+
+// correctTunnelAddrs fixes address drift on an existing tunnel in place, removing stale internal addresses and adding
+// missing ones without recreating the interface
+func correctTunnelAddrs(link netlink.Link, t tunnelPlan) error {
+	stale, missing, err := addrDrift(link, t)
+	if err != nil {
+		return err
+	}
+	for _, addr := range stale {
+		log.Infof("Removing stale address %s from %s", addr.IPNet, t.Interface)
+		addr := addr
+		if err := nl.AddrDel(link, &addr); err != nil {
+			return fmt.Errorf("error removing %s from %s: %s", addr.IPNet, t.Interface, err)
+		}
+	}
+	for _, addr := range missing {
+		ipNet, err := parseCIDR(addr)
+		if err != nil {
+			return err
+		}
+		log.Infof("Adding missing address %s to %s", addr, t.Interface)
+		if err := nl.AddrAdd(link, &netlink.Addr{IPNet: &ipNet}); err != nil {
+			return fmt.Errorf("error adding %s to %s: %s", addr, t.Interface, err)
+		}
+	}
+	return nil
+}
+
+// addTunnel adds a planned tunnel of its type and returns the interface index
+func addTunnel(config *Config, t tunnelPlan) (int, error) {
+	switch t.Type {
+	case "wireguard":
+		return addWireguard(config, t)
+	case "vxlan":
+		return addVXLAN(config, t)
+	}
+	return addGRE(t.Interface, t.Local, t.Remote, t.Internal4, t.Internal6, config.TunnelMTU, config.AddressFamily == "dual")
+}
+
+// tunnelAction is the change reconciliation makes to one fd- interface
+type tunnelAction struct {
+	Node      string `json:"node,omitempty"`
+	Interface string `json:"interface"`
+	Action    string `json:"action"` // keep, correct, recreate, add, or remove
+	Detail    string `json:"detail,omitempty"`
+	tunnel    tunnelPlan
+	link      netlink.Link
+}
+
+// diffTunnels compares the fd- interfaces with the plan without changing anything. Matching tunnels are kept, or
+// corrected in place if only their addresses drifted, tunnels that differ otherwise are recreated, missing tunnels are
+// added and interfaces not in the plan are removed.
+func diffTunnels(config *Config, p *plan) ([]tunnelAction, error) {
+	links, err := fabricLinks()
+	if err != nil {
+		return nil, err
+	}
+	existing := map[string]netlink.Link{}
+	for _, link := range links {
+		existing[link.Attrs().Name] = link
+	}
+
+	var actions []tunnelAction
+	for _, t := range p.Tunnels {
+		action := tunnelAction{Node: t.Node, Interface: t.Interface, Action: "add", tunnel: t}
+		if link, ok := existing[t.Interface]; ok {
+			delete(existing, t.Interface)
+			action.link = link
+			action.Action = "keep"
+			if diff := tunnelMatches(config, link, t); diff != "" {
+				action.Action = "recreate"
+				action.Detail = diff
+			} else if stale, missing, err := addrDrift(link, t); err != nil {
+				action.Detail = err.Error()
+			} else if len(stale) > 0 || len(missing) > 0 {
+				action.Action = "correct"
+				var drift []string
+				for _, addr := range stale {
+					drift = append(drift, "-"+addr.IPNet.String())
+				}
+				for _, addr := range missing {
+					drift = append(drift, "+"+addr)
+				}
+				action.Detail = "addresses " + strings.Join(drift, " ")
+			}
+		}
+		actions = append(actions, action)
+	}
+	var removed []tunnelAction
+	for name, link := range existing {
+		removed = append(removed, tunnelAction{Interface: name, Action: "remove", Detail: "not in plan", link: link})
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].Interface < removed[j].Interface })
+	return append(actions, removed...), nil
+}
+
+// reconcileTunnels brings the fd- interfaces in line with the plan: matching tunnels are left untouched so traffic
+// over them isn't interrupted, tunnels that differ are recreated, missing tunnels are added and tunnels and metric
+// series of nodes no longer in the plan are removed
+func reconcileTunnels(config *Config, p *plan) error {
+	actions, err := diffTunnels(config, p)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	var created int
+	for _, a := range actions {
+		t := a.tunnel
+		switch a.Action {
+		case "keep", "correct":
+			log.Debugf("Keeping existing %s tunnel to %s", t.Type, t.Node)
+			if err := correctTunnelAddrs(a.link, t); err != nil {
+				log.Warnf("Error correcting addresses of %s tunnel to %s: %s", t.Type, t.Node, err)
+			}
+			if err := nl.LinkSetUp(a.link); err != nil {
+				log.Warnf("Error bringing up %s interface %s: %s", t.Type, t.Interface, err)
+			}
+			continue
+		case "remove":
+			log.Infof("Removing %s interface %s not in plan", a.link.Type(), a.Interface)
+			if err := nl.LinkDel(a.link); err != nil {
+				log.Warnf("Error deleting %s interface %s: %s", a.link.Type(), a.Interface, err)
+			}
+			continue
+		case "recreate":
+			log.Infof("Recreating %s tunnel to %s: %s", t.Type, t.Node, a.Detail)
+			if err := nl.LinkDel(a.link); err != nil {
+				log.Warnf("Error deleting %s interface %s: %s", a.link.Type(), t.Interface, err)
+				continue
+			}
+		default:
+			log.Infof("Adding %s tunnel to %s", t.Type, t.Node)
+		}
+		// Spread tunnel creation out to avoid spiking netlink load on constrained hosts
+		if created > 0 && config.TunnelSetupInterval > 0 {
+			time.Sleep(config.TunnelSetupInterval)
+		}
+		created++
+		if _, err := addTunnel(config, t); err != nil {
+			log.Warn(err)
+		}
+	}
+	log.Infof("Set up %d of %d tunnels in %s", created, len(p.Tunnels), time.Since(start).Round(time.Millisecond))
+
+	// Drop the metrics of nodes that left the config so they aren't exported at their last value forever
+	pruneNodeMetrics(config)
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+// testAddr returns a netlink address of a CIDR as the kernel reports it
+func testAddr(t *testing.T, cidr string) netlink.Addr {
+	t.Helper()
+	ipNet, err := parseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return netlink.Addr{IPNet: &ipNet}
+}
+
+func TestDiffAddrs(t *testing.T) {
+	planned := tunnelPlan{Internal4: "172.16.20.10/24", Internal6: "fd00:0:0:10::20:10/64"}
+	for _, tt := range []struct {
+		name        string
+		addrs       []string
+		wantStale   []string
+		wantMissing []string
+	}{
+		{"in sync", []string{"172.16.20.10/24", "fd00:0:0:10::20:10/64"}, nil, nil},
+		{"none assigned", nil, nil, []string{"172.16.20.10/24", "fd00:0:0:10::20:10/64"}},
+		{"wrong prefix length", []string{"172.16.20.10/16", "fd00:0:0:10::20:10/64"}, []string{"172.16.20.10/16"}, []string{"172.16.20.10/24"}},
+		{"stale address", []string{"172.16.20.10/24", "fd00:0:0:10::20:10/64", "172.16.30.10/24"}, []string{"172.16.30.10/24"}, nil},
+		{"IPv6 written differently", []string{"172.16.20.10/24", "fd00:0000:0000:0010:0000:0000:0020:0010/64"}, nil, nil},
+		{"link-local ignored", []string{"172.16.20.10/24", "fd00:0:0:10::20:10/64", "fe80::1/64"}, nil, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var addrs []netlink.Addr
+			for _, cidr := range tt.addrs {
+				addrs = append(addrs, testAddr(t, cidr))
+			}
+			stale, missing := diffAddrs(addrs, planned)
+			var staleCIDRs []string
+			for _, addr := range stale {
+				staleCIDRs = append(staleCIDRs, addr.IPNet.String())
+			}
+			if !reflect.DeepEqual(staleCIDRs, tt.wantStale) {
+				t.Errorf("stale %v, want %v", staleCIDRs, tt.wantStale)
+			}
+			if !reflect.DeepEqual(missing, tt.wantMissing) {
+				t.Errorf("missing %v, want %v", missing, tt.wantMissing)
+			}
+		})
+	}
+}
+
+func TestDiffAddrsLongIPv4Mask(t *testing.T) {
+	// An IPv4 address with its mask in 16 byte form is the same network as the planned one
+	addr := netlink.Addr{IPNet: &net.IPNet{IP: net.ParseIP("172.16.20.10"), Mask: net.CIDRMask(120, 128)}}
+	stale, missing := diffAddrs([]netlink.Addr{addr}, tunnelPlan{Internal4: "172.16.20.10/24"})
+	if len(stale) != 0 || len(missing) != 0 {
+		t.Errorf("stale %v and missing %v, want none", stale, missing)
+	}
+}
+
+func TestReconcileTunnelsCorrectsAddrs(t *testing.T) {
+	config := testConfig(t, "")
+	fake := useFakeRouteLayer(t)
+	p, err := buildPlan(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only fmt2's tunnel is planned, so nothing is added
+	p.Tunnels = p.Tunnels[:1]
+	planned := p.Tunnels[0]
+
+	la := netlink.NewLinkAttrs()
+	la.Name = "fd-fmt2"
+	la.MTU = config.TunnelMTU
+	fake.links = []netlink.Link{&netlink.Gretun{
+		LinkAttrs: la,
+		Local:     net.ParseIP("192.0.2.10"),
+		Remote:    net.ParseIP("192.0.2.20"),
+	}}
+	// The IPv6 address is in place but the IPv4 one is left over from a previous prefix4
+	fake.addrs["fd-fmt2"] = []netlink.Addr{testAddr(t, "172.17.20.10/24"), testAddr(t, planned.Internal6)}
+
+	if err := reconcileTunnels(config, p); err != nil {
+		t.Fatal(err)
+	}
+
+	// The stale address is replaced in place without recreating the tunnel
+	want := []string{"addr-del fd-fmt2 172.17.20.10/24", "addr-add fd-fmt2 172.16.20.10/24", "link-up fd-fmt2"}
+	if !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("calls %q, want %q", fake.calls, want)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// tunnelRecreations holds when each node's tunnel was last recreated and the recreations in progress
+var tunnelRecreations = struct {
+	sync.Mutex
+	last    map[string]time.Time
+	running map[string]bool
+}{last: map[string]time.Time{}, running: map[string]bool{}}
+
+// recreateDue returns true if a node's tunnel should be considered for recreation after failures consecutive failed
+// cycles. Recreations of a node are at least tunnel-recreate-interval apart so a node that is really down doesn't have
+// its tunnel rebuilt every cycle.
+func recreateDue(config *Config, name string, failures int, now time.Time) bool {
+	if config.TunnelRecreateAfter == 0 || failures < config.TunnelRecreateAfter {
+		return false
+	}
+	tunnelRecreations.Lock()
+	defer tunnelRecreations.Unlock()
+	if tunnelRecreations.running[name] || now.Sub(tunnelRecreations.last[name]) < config.TunnelRecreateInterval {
+		return false
+	}
+	tunnelRecreations.running[name] = true
+	tunnelRecreations.last[name] = now
+	return true
+}
+
+// recreateTunnel rebuilds the tunnel to a node in the background if the node's underlay address still answers probes,
+// since then the tunnel itself is the likely fault, and schedules the node to be probed again on the next cycle
+func recreateTunnel(config *Config, prober Prober, name string, node Node) {
+	underlay := nodeUnderlayIP(config, node)
+	go func() {
+		defer func() {
+			tunnelRecreations.Lock()
+			delete(tunnelRecreations.running, name)
+			tunnelRecreations.Unlock()
+		}()
+
+		result, err := prober.Probe(probeTarget{Dst: underlay})
+		if err != nil || result.Loss >= 100 {
+			log.Debugf("Not recreating tunnel to %s, underlay is unreachable too", name)
+			return
+		}
+		log.Warnf("Tunnel to %s is failing but its underlay answers (%s), recreating it", name, result.Latency)
+		configLock.RLock()
+		err = rebuildTunnel(config, name)
+		configLock.RUnlock()
+		if err != nil {
+			log.Errorf("Error recreating tunnel to %s: %s", name, err)
+			return
+		}
+		metricTunnelRecreated.WithLabelValues(name).Inc()
+		events.Add("tunnel-recreated", name, fmt.Sprintf("underlay latency %s", result.Latency))
+		probeNextCycle(name)
+	}()
+}
+
+// rebuildTunnel deletes and re-adds the tunnel to a node as planned
+func rebuildTunnel(config *Config, name string) error {
+	p, err := buildPlan(config)
+	if err != nil {
+		return err
+	}
+	for _, t := range p.Tunnels {
+		if t.Node != name {
+			continue
+		}
+		if link, err := netlink.LinkByName(t.Interface); err == nil {
+			if err := netlink.LinkDel(link); err != nil {
+				return fmt.Errorf("error deleting tunnel interface %s: %s", t.Interface, err)
+			}
+		}
+		_, err := addTunnel(config, t)
+		return err
+	}
+	return fmt.Errorf("no tunnel planned to %s", name)
+}
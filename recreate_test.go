@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecreateDue(t *testing.T) {
+	config := testConfig(t, "tunnel-recreate-after: 3\ntunnel-recreate-interval: 10m\n")
+	now := time.Now()
+	for _, tt := range []struct {
+		name     string
+		failures int
+		at       time.Time
+		done     bool // Whether the previous recreation finished
+		want     bool
+	}{
+		{"below threshold", 2, now, true, false},
+		{"at threshold", 3, now, false, true},
+		{"still running", 4, now.Add(time.Hour), false, false},
+		{"within interval", 4, now.Add(5 * time.Minute), true, false},
+		{"after interval", 5, now.Add(10 * time.Minute), true, true},
+	} {
+		if got := recreateDue(config, "fmt2", tt.failures, tt.at); got != tt.want {
+			t.Errorf("%s: recreate due %t, want %t", tt.name, got, tt.want)
+		}
+		if tt.done {
+			tunnelRecreations.Lock()
+			delete(tunnelRecreations.running, "fmt2")
+			tunnelRecreations.Unlock()
+		}
+	}
+	tunnelRecreations.Lock()
+	delete(tunnelRecreations.running, "fmt2")
+	tunnelRecreations.Unlock()
+
+	disabled := testConfig(t, "")
+	if recreateDue(disabled, "sea3", 100, now) {
+		t.Error("recreate due without tunnel-recreate-after")
+	}
+}
+
+func TestRecreateTunnelUnderlayDown(t *testing.T) {
+	config := testConfig(t, "tunnel-recreate-after: 1\n")
+	if !recreateDue(config, "fmt2", 1, time.Now()) {
+		t.Fatal("recreate isn't due")
+	}
+	prober := &fakeProber{result: probeResult{Loss: 100}}
+	recreateTunnel(config, prober, "fmt2", config.Nodes["fmt2"])
+
+	// The recreation is skipped once the underlay doesn't answer either
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		tunnelRecreations.Lock()
+		running := tunnelRecreations.running["fmt2"]
+		tunnelRecreations.Unlock()
+		if !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("recreation didn't finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(prober.targets) != 1 || prober.targets[0].Dst != "192.0.2.20" {
+		t.Errorf("probed %+v, want the underlay 192.0.2.20", prober.targets)
+	}
+	if got := testutil.ToFloat64(metricTunnelRecreated.WithLabelValues("fmt2")); got != 0 {
+		t.Errorf("counted %.0f recreations, want 0", got)
+	}
+}
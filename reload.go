@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// configLock guards the running config and runningPlan against reloads. The probe loop is the only goroutine that
+// replaces them, taking the write lock to do so, and reads them without locking. Every other goroutine holds the read
+// lock while it uses the config, and goroutines that outlive their caller copy the settings they need first.
+var configLock sync.RWMutex
+
+// runningPlan is the tunnel plan of the running config
+var runningPlan *plan
+
+// configSnapshot returns a copy of the running config and its tunnel plan, for goroutines that use them for longer than
+// they should hold the read lock. The copy shares its maps and slices with the running config, which are never
+// modified once loaded. It must not be called while holding the read lock.
+func configSnapshot(config *Config) (*Config, *plan) {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	snapshot := *config
+	return &snapshot, runningPlan
+}
+
+// lockConfig serves API requests while holding the config read lock. /reload waits for the probe loop to take the
+// write lock, and the /metrics collectors take the read lock themselves, so those two are served without it.
+func lockConfig(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/reload" && r.URL.Path != "/metrics" {
+			configLock.RLock()
+			defer configLock.RUnlock()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reloadRequests carries reloads requested by SIGHUP or /reload to the probe loop, which applies them between cycles
+// so a cycle never sees half of an old and half of a new config
+var reloadRequests = make(chan chan error)
+
+// errShuttingDown is returned for reloads requested once the probe loop is being stopped
+var errShuttingDown = errors.New("shutting down")
+
+// requestReload asks the probe loop to reload the config file and waits for the result. It gives up if the probe loop
+// is stopped or ctx is done first, though a reload the loop already started still completes.
+func requestReload(ctx context.Context) error {
+	done := make(chan error, 1)
+	select {
+	case reloadRequests <- done:
+	case <-probesStopping:
+		return errShuttingDown
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-probesStopping:
+		return errShuttingDown
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// restartOnlyChange returns the key of the first setting that differs between two configs and is only applied at
+// startup, or an empty string if the configs can be swapped at runtime
+func restartOnlyChange(current, next *Config) string {
+	for _, setting := range []struct {
+		key           string
+		current, next interface{}
+	}{
+		{"local-id", current.LocalID, next.LocalID},
+		{"prefix4", current.Prefix4, next.Prefix4},
+		{"prefix6", current.Prefix6, next.Prefix6},
+		{"listen", current.Listen, next.Listen},
+		{"grpc-listen", current.GRPCListen, next.GRPCListen},
+		{"tls-cert", current.TLSCert, next.TLSCert},
+		{"tls-key", current.TLSKey, next.TLSKey},
+		{"tls-client-ca", current.TLSClientCA, next.TLSClientCA},
+		{"probe-type", current.ProbeType, next.ProbeType},
+		{"probe-fallback", current.ProbeFallback, next.ProbeFallback},
+		{"probe-bind", current.ProbeBind, next.ProbeBind},
+		{"probe-pinning", current.ProbePinning, next.ProbePinning},
+		{"metric-labels", current.MetricLabels, next.MetricLabels},
+		{"metric-node-label", current.MetricNodeLabel, next.MetricNodeLabel},
+		{"candidate-info-labels", current.CandidateInfoLabels, next.CandidateInfoLabels},
+		{"latency-metric-type", current.LatencyMetricType, next.LatencyMetricType},
+		{"reroute-duration-buckets", current.RerouteDurationBuckets, next.RerouteDurationBuckets},
+		{"event-log-size", current.EventLogSize, next.EventLogSize},
+		{"state-file", current.StateFile, next.StateFile},
+		{"kv-backend", current.KVBackend, next.KVBackend},
+		{"standby-peer", current.StandbyPeer, next.StandbyPeer},
+		{"partition-detection", current.PartitionDetection, next.PartitionDetection},
+		{"path-mtu-probe", current.PathMTUProbe, next.PathMTUProbe},
+		{"keepalive-interval", current.KeepaliveInterval, next.KeepaliveInterval},
+		{"link-watch", current.LinkWatch, next.LinkWatch},
+		{"otlp-endpoint", current.OTLPEndpoint, next.OTLPEndpoint},
+		{"monitor-only", current.MonitorOnly, next.MonitorOnly},
+	} {
+		if !reflect.DeepEqual(setting.current, setting.next) {
+			return setting.key
+		}
+	}
+	return ""
+}
+
+// nodeChanges returns the names of nodes added to and removed from a config, sorted
+func nodeChanges(current, next *Config) ([]string, []string) {
+	var added, removed []string
+	for name := range next.Nodes {
+		if _, ok := current.Nodes[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	for name := range current.Nodes {
+		if _, ok := next.Nodes[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// forgetNode drops everything recorded about a node removed from the config, so nothing can select or report it
+func forgetNode(name string) {
+	candidateNodes.Delete(name)
+	forgetSmoothing(name)
+	lastSamples.Lock()
+	delete(lastSamples.nodes, name)
+	delete(lastSamples.window, name)
+	lastSamples.Unlock()
+	healthWindows.Lock()
+	delete(healthWindows.nodes, name)
+	healthWindows.Unlock()
+	schedules.Lock()
+	delete(schedules.nodes, name)
+	schedules.Unlock()
+	exclusions.Lock()
+	delete(exclusions.reasons, name)
+	exclusions.Unlock()
+	tunnelState.Lock()
+	delete(tunnelState.failures, name)
+	delete(tunnelState.down, name)
+	tunnelState.Unlock()
+	tunnelRecreations.Lock()
+	delete(tunnelRecreations.last, name)
+	tunnelRecreations.Unlock()
+	reachability.Lock()
+	delete(reachability.nodes, name)
+	reachability.Unlock()
+	selectedSources.Lock()
+	delete(selectedSources.nodes, name)
+	selectedSources.Unlock()
+	simulatedDown.Lock()
+	delete(simulatedDown.nodes, name)
+	simulatedDown.Unlock()
+	drainingPeers.Lock()
+	delete(drainingPeers.nodes, name)
+	drainingPeers.Unlock()
+}
+
+// reloadConfig loads the config file again and applies it in place: tunnels to added nodes are created, tunnels to
+// removed nodes are deleted along with everything recorded about them, probers are created for new per-node probe
+// overrides and every other setting takes effect from the next probe cycle. The running config is left unchanged if
+// the new one is invalid or changes a setting that is only applied at startup. Must be called from the probe loop.
+func reloadConfig(config *Config, probes *probeSet) error {
+	next, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	if key := restartOnlyChange(config, next); key != "" {
+		return fmt.Errorf("%s changed, restart to apply it", key)
+	}
+	dnsCache.Lock()
+	onChange := dnsCache.onChange
+	dnsCache.Unlock()
+	if err := primeDNSCache(next, onChange); err != nil {
+		return err
+	}
+	p, err := buildPlan(next)
+	if err != nil {
+		return err
+	}
+
+	// Probe workers a timed out sweep left running still use the config and probers
+	probes.wait()
+	added, removed := nodeChanges(config, next)
+	configLock.Lock()
+	reroute.Lock()
+	if reroute.Active {
+		if _, ok := next.Nodes[reroute.Target]; !ok {
+			reroute.Unlock()
+			configLock.Unlock()
+			return fmt.Errorf("active reroute target %s was removed, withdraw the reroute first", reroute.Target)
+		}
+		if !reflect.DeepEqual(config.Prefixes, next.Prefixes) || !reflect.DeepEqual(config.PrefixTargets, next.PrefixTargets) ||
+			!reflect.DeepEqual(config.RerouteDeny, next.RerouteDeny) {
+			reroute.Unlock()
+			configLock.Unlock()
+			return fmt.Errorf("prefixes changed while a reroute is active, withdraw the reroute first")
+		}
+	}
+	if err := probes.addNodeProbers(next); err != nil {
+		reroute.Unlock()
+		configLock.Unlock()
+		return err
+	}
+	*config = *next
+	runningPlan = p
+	reroute.Unlock()
+	for _, name := range removed {
+		forgetNode(name)
+	}
+	configLock.Unlock()
+
+	if err := reconcileTunnels(config, p); err != nil {
+		log.Errorf("Error reconciling tunnels after reload: %s", err)
+	}
+	if config.ProbePinning {
+		if err := setupProbePins(config); err != nil {
+			log.Errorf("Error updating probe pinning after reload: %s", err)
+		}
+	}
+
+	detail := fmt.Sprintf("%d nodes", len(config.Nodes))
+	if len(added) > 0 {
+		detail += ", added " + strings.Join(added, " ")
+	}
+	if len(removed) > 0 {
+		detail += ", removed " + strings.Join(removed, " ")
+	}
+	log.Infof("Reloaded %s: %s", *configFile, detail)
+	logPlan(config, p)
+	events.Add("reload", "", detail)
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestReloadWithoutProbeLoop(t *testing.T) {
+	// Nothing receives reload requests, as once the probe loop has stopped
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := requestReload(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error %v, want the request context's", err)
+	}
+
+	stopping := make(chan struct{})
+	previous := probesStopping
+	probesStopping = stopping
+	t.Cleanup(func() { probesStopping = previous })
+	close(stopping)
+	if err := requestReload(context.Background()); !errors.Is(err, errShuttingDown) {
+		t.Errorf("error %v, want %v", err, errShuttingDown)
+	}
+
+	config := testConfig(t, "admin-token: secret\n")
+	mux := newAPIMux(config, &Director{config: config})
+	req := httptest.NewRequest(http.MethodPost, "/reload", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("reload status %d while shutting down, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestRequestReloadWaitsForResult(t *testing.T) {
+	loadErr := errors.New("invalid config")
+	go func() {
+		done := <-reloadRequests
+		done <- loadErr
+	}()
+	if err := requestReload(context.Background()); err != loadErr {
+		t.Errorf("error %v, want %v", err, loadErr)
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// targetHealth is the live health of the active reroute target
+type targetHealth struct {
+	Latency  time.Duration `json:"latency"`
+	Loss     float64       `json:"loss"`
+	Healthy  bool          `json:"healthy"`
+	Failures int           `json:"consecutive_failures"`
+	Updated  time.Time     `json:"updated"`
+}
+
+// rerouteState tracks the active reroute. All fields are guarded by the embedded mutex, which is also held for the
+// duration of route changes so that API and ping loop transitions don't interleave.
+type rerouteState struct {
+	sync.Mutex
+	Active             bool
+	Target             string
+	Since              time.Time
+	Health             targetHealth
+	RevertPendingSince time.Time // When local health recovered, zero if no revert is pending
+	Panic              bool      // Rerouted to the panic target, automatic failover and revert are suspended
+	Auto               bool      // Rerouted by auto-reroute, which withdraws the reroute once local health recovers
+}
+
+var reroute = &rerouteState{}
+
+// errMonitorOnly is returned by routing changes in monitor-only mode
+var errMonitorOnly = fmt.Errorf("monitor-only mode, routing changes are disabled")
+
+// rerouteTo reroutes all prefixes to a node, replacing the current target if a reroute is already active. The reroute
+// is explicit, so auto-reroute no longer withdraws it.
+func rerouteTo(config *Config, name string, node *Node, reason string) error {
+	reroute.Lock()
+	defer reroute.Unlock()
+	return rerouteToAutoLocked(config, name, node, reason, false)
+}
+
+// rerouteToAutoLocked is rerouteToLocked that also records whether auto-reroute made the reroute, before the state is
+// saved. The caller must hold the reroute lock.
+func rerouteToAutoLocked(config *Config, name string, node *Node, reason string, auto bool) error {
+	wasAuto := reroute.Auto
+	reroute.Auto = auto
+	if err := rerouteToLocked(config, name, node, reason); err != nil {
+		reroute.Auto = wasAuto
+		return err
+	}
+	return nil
+}
+
+// rerouteToLocked is rerouteTo for callers already holding the reroute lock
+func rerouteToLocked(config *Config, name string, node *Node, reason string) (err error) {
+	if config.MonitorOnly {
+		return errMonitorOnly
+	}
+	ctx, span := startTransitionSpan(config, "reroute", name, reason)
+	defer func() { endSpan(span, err) }()
+
+	routes, err := reroutePlan(config, name, node)
+	if err != nil {
+		return err
+	}
+	previous := reroute.Target
+	if reroute.Active {
+		log.Infof("Switching reroute target from %s to %s", previous, name)
+		for _, r := range routes {
+			r := r
+			if err := traceNetlink(ctx, "route-replace "+r.Prefix, func() error {
+				return addRoute(r)
+			}); err != nil {
+				return err
+			}
+		}
+	} else {
+		if err := traceNetlink(ctx, "set-reroute", func() error {
+			return setReroute(true, routes)
+		}); err != nil {
+			return err
+		}
+		previous = ""
+		reroute.Since = time.Now()
+	}
+
+	setPrefixRerouted(config, previous, name)
+	reroute.Active = true
+	reroute.Target = name
+	reroute.Health = targetHealth{}
+	saveStateLocked(config)
+	exportKVStateLocked(config)
+	mirrorStateLocked(config)
+	events.Add("reroute", name, reason)
+	sendWebhook(config, webhookEvent{
+		Event:    "reroute",
+		Target:   name,
+		Previous: previous,
+		Reason:   reason,
+	})
+	return nil
+}
+
+// preflightTarget probes a target's reroute nexthop immediately before routes are installed and returns an error if
+// the target appears unreachable, catching targets that failed since their candidate data was last updated
+func preflightTarget(config *Config, prober Prober, node *Node) (probeResult, error) {
+	nexthop4, nexthop6 := rerouteNexthops(config, node)
+	nexthop := nexthop4
+	if nexthop == "" {
+		nexthop = nexthop6
+	}
+	if nexthop == "" {
+		return probeResult{}, fmt.Errorf("no nexthop to probe")
+	}
+	host, _ := splitZone(nexthop)
+	result, err := prober.Probe(probeTarget{Dst: host})
+	if err != nil {
+		return result, err
+	}
+	if result.Loss >= 100 {
+		return result, fmt.Errorf("no replies from %s", host)
+	}
+	return result, nil
+}
+
+// healthyFraction returns the fraction of remote nodes that are currently candidates
+func healthyFraction(config *Config) float64 {
+	remote := len(config.Nodes) - 1
+	if remote <= 0 {
+		return 0
+	}
+	return float64(candidateNodes.Len()) / float64(remote)
+}
+
+// degradedEverywhere returns an error if fewer than MinHealthyFraction of nodes are healthy, in which case the problem
+// is assumed to be systemic and rerouting would only move traffic onto an already struggling node
+func degradedEverywhere(config *Config) error {
+	if config.MinHealthyFraction == 0 {
+		return nil
+	}
+	if fraction := healthyFraction(config); fraction < config.MinHealthyFraction {
+		return fmt.Errorf("only %.0f%% of nodes healthy (minimum %.0f%%)", fraction*100, config.MinHealthyFraction*100)
+	}
+	return nil
+}
+
+// noReroute withdraws the active reroute and restores local service
+func noReroute(config *Config, reason string) error {
+	reroute.Lock()
+	defer reroute.Unlock()
+	return noRerouteLocked(config, reason)
+}
+
+// noRerouteLocked is noReroute for callers already holding the reroute lock
+func noRerouteLocked(config *Config, reason string) (err error) {
+	if config.MonitorOnly {
+		return errMonitorOnly
+	}
+	ctx, span := startTransitionSpan(config, "noreroute", "", reason)
+	defer func() { endSpan(span, err) }()
+
+	var routes []plannedRoute
+	for _, prefix := range reroutedPrefixes(config) {
+		routes = append(routes, plannedRoute{Prefix: prefix})
+	}
+	if err := traceNetlink(ctx, "clear-reroute", func() error {
+		return setReroute(false, routes)
+	}); err != nil {
+		return err
+	}
+	previous := reroute.Target
+	setPrefixRerouted(config, previous, "")
+	if reroute.Active {
+		observeRerouteDuration(reroute.Since)
+	}
+	reroute.Active = false
+	reroute.Target = ""
+	reroute.Health = targetHealth{}
+	reroute.RevertPendingSince = time.Time{}
+	reroute.Auto = false
+	if reroute.Panic {
+		reroute.Panic = false
+		metricPanic.Set(0)
+	}
+	saveStateLocked(config)
+	exportKVStateLocked(config)
+	mirrorStateLocked(config)
+	events.Add("noreroute", previous, reason)
+	sendWebhook(config, webhookEvent{
+		Event:    "noreroute",
+		Previous: previous,
+		Reason:   reason,
+	})
+	return nil
+}
+
+// observeRerouteDuration records the duration of a reroute that started at since and just ended
+func observeRerouteDuration(since time.Time) {
+	if since.IsZero() {
+		return
+	}
+	duration := time.Since(since).Seconds()
+	metricRerouteDuration.Observe(duration)
+	metricReroutedSeconds.Add(duration)
+}
+
+// activeTarget returns the name of the active reroute target, or an empty string if no reroute is active
+func activeTarget() string {
+	reroute.Lock()
+	defer reroute.Unlock()
+	if !reroute.Active {
+		return ""
+	}
+	return reroute.Target
+}
+
+// isSupervised returns true if a node is the active reroute target or a configured fallback and should be probed
+// with extra samples
+func isSupervised(config *Config, name string) bool {
+	if name == activeTarget() {
+		return true
+	}
+	for _, fallback := range config.RerouteFallbacks {
+		if fallback == name {
+			return true
+		}
+	}
+	return false
+}
+
+// replacementTarget returns the first healthy configured fallback, or the closest candidate if no fallback is
+// healthy, excluding the given node
+func replacementTarget(config *Config, exclude string) (*Node, string) {
+	for _, name := range config.RerouteFallbacks {
+		if name == exclude {
+			continue
+		}
+		if node, ok := candidateNodes.Get(name); ok && autoSelectable(config, node) {
+			return &node, name
+		}
+	}
+	return closestNode(config, exclude)
+}
+
+// completedCycles is the number of probe cycles completed since startup
+var completedCycles int64
+
+// insufficientData returns a reason if fewer than reroute-min-cycles probe cycles have completed, so candidates are
+// based on too little data for automatic selection, or an empty string otherwise
+func insufficientData(config *Config) string {
+	cycles := atomic.LoadInt64(&completedCycles)
+	if cycles < int64(config.RerouteMinCycles) {
+		return fmt.Sprintf("insufficient data (%d/%d cycles)", cycles, config.RerouteMinCycles)
+	}
+	return ""
+}
+
+// defaultTarget returns the node a parameterless reroute should use: the configured default reroute target if it is a
+// healthy candidate, otherwise the closest candidate unless default-reroute-strict is set. The returned
+// reason describes the choice.
+func defaultTarget(config *Config) (*Node, string, string) {
+	if reason := insufficientData(config); reason != "" {
+		return nil, "", reason
+	}
+	if config.DefaultRerouteTarget == "" {
+		node, name := closestNode(config, "")
+		return node, name, "closest candidate"
+	}
+	if node, ok := candidateNodes.Get(config.DefaultRerouteTarget); ok {
+		return &node, config.DefaultRerouteTarget, "default target"
+	}
+	if config.DefaultRerouteStrict {
+		return nil, "", fmt.Sprintf("default target %s unhealthy", config.DefaultRerouteTarget)
+	}
+	node, name := closestNode(config, "")
+	return node, name, fmt.Sprintf("default target %s unhealthy, using closest candidate", config.DefaultRerouteTarget)
+}
+
+// superviseTarget records a probe result for a node and, if it is the active reroute target and has been unhealthy
+// for TargetDownCycles consecutive cycles, applies the configured failover policy
+func superviseTarget(config *Config, name string, latency time.Duration, loss float64, probeErr error) {
+	reroute.Lock()
+	defer reroute.Unlock()
+	if !reroute.Active || reroute.Target != name {
+		return
+	}
+
+	node := config.Nodes[name]
+	healthy := probeErr == nil && latency <= config.nodeLatencyThreshold(node, false) && loss < config.nodeLossThreshold(node)
+	reroute.Health.Latency = latency
+	reroute.Health.Loss = loss
+	reroute.Health.Healthy = healthy
+	reroute.Health.Updated = time.Now()
+	if healthy {
+		reroute.Health.Failures = 0
+		return
+	}
+	reroute.Health.Failures++
+	log.Warnf("Reroute target %s is unhealthy (latency %s, loss %.1f%%, %d/%d cycles)",
+		name, latency, loss, reroute.Health.Failures, config.TargetDownCycles)
+	if reroute.Health.Failures != config.TargetDownCycles {
+		// Only act on the transition to down so a held target doesn't alert every cycle
+		return
+	}
+	if reroute.Panic {
+		log.Errorf("Panic target %s is down, holding until unpanic", name)
+		return
+	}
+
+	failoverLocked(config, name, fmt.Sprintf("target %s unhealthy for %d cycles", name, reroute.Health.Failures))
+}
+
+// failoverLocked applies the configured failover policy to an active reroute target that is down. The caller must
+// hold the reroute lock.
+func failoverLocked(config *Config, name, reason string) {
+	log.Errorf("Reroute target %s is down, applying %s failover policy", name, config.FailoverOnTargetDown)
+	metricTargetDown.Inc()
+	sendWebhook(config, webhookEvent{
+		Event:  "target-down",
+		Target: name,
+		Reason: reason,
+	})
+
+	switch config.FailoverOnTargetDown {
+	case "hold":
+		events.Add("selection", name, reason+", holding current target")
+		return
+	case "next":
+		if err := degradedEverywhere(config); err != nil {
+			log.Errorf("Not failing over from %s, degradation looks systemic: %s", name, err)
+			events.Add("reroute-refused", name, err.Error())
+			break
+		}
+		node, replacement := replacementTarget(config, name)
+		if node != nil {
+			current := Node{Latency: reroute.Health.Latency, Loss: reroute.Health.Loss}
+			if ok, why := improvesEnough(config, current, *node); !ok {
+				log.Warnf("Failover from %s to %s declined: %s", name, replacement, why)
+				events.Add("failover-declined", replacement, why)
+				return
+			}
+			events.Add("selection", replacement, reason)
+			if err := rerouteToLocked(config, replacement, node, reason); err != nil {
+				log.Errorf("Error rerouting from %s to %s: %s", name, replacement, err)
+			}
+			return
+		}
+		if !terminalPolicyLocked(config, name, reason) {
+			return
+		}
+	}
+
+	events.Add("selection", "", reason+", reverting to local")
+	if err := noRerouteLocked(config, reason); err != nil {
+		log.Errorf("Error reverting reroute to %s: %s", name, err)
+	}
+}
+
+// improvesEnough returns true if moving traffic from the current path to a candidate improves it by at least the
+// configured minimum, or a description of the shortfall. Lower loss is always an improvement; otherwise the latency
+// gain must meet both min-improvement and min-improvement-ratio.
+func improvesEnough(config *Config, current, candidate Node) (bool, string) {
+	if candidate.Loss < current.Loss {
+		return true, ""
+	}
+	gain := current.Latency - candidate.Latency
+	if config.MinImprovement > 0 && gain < config.MinImprovement {
+		return false, fmt.Sprintf("latency gain %s is below min-improvement %s", gain, config.MinImprovement)
+	}
+	if config.MinImprovementRatio > 0 && float64(gain) < float64(current.Latency)*config.MinImprovementRatio {
+		return false, fmt.Sprintf("latency gain %s is below %.0f%% of %s", gain, config.MinImprovementRatio*100, current.Latency)
+	}
+	return true, ""
+}
+
+// terminalPolicyLocked applies the terminal policy when the active reroute target is down and no replacement candidate
+// is available. It returns true if the caller should revert to local. The caller must hold the reroute lock.
+func terminalPolicyLocked(config *Config, name, reason string) bool {
+	log.Errorf("No replacement candidate available for %s, applying %s terminal policy", name, config.TerminalPolicy)
+	metricTerminalPolicy.WithLabelValues(config.TerminalPolicy).Inc()
+	events.Add("terminal-policy", name, fmt.Sprintf("%s, no replacement candidate, %s", reason, config.TerminalPolicy))
+	switch config.TerminalPolicy {
+	case "hold":
+		log.Errorf("Holding reroute to down target %s", name)
+		return false
+	case "least-bad":
+		if replacement := leastBadNode(name); replacement != "" {
+			node := config.Nodes[replacement]
+			log.Errorf("Rerouting to least bad node %s ignoring thresholds", replacement)
+			if err := rerouteToLocked(config, replacement, &node, reason+", least bad node"); err != nil {
+				log.Errorf("Error rerouting from %s to %s: %s", name, replacement, err)
+			}
+			return false
+		}
+		log.Errorf("No node replied to probes, reverting to local")
+	}
+	return true
+}
+
+// superviseRevert automatically withdraws an active reroute once local health has stayed good for RevertHold and a
+// confirmation probe of the local path also passes. Local health degrading during the hold cancels the pending revert.
+func superviseRevert(config *Config, health localHealth, confirmProber Prober) {
+	reroute.Lock()
+	if !reroute.Active || reroute.Panic {
+		reroute.Unlock()
+		return
+	}
+	if !health.Healthy {
+		if !reroute.RevertPendingSince.IsZero() {
+			log.Warn("Local health degraded during revert hold, cancelling pending revert")
+			events.Add("revert-cancel", reroute.Target, "local health degraded during hold")
+			reroute.RevertPendingSince = time.Time{}
+		}
+		reroute.Unlock()
+		return
+	}
+	now := time.Now()
+	if reroute.RevertPendingSince.IsZero() {
+		at := failbackAt(config, now, now)
+		log.Infof("Local health recovered, reverting reroute to %s at %s", reroute.Target, at.Format(time.RFC3339))
+		events.Add("revert-pending", reroute.Target, fmt.Sprintf("local healthy, hold %s, revert at %s", config.RevertHold, at.Format(time.RFC3339)))
+		reroute.RevertPendingSince = now
+		reroute.Unlock()
+		return
+	}
+	if at := failbackAt(config, reroute.RevertPendingSince, now); now.Before(at) {
+		if now.Sub(reroute.RevertPendingSince) >= config.RevertHold {
+			log.Debugf("Revert hold elapsed, deferring revert to the failback window at %s", at.Format(time.RFC3339))
+		}
+		reroute.Unlock()
+		return
+	}
+	reroute.Unlock()
+
+	// Confirm through the probe path without holding the lock, then recheck that the revert is still pending
+	confirm := measureLocal(config, confirmProber)
+	reroute.Lock()
+	defer reroute.Unlock()
+	if !reroute.Active || reroute.RevertPendingSince.IsZero() {
+		return
+	}
+	if !confirm.Healthy {
+		log.Warnf("Revert confirmation probe failed (latency %s, loss %.1f%%), cancelling pending revert", confirm.Latency, confirm.Loss)
+		events.Add("revert-cancel", reroute.Target, "confirmation probe failed")
+		reroute.RevertPendingSince = time.Time{}
+		return
+	}
+	reason := fmt.Sprintf("local healthy for %s", config.RevertHold)
+	if err := noRerouteLocked(config, reason); err != nil {
+		log.Errorf("Error reverting reroute: %s", err)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// activeReroute pretends a reroute to a node is active for the rest of the test
+func activeReroute(t *testing.T, target string) {
+	t.Helper()
+	reroute.Lock()
+	reroute.Active, reroute.Target, reroute.Since = true, target, time.Now()
+	reroute.Unlock()
+	t.Cleanup(func() {
+		reroute.Lock()
+		defer reroute.Unlock()
+		reroute.Active, reroute.Target, reroute.Since = false, "", time.Time{}
+		reroute.Health = targetHealth{}
+		reroute.RevertPendingSince, reroute.Panic, reroute.Auto = time.Time{}, false, false
+	})
+}
+
+func TestSuperviseTargetHold(t *testing.T) {
+	config := testConfig(t, "failover-on-target-down: hold\ntarget-down-cycles: 3\n")
+	activeReroute(t, "fmt2")
+	downs := testutil.ToFloat64(metricTargetDown)
+
+	// Other nodes failing doesn't count against the target
+	superviseTarget(config, "sea3", 0, 100, nil)
+	for i := 0; i < 5; i++ {
+		superviseTarget(config, "fmt2", 0, 100, nil)
+	}
+
+	if reroute.Health.Failures != 5 || reroute.Health.Healthy {
+		t.Errorf("target health %+v, want 5 failures", reroute.Health)
+	}
+	if !reroute.Active || reroute.Target != "fmt2" {
+		t.Errorf("reroute active %t to %s, want held on fmt2", reroute.Active, reroute.Target)
+	}
+	// Only the transition to down is acted on
+	if got := testutil.ToFloat64(metricTargetDown) - downs; got != 1 {
+		t.Errorf("target down counted %.0f times, want 1", got)
+	}
+
+	superviseTarget(config, "fmt2", 20*time.Millisecond, 0, nil)
+	if reroute.Health.Failures != 0 || !reroute.Health.Healthy {
+		t.Errorf("target health %+v after recovering, want healthy", reroute.Health)
+	}
+}
+
+func TestDefaultTarget(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		extra      string
+		candidates []string
+		wantTarget string
+	}{
+		{"closest without default", "", []string{"fmt2", "sea3"}, "sea3"},
+		{"default healthy", "default-reroute-target: fmt2\n", []string{"fmt2", "sea3"}, "fmt2"},
+		{"default unhealthy", "default-reroute-target: fmt2\n", []string{"sea3"}, "sea3"},
+		{"default unhealthy strict", "default-reroute-target: fmt2\ndefault-reroute-strict: true\n", []string{"sea3"}, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, tt.extra)
+			latencies := map[string]time.Duration{"fmt2": 40 * time.Millisecond, "sea3": 20 * time.Millisecond}
+			for _, name := range tt.candidates {
+				node := config.Nodes[name]
+				node.Latency = latencies[name]
+				candidateNodes.Set(name, node)
+			}
+
+			node, name, reason := defaultTarget(config)
+			if name != tt.wantTarget || (node == nil) != (tt.wantTarget == "") {
+				t.Errorf("target %q (%s), want %q", name, reason, tt.wantTarget)
+			}
+		})
+	}
+}
+
+func TestPreflightTarget(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		extra   string
+		prober  *fakeProber
+		wantDst string
+		wantErr bool
+	}{
+		{"overlay", "", &fakeProber{result: probeResult{Latency: 20 * time.Millisecond}}, "172.16.10.20", false},
+		{"underlay", "reroute-via: underlay\n", &fakeProber{result: probeResult{Latency: 20 * time.Millisecond}}, "192.0.2.20", false},
+		{"no replies", "", &fakeProber{result: probeResult{Loss: 100}}, "172.16.10.20", true},
+		{"probe error", "", &fakeProber{err: errors.New("sendto: no route to host")}, "172.16.10.20", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, tt.extra)
+			node := config.Nodes["fmt2"]
+			_, err := preflightTarget(config, tt.prober, &node)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error %v, want error %t", err, tt.wantErr)
+			}
+			if len(tt.prober.targets) != 1 || tt.prober.targets[0].Dst != tt.wantDst {
+				t.Errorf("probed %+v, want %s", tt.prober.targets, tt.wantDst)
+			}
+		})
+	}
+}
+
+func TestReroutePreflightRefuse(t *testing.T) {
+	config := testConfig(t, "reroute-preflight: refuse\n")
+	d := &Director{config: config, probes: &probeSet{Supervised: &fakeProber{result: probeResult{Loss: 100}}}}
+
+	result, err := d.Reroute("fmt2", false)
+	if err == nil {
+		t.Fatal("reroute to an unreachable target wasn't refused")
+	}
+	if result.Preflight == "" {
+		t.Error("refused reroute doesn't report the preflight outcome")
+	}
+	if reroute.Active {
+		t.Error("reroute active after the preflight refused it")
+	}
+}
+
+func TestDegradedEverywhere(t *testing.T) {
+	for _, tt := range []struct {
+		fraction   string
+		candidates []string
+		wantErr    bool
+	}{
+		{"0", nil, false},
+		{"0.5", nil, true},
+		{"0.5", []string{"fmt2"}, false},
+		{"1", []string{"fmt2"}, true},
+		{"1", []string{"fmt2", "sea3"}, false},
+	} {
+		config := testConfig(t, "min-healthy-fraction: "+tt.fraction+"\n")
+		for _, name := range tt.candidates {
+			candidateNodes.Set(name, config.Nodes[name])
+		}
+		if err := degradedEverywhere(config); (err != nil) != tt.wantErr {
+			t.Errorf("min-healthy-fraction %s with candidates %v: error %v, want error %t", tt.fraction, tt.candidates, err, tt.wantErr)
+		}
+		for _, name := range tt.candidates {
+			candidateNodes.Delete(name)
+		}
+	}
+}
+
+func TestRerouteRefusedWhenDegradedEverywhere(t *testing.T) {
+	// Monitor-only stops a reroute that got past the check before any route is touched
+	config := testConfig(t, "min-healthy-fraction: 0.5\nmonitor-only: true\n")
+	d := &Director{config: config}
+
+	_, err := d.Reroute("fmt2", false)
+	if err == nil || errors.Is(err, errMonitorOnly) || apiErrorStatus(err) != http.StatusConflict {
+		t.Errorf("unforced reroute error %v, want a refusal", err)
+	}
+	if _, err := d.Reroute("fmt2", true); !errors.Is(err, errMonitorOnly) {
+		t.Errorf("forced reroute error %v, want it to get past the check", err)
+	}
+}
+
+func TestMonitorOnlyRouting(t *testing.T) {
+	config := testConfig(t, "monitor-only: true\n")
+	node := config.Nodes["fmt2"]
+	if err := rerouteTo(config, "fmt2", &node, "test"); !errors.Is(err, errMonitorOnly) {
+		t.Errorf("reroute error %v, want %v", err, errMonitorOnly)
+	}
+	if reroute.Active {
+		t.Error("rerouted in monitor-only mode")
+	}
+
+	activeReroute(t, "fmt2")
+	if err := noReroute(config, "test"); !errors.Is(err, errMonitorOnly) {
+		t.Errorf("noreroute error %v, want %v", err, errMonitorOnly)
+	}
+	if !reroute.Active {
+		t.Error("reroute withdrawn in monitor-only mode")
+	}
+}
+
+func TestTerminalPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		policy     string
+		samples    bool
+		wantRevert bool
+	}{
+		{"local", true, true},
+		{"hold", true, false},
+		{"least-bad", false, true},
+		// Monitor-only stops the reroute to the least bad node before any route is touched
+		{"least-bad", true, false},
+	} {
+		t.Run(tt.policy, func(t *testing.T) {
+			config := testConfig(t, "terminal-policy: "+tt.policy+"\nmonitor-only: true\n")
+			activeReroute(t, "fmt2")
+			if tt.samples {
+				recordSamples(config, "sea3", measurement{probeResult: probeResult{Loss: 50, Latency: 20 * time.Millisecond}}, time.Now())
+			}
+			applied := testutil.ToFloat64(metricTerminalPolicy.WithLabelValues(tt.policy))
+
+			reroute.Lock()
+			revert := terminalPolicyLocked(config, "fmt2", "test")
+			reroute.Unlock()
+
+			if revert != tt.wantRevert {
+				t.Errorf("revert to local %t, want %t", revert, tt.wantRevert)
+			}
+			if got := testutil.ToFloat64(metricTerminalPolicy.WithLabelValues(tt.policy)) - applied; got != 1 {
+				t.Errorf("terminal policy counted %.0f times, want 1", got)
+			}
+		})
+	}
+}
+
+func TestImprovesEnough(t *testing.T) {
+	ms := time.Millisecond
+	for _, tt := range []struct {
+		name      string
+		extra     string
+		current   Node
+		candidate Node
+		want      bool
+	}{
+		{"no minimum", "", Node{Latency: 50 * ms}, Node{Latency: 60 * ms}, true},
+		{"absolute gain met", "min-improvement: 10ms\n", Node{Latency: 50 * ms}, Node{Latency: 40 * ms}, true},
+		{"absolute gain short", "min-improvement: 10ms\n", Node{Latency: 50 * ms}, Node{Latency: 45 * ms}, false},
+		{"ratio met", "min-improvement-ratio: 0.2\n", Node{Latency: 50 * ms}, Node{Latency: 40 * ms}, true},
+		{"ratio short", "min-improvement-ratio: 0.2\n", Node{Latency: 50 * ms}, Node{Latency: 41 * ms}, false},
+		{"both required", "min-improvement: 5ms\nmin-improvement-ratio: 0.5\n", Node{Latency: 50 * ms}, Node{Latency: 40 * ms}, false},
+		{"lower loss", "min-improvement: 10ms\n", Node{Latency: 50 * ms, Loss: 20}, Node{Latency: 60 * ms, Loss: 5}, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, tt.extra)
+			ok, why := improvesEnough(config, tt.current, tt.candidate)
+			if ok != tt.want {
+				t.Errorf("improves enough %t (%s), want %t", ok, why, tt.want)
+			}
+			if ok == (why != "") {
+				t.Errorf("improves enough %t with reason %q", ok, why)
+			}
+		})
+	}
+}
+
+func TestRerouteMinCycles(t *testing.T) {
+	config := testConfig(t, "reroute-min-cycles: 3\n")
+	t.Cleanup(func() { atomic.StoreInt64(&completedCycles, 0) })
+	candidateNodes.Set("fmt2", config.Nodes["fmt2"])
+
+	atomic.StoreInt64(&completedCycles, 2)
+	if reason := insufficientData(config); reason != "insufficient data (2/3 cycles)" {
+		t.Errorf("reason %q after 2 cycles, want insufficient data", reason)
+	}
+	if _, name := closestNode(config, ""); name != "" {
+		t.Errorf("closest node %s after 2 cycles, want none", name)
+	}
+	if _, name, reason := defaultTarget(config); name != "" || reason == "" {
+		t.Errorf("default target %q (%s) after 2 cycles, want none", name, reason)
+	}
+
+	atomic.StoreInt64(&completedCycles, 3)
+	if reason := insufficientData(config); reason != "" {
+		t.Errorf("reason %q after 3 cycles, want none", reason)
+	}
+	if _, name, reason := defaultTarget(config); name != "fmt2" {
+		t.Errorf("default target %q (%s) after 3 cycles, want fmt2", name, reason)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+)
+
+// installedRoute is a kernel route covering a managed prefix, as reported by the /routes endpoint
+type installedRoute struct {
+	Prefix   string `json:"prefix"`
+	Gateway  string `json:"gateway,omitempty"`
+	Device   string `json:"device,omitempty"`
+	Table    int    `json:"table"`
+	Priority int    `json:"priority"`
+	Owned    bool   `json:"owned"`
+	Reason   string `json:"reason,omitempty"` // Why a route isn't owned by the director
+}
+
+// installedRoutes reads the kernel routes that fall within the managed prefixes and marks the ones the director
+// installed for the active reroute target as owned
+func installedRoutes(config *Config) ([]installedRoute, error) {
+	return classifyRoutes(config, activeTarget())
+}
+
+// classifyRoutes reads the kernel routes that fall within the managed prefixes and marks the ones a reroute to target
+// would own. An empty target expects no reroute.
+func classifyRoutes(config *Config, target string) ([]installedRoute, error) {
+	expected := map[string]string{}
+	if target != "" {
+		node := config.Nodes[target]
+		planned, err := reroutePlan(config, target, &node)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range planned {
+			expected[r.Prefix] = r.Proposed
+		}
+	}
+
+	var managed []*net.IPNet
+	for _, prefix := range config.Prefixes {
+		_, n, err := net.ParseCIDR(prefix)
+		if err != nil {
+			return nil, err
+		}
+		managed = append(managed, n)
+	}
+
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("error listing routes: %s", err)
+	}
+	var installed []installedRoute
+	for _, route := range routes {
+		if route.Dst == nil {
+			continue
+		}
+		for i, n := range managed {
+			if !n.Contains(route.Dst.IP) {
+				continue
+			}
+			r := installedRoute{
+				Prefix:   route.Dst.String(),
+				Table:    route.Table,
+				Priority: route.Priority,
+			}
+			if route.Gw != nil {
+				r.Gateway = route.Gw.String()
+			}
+			if link, err := netlink.LinkByIndex(route.LinkIndex); err == nil {
+				r.Device = link.Attrs().Name
+			}
+
+			nexthop := expected[config.Prefixes[i]]
+			gw, _, _ := parseZonedIP(nexthop)
+			switch {
+			case r.Prefix != n.String():
+				r.Reason = fmt.Sprintf("more specific than managed prefix %s", n)
+			case target != "" && rerouteDenied(config, config.Prefixes[i]):
+				r.Reason = "in reroute-deny"
+			case nexthop == "":
+				r.Reason = "not rerouting"
+			case !gw.Equal(route.Gw):
+				r.Reason = fmt.Sprintf("nexthop is %s, expected %s", r.Gateway, nexthop)
+			default:
+				r.Owned = true
+			}
+			installed = append(installed, r)
+			break
+		}
+	}
+	return installed, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// sampleSet is the per-packet RTTs of a node's most recent probe cycle
+type sampleSet struct {
+	Node    string    `json:"node"`
+	Time    time.Time `json:"time"`
+	Method  string    `json:"method"`
+	Loss    float64   `json:"loss"`
+	Samples []string  `json:"rtts"`
+	latency time.Duration
+}
+
+// lastSamples holds only the latest cycle's samples of each node, and a window of each node's most recent RTTs across
+// cycles bounded by sample-window, to bound memory
+var lastSamples = struct {
+	sync.Mutex
+	nodes  map[string]sampleSet
+	window map[string][]time.Duration
+}{nodes: map[string]sampleSet{}, window: map[string][]time.Duration{}}
+
+// recordSamples replaces a node's retained samples with those of the latest cycle and adds them to its window
+func recordSamples(config *Config, name string, m measurement, probeTime time.Time) {
+	set := sampleSet{
+		Node:    name,
+		Time:    probeTime,
+		Method:  m.Method,
+		Loss:    m.Loss,
+		Samples: make([]string, len(m.Samples)),
+		latency: m.Latency,
+	}
+	for i, rtt := range m.Samples {
+		set.Samples[i] = rtt.String()
+	}
+	lastSamples.Lock()
+	defer lastSamples.Unlock()
+	lastSamples.nodes[name] = set
+	window := append(lastSamples.window[name], m.Samples...)
+	if len(window) > config.SampleWindow {
+		// Copy rather than reslice so the dropped samples can be freed
+		window = append([]time.Duration(nil), window[len(window)-config.SampleWindow:]...)
+	}
+	lastSamples.window[name] = window
+}
+
+// latencyPercentiles is the latency distribution of a node's sample window
+type latencyPercentiles struct {
+	Samples int    `json:"samples"`
+	P50     string `json:"p50"`
+	P90     string `json:"p90"`
+	P99     string `json:"p99"`
+}
+
+// percentile returns the nearest-rank percentile of sorted samples
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p/100*float64(len(sorted))+0.999999) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// windowPercentiles returns the p50, p90 and p99 latency of each node with samples in its window
+func windowPercentiles() map[string]latencyPercentiles {
+	lastSamples.Lock()
+	defer lastSamples.Unlock()
+	percentiles := map[string]latencyPercentiles{}
+	for name, window := range lastSamples.window {
+		if len(window) == 0 {
+			continue
+		}
+		sorted := append([]time.Duration(nil), window...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		percentiles[name] = latencyPercentiles{
+			Samples: len(sorted),
+			P50:     percentile(sorted, 50).String(),
+			P90:     percentile(sorted, 90).String(),
+			P99:     percentile(sorted, 99).String(),
+		}
+	}
+	return percentiles
+}
+
+// nodeSamples returns a node's latest samples, and false if the node hasn't been probed
+func nodeSamples(name string) (sampleSet, bool) {
+	lastSamples.Lock()
+	defer lastSamples.Unlock()
+	set, ok := lastSamples.nodes[name]
+	return set, ok
+}
+
+// leastBadNode returns the node with the lowest loss, then latency, in its latest samples regardless of thresholds,
+// excluding the given node name. It returns an empty string if no node got any replies.
+func leastBadNode(exclude string) string {
+	lastSamples.Lock()
+	defer lastSamples.Unlock()
+	var best string
+	for name, set := range lastSamples.nodes {
+		if name == exclude || set.Loss >= 100 {
+			continue
+		}
+		current, ok := lastSamples.nodes[best]
+		if !ok || set.Loss < current.Loss || set.Loss == current.Loss && set.latency < current.latency {
+			best = name
+		}
+	}
+	return best
+}
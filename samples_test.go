@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeastBadNode(t *testing.T) {
+	config := testConfig(t, "")
+	if got := leastBadNode(""); got != "" {
+		t.Errorf("least bad node %s without samples, want none", got)
+	}
+
+	sample := func(name string, loss float64, latency time.Duration) {
+		recordSamples(config, name, measurement{probeResult: probeResult{Loss: loss, Latency: latency}}, time.Now())
+	}
+	sample("pdx1", 100, 0)
+	sample("fmt2", 40, 80*time.Millisecond)
+	sample("sea3", 40, 60*time.Millisecond)
+
+	if got := leastBadNode(""); got != "sea3" {
+		t.Errorf("least bad node %s, want sea3 with equal loss and lower latency", got)
+	}
+	if got := leastBadNode("sea3"); got != "fmt2" {
+		t.Errorf("least bad node excluding sea3 %s, want fmt2", got)
+	}
+	sample("fmt2", 30, 80*time.Millisecond)
+	if got := leastBadNode(""); got != "fmt2" {
+		t.Errorf("least bad node %s, want fmt2 with lower loss", got)
+	}
+	sample("fmt2", 100, 0)
+	if got := leastBadNode("sea3"); got != "" {
+		t.Errorf("least bad node %s when no other node replied, want none", got)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	for _, tt := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, 1},
+		{10, 1},
+		{50, 5},
+		{90, 9},
+		{99, 10},
+		{100, 10},
+	} {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("p%.0f is %d, want %d", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestWindowPercentiles(t *testing.T) {
+	config := testConfig(t, "sample-window: 10\n")
+	var samples []time.Duration
+	for i := 1; i <= 15; i++ {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	// Only the last 10 samples across both cycles are kept
+	recordSamples(config, "fmt2", measurement{probeResult: probeResult{Samples: samples[:8]}}, time.Now())
+	recordSamples(config, "fmt2", measurement{probeResult: probeResult{Samples: samples[8:]}}, time.Now())
+
+	want := latencyPercentiles{Samples: 10, P50: "10ms", P90: "14ms", P99: "15ms"}
+	if got := windowPercentiles()["fmt2"]; got != want {
+		t.Errorf("percentiles %+v, want %+v", got, want)
+	}
+	if _, ok := windowPercentiles()["sea3"]; ok {
+		t.Error("percentiles reported for a node without samples")
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// probeStopRequests stops the probe loop. A send completes once the loop is between cycles, after which it runs no
+// further cycles.
+var probeStopRequests = make(chan struct{})
+
+// probesStopping is closed once shutdown starts stopping the probe loop, so reloads that would wait for it fail instead
+var probesStopping = make(chan struct{})
+
+// stopProbing stops the probe loop, waiting up to timeout for an in-flight cycle to finish
+func stopProbing(timeout time.Duration) {
+	close(probesStopping)
+	select {
+	case probeStopRequests <- struct{}{}:
+		log.Debug("Probe loop stopped")
+	case <-time.After(timeout):
+		log.Warnf("Probe cycle still running after %s, shutting down anyway", timeout)
+	}
+}
+
+// quiesceRoutes waits for an in-flight reroute transition to finish and keeps new ones from starting by holding the
+// reroute lock until the process exits. With teardown-on-exit the active reroute is withdrawn and the fabric
+// interfaces are deleted.
+func quiesceRoutes(config *Config) {
+	reroute.Lock()
+	if !config.TeardownOnExit {
+		return
+	}
+	if reroute.Active && !config.MonitorOnly {
+		log.Infof("Withdrawing reroute to %s before exiting", reroute.Target)
+		if err := noRerouteLocked(config, "shutdown"); err != nil {
+			log.Errorf("Error withdrawing reroute: %s", err)
+		}
+	}
+	if err := teardownGRE(); err != nil {
+		log.Errorf("Error tearing down interfaces: %s", err)
+		return
+	}
+	log.Info("Teardown complete")
+}
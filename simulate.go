@@ -0,0 +1,42 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// simulatedDown is the set of node names forced to fail health thresholds by the /simulate endpoint
+var simulatedDown = struct {
+	sync.Mutex
+	nodes map[string]bool
+}{nodes: map[string]bool{}}
+
+// setSimulatedDown forces a node to be treated as failing (down true) or restores real measurement (down false)
+func setSimulatedDown(name string, down bool) {
+	simulatedDown.Lock()
+	defer simulatedDown.Unlock()
+	if down {
+		simulatedDown.nodes[name] = true
+	} else {
+		delete(simulatedDown.nodes, name)
+	}
+}
+
+// isSimulatedDown returns true if a node is being simulated as down
+func isSimulatedDown(name string) bool {
+	simulatedDown.Lock()
+	defer simulatedDown.Unlock()
+	return simulatedDown.nodes[name]
+}
+
+// simulatedDownNodes returns the sorted names of nodes being simulated as down
+func simulatedDownNodes() []string {
+	simulatedDown.Lock()
+	defer simulatedDown.Unlock()
+	var names []string
+	for name := range simulatedDown.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
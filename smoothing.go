@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// nodeEWMA is the exponentially weighted moving average of a node's latency and loss
+type nodeEWMA struct {
+	Latency time.Duration
+	Loss    float64
+	primed  bool // Whether Latency holds a sample yet, which it doesn't until the node answers
+}
+
+// smoothed holds the EWMA of each node by name
+var smoothed = struct {
+	sync.Mutex
+	nodes map[string]*nodeEWMA
+}{nodes: map[string]*nodeEWMA{}}
+
+// smoothMeasurement folds a cycle's measurement into a node's EWMA and returns the smoothed latency and loss, or the
+// raw values if ewma-alpha is unset. A probe error counts as total loss. Latency is only folded in when a probe was
+// answered, so a lost cycle raises the loss without dragging the latency towards zero.
+func smoothMeasurement(config *Config, name string, m measurement) (time.Duration, float64) {
+	latency, loss := m.Latency, m.Loss
+	if config.EWMAAlpha == 0 {
+		return latency, loss
+	}
+	if m.Err != nil {
+		loss = 100
+	}
+	smoothed.Lock()
+	defer smoothed.Unlock()
+	s, ok := smoothed.nodes[name]
+	if !ok {
+		s = &nodeEWMA{Loss: loss}
+		smoothed.nodes[name] = s
+	} else {
+		s.Loss += config.EWMAAlpha * (loss - s.Loss)
+	}
+	if loss < 100 {
+		if !s.primed {
+			s.Latency, s.primed = latency, true
+		} else {
+			s.Latency += time.Duration(config.EWMAAlpha * float64(latency-s.Latency))
+		}
+	}
+	if !s.primed {
+		return latency, s.Loss
+	}
+	return s.Latency, s.Loss
+}
+
+// forgetSmoothing drops a node's EWMA
+func forgetSmoothing(name string) {
+	smoothed.Lock()
+	defer smoothed.Unlock()
+	delete(smoothed.nodes, name)
+}
+
+// candidateThresholds returns the latency and loss a node must stay within on an address family. A candidate is only
+// removed once it exceeds the thresholds by latency-hysteresis or loss-hysteresis, so a node hovering at a threshold
+// isn't added and removed every cycle.
+func candidateThresholds(config *Config, node Node, ipv6, candidate bool) (time.Duration, float64) {
+	latency, loss := config.nodeLatencyThreshold(node, ipv6), config.nodeLossThreshold(node)
+	if candidate {
+		latency += config.LatencyHysteresis
+		loss += config.LossHysteresis
+	}
+	return latency, loss
+}
+
+// closestSelection is the closest node as of the last probe cycle, which closestNode keeps returning until another
+// candidate is faster by more than closest-hysteresis. Only the probe loop moves it, so reading the closest node from
+// the API or metrics never changes which node is selected.
+var closestSelection = struct {
+	sync.Mutex
+	name string
+}{}
+
+// stickyClosest returns the selected closest node instead of a newly found one if it is still selectable and no more
+// than closest-hysteresis slower
+func stickyClosest(config *Config, candidates map[string]Node, exclude string, closest *Node, closestName string) (*Node, string) {
+	closestSelection.Lock()
+	selected := closestSelection.name
+	closestSelection.Unlock()
+	if closest != nil && selected != "" && selected != closestName && selected != exclude {
+		if node, ok := candidates[selected]; ok && autoSelectable(config, node) &&
+			degraded(config, node) == degraded(config, *closest) && node.Latency-closest.Latency <= config.ClosestHysteresis {
+			closest, closestName = &node, selected
+		}
+	}
+	return closest, closestName
+}
+
+// updateClosestSelection moves closestSelection to the closest node after a probe cycle has updated the candidates
+func updateClosestSelection(config *Config) {
+	_, name := closestNode(config, "")
+	closestSelection.Lock()
+	defer closestSelection.Unlock()
+	closestSelection.name = name
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSmoothMeasurement(t *testing.T) {
+	answered := func(latency time.Duration, loss float64) measurement {
+		return measurement{probeResult: probeResult{Latency: latency, Loss: loss}}
+	}
+	for _, tt := range []struct {
+		name        string
+		alpha       float64
+		samples     []measurement
+		wantLatency time.Duration
+		wantLoss    float64
+	}{
+		{"disabled returns raw values", 0, []measurement{answered(10*time.Millisecond, 0), answered(50*time.Millisecond, 20)}, 50 * time.Millisecond, 20},
+		{"first sample primes the average", 0.5, []measurement{answered(40*time.Millisecond, 10)}, 40 * time.Millisecond, 10},
+		{"later samples are weighted by alpha", 0.5, []measurement{answered(40*time.Millisecond, 0), answered(80*time.Millisecond, 20)}, 60 * time.Millisecond, 10},
+		{"lost cycle keeps the latency", 0.5, []measurement{answered(40*time.Millisecond, 0), answered(0, 100)}, 40 * time.Millisecond, 50},
+		{"probe error counts as total loss", 0.5, []measurement{answered(40*time.Millisecond, 0), {Err: errors.New("sendto: operation not permitted")}}, 40 * time.Millisecond, 50},
+		{"error before any reply", 0.5, []measurement{{Err: errors.New("timeout")}}, 0, 100},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, "")
+			config.EWMAAlpha = tt.alpha
+			var latency time.Duration
+			var loss float64
+			for _, m := range tt.samples {
+				latency, loss = smoothMeasurement(config, "fmt2", m)
+			}
+			if latency != tt.wantLatency || loss != tt.wantLoss {
+				t.Errorf("smoothed to %s, %.1f%%, want %s, %.1f%%", latency, loss, tt.wantLatency, tt.wantLoss)
+			}
+		})
+	}
+}
+
+func TestCandidateThresholds(t *testing.T) {
+	config := testConfig(t, "latency-hysteresis: 20ms\nloss-hysteresis: 5\n")
+	for _, tt := range []struct {
+		candidate   bool
+		wantLatency time.Duration
+		wantLoss    float64
+	}{
+		{false, 100 * time.Millisecond, 10},
+		{true, 120 * time.Millisecond, 15},
+	} {
+		latency, loss := candidateThresholds(config, config.Nodes["fmt2"], false, tt.candidate)
+		if latency != tt.wantLatency || loss != tt.wantLoss {
+			t.Errorf("candidate %t: thresholds %s, %.1f%%, want %s, %.1f%%", tt.candidate, latency, loss, tt.wantLatency, tt.wantLoss)
+		}
+	}
+}
+
+func TestClosestHysteresis(t *testing.T) {
+	config := testConfig(t, "closest-hysteresis: 10ms\n")
+	setLatency := func(name string, latency time.Duration) {
+		node := config.Nodes[name]
+		node.Latency = latency
+		candidateNodes.Set(name, node)
+	}
+	setLatency("fmt2", 30*time.Millisecond)
+	setLatency("sea3", 50*time.Millisecond)
+	updateClosestSelection(config)
+
+	// A challenger within the margin doesn't take over
+	setLatency("sea3", 25*time.Millisecond)
+	if _, name := closestNode(config, ""); name != "fmt2" {
+		t.Fatalf("closest is %s, want fmt2 within the hysteresis margin", name)
+	}
+
+	// Reading the closest node must not move the selection, however often it happens
+	setLatency("sea3", 10*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		closestNode(config, "")
+	}
+	setLatency("sea3", 25*time.Millisecond)
+	if _, name := closestNode(config, ""); name != "fmt2" {
+		t.Fatalf("closest is %s after reads, want fmt2", name)
+	}
+
+	// A probe cycle moves the selection once the challenger is faster by more than the margin
+	setLatency("sea3", 10*time.Millisecond)
+	updateClosestSelection(config)
+	setLatency("sea3", 25*time.Millisecond)
+	if _, name := closestNode(config, ""); name != "sea3" {
+		t.Errorf("closest is %s, want sea3 once it was selected", name)
+	}
+
+	// Excluding the selected node falls back to the fastest other candidate
+	if _, name := closestNode(config, "sea3"); name != "fmt2" {
+		t.Errorf("closest excluding sea3 is %s, want fmt2", name)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// sourceStrategies are the supported ways of choosing a probe's source, in the order they're tried by default
+var sourceStrategies = []string{"source", "interface", "auto"}
+
+// sourceTarget returns the probe target for a node using a source selection strategy: source sends from the local
+// internal IP, interface binds to the node's tunnel interface and auto lets the OS pick the source
+func sourceTarget(config *Config, name string, node Node, prefix, strategy string) probeTarget {
+	target := probeTarget{Dst: internalIP(prefix, config.LocalID, node.ID, 0)}
+	switch strategy {
+	case "source":
+		target.Src = internalIP(prefix, node.ID, config.LocalID, 0)
+	case "interface":
+		target.Device = "fd-" + name
+	}
+	return target
+}
+
+// sourceReady returns true if a source IP is assigned, past duplicate address detection, to an interface that is up
+func sourceReady(src string) bool {
+	host, _ := splitZone(src)
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	links, err := netlink.LinkList()
+	if err != nil {
+		log.Debugf("Error listing interfaces: %s", err)
+		return true // Don't block probing on a failed readiness check
+	}
+	for _, link := range links {
+		if link.Attrs().Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			log.Debugf("Error listing addresses of %s: %s", link.Attrs().Name, err)
+			continue
+		}
+		for _, addr := range addrs {
+			if addr.IP.Equal(ip) && addr.Flags&syscall.IFA_F_TENTATIVE == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selectedSources holds the last source strategy that yielded replies from each node
+var selectedSources = struct {
+	sync.Mutex
+	nodes map[string]string
+}{nodes: map[string]string{}}
+
+// recordSourceStrategy records the strategy that yielded replies from a node, logging and updating the metric when it
+// changes
+func recordSourceStrategy(name, strategy string) {
+	selectedSources.Lock()
+	previous := selectedSources.nodes[name]
+	selectedSources.nodes[name] = strategy
+	selectedSources.Unlock()
+	if previous == strategy {
+		return
+	}
+	if previous != "" {
+		log.Infof("Probe source strategy for %s changed from %s to %s", name, previous, strategy)
+		metricNodeProbeSource.DeleteLabelValues(name, previous)
+	}
+	metricNodeProbeSource.WithLabelValues(name, strategy).Set(1)
+	sourceSeries.Lock()
+	sourceSeries.strategy[name] = true
+	sourceSeries.Unlock()
+}
+
+// sourceSeries holds which nodes have probe source and source latency series, so the series of a node removed from
+// the config can be deleted after everything else recorded about it is forgotten
+var sourceSeries = struct {
+	sync.Mutex
+	strategy map[string]bool            // Nodes with a probe source series
+	latency  map[string]map[string]bool // Source interfaces with a latency series, by node
+}{strategy: map[string]bool{}, latency: map[string]map[string]bool{}}
+
+// setSourceLatency exports a node's latency via a source interface, or deletes the series if the probe failed
+func setSourceLatency(name, iface string, m measurement) {
+	sourceSeries.Lock()
+	defer sourceSeries.Unlock()
+	if m.Err != nil {
+		metricNodeSourceLatency.DeleteLabelValues(name, iface)
+		delete(sourceSeries.latency[name], iface)
+		return
+	}
+	metricNodeSourceLatency.WithLabelValues(name, iface).Set(m.Latency.Seconds())
+	if sourceSeries.latency[name] == nil {
+		sourceSeries.latency[name] = map[string]bool{}
+	}
+	sourceSeries.latency[name][iface] = true
+}
+
+// deleteSourceSeries deletes a node's probe source and source latency series
+func deleteSourceSeries(name string) {
+	sourceSeries.Lock()
+	defer sourceSeries.Unlock()
+	for _, strategy := range sourceStrategies {
+		metricNodeProbeSource.DeleteLabelValues(name, strategy)
+	}
+	for iface := range sourceSeries.latency[name] {
+		metricNodeSourceLatency.DeleteLabelValues(name, iface)
+	}
+	delete(sourceSeries.strategy, name)
+	delete(sourceSeries.latency, name)
+}
+
+// sourceSeriesNodes returns the nodes that have probe source or source latency series
+func sourceSeriesNodes() []string {
+	sourceSeries.Lock()
+	defer sourceSeries.Unlock()
+	var names []string
+	for name := range sourceSeries.strategy {
+		names = append(names, name)
+	}
+	for name := range sourceSeries.latency {
+		if !sourceSeries.strategy[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// measureSources probes a node's underlay address from each configured source interface and combines the results so
+// the node is up if it's reachable via any uplink, exporting the latency seen through each
+func (p *probeSet) measureSources(config *Config, name string, node Node, prober Prober, probeType string) measurement {
+	host, _ := splitZone(nodeUnderlayIP(config, node))
+	results := map[string]measurement{}
+	for _, iface := range config.SourceInterfaces {
+		var m measurement
+		target := probeTarget{Dst: host, Device: iface}
+		m.probeResult, m.Method, m.Err = probeWithFallback(prober, p.Fallback, probeType, config.ProbeFallback, target)
+		if m.Err != nil {
+			log.Debugf("Error probing %s via %s: %s", name, iface, m.Err)
+		}
+		setSourceLatency(name, iface, m)
+		results[iface] = m
+	}
+	return combineAnyUp(results)
+}
+
+// combineAnyUp returns the best of a set of per-source measurements: the one with the least loss, then the lowest
+// latency. The combination only errors if every source errored.
+func combineAnyUp(results map[string]measurement) measurement {
+	var best *measurement
+	var errs []string
+	for source, m := range results {
+		m := m
+		if m.Err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", source, m.Err))
+			continue
+		}
+		if best == nil || m.Loss < best.Loss || (m.Loss == best.Loss && m.Latency < best.Latency) {
+			best = &m
+		}
+	}
+	if best == nil {
+		sort.Strings(errs)
+		return measurement{Err: fmt.Errorf("all sources failed: %s", strings.Join(errs, ", "))}
+	}
+	return *best
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSourceTarget(t *testing.T) {
+	config := testConfig(t, "")
+	node := config.Nodes["fmt2"]
+	for _, tt := range []struct {
+		strategy string
+		prefix   string
+		want     probeTarget
+	}{
+		{"source", config.Prefix4, probeTarget{Src: "172.16.20.10", Dst: "172.16.10.20"}},
+		{"source", config.Prefix6, probeTarget{Src: "fd00::10:20:10", Dst: "fd00::10:10:20"}},
+		{"interface", config.Prefix4, probeTarget{Dst: "172.16.10.20", Device: "fd-fmt2"}},
+		{"auto", config.Prefix4, probeTarget{Dst: "172.16.10.20"}},
+	} {
+		t.Run(tt.strategy+" "+tt.prefix, func(t *testing.T) {
+			if got := sourceTarget(config, "fmt2", node, tt.prefix, tt.strategy); got != tt.want {
+				t.Errorf("target %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeBindSetsSourceStrategy(t *testing.T) {
+	for _, tt := range []struct {
+		extra string
+		want  []string
+	}{
+		{"", []string{"source"}},
+		{"probe-bind: interface\n", []string{"interface"}},
+		{"probe-bind: interface\nprobe-source-strategies: [source, auto]\n", []string{"source", "auto"}},
+	} {
+		config := testConfig(t, tt.extra)
+		if !reflect.DeepEqual(config.ProbeSources, tt.want) {
+			t.Errorf("%q probes with source strategies %v, want %v", tt.extra, config.ProbeSources, tt.want)
+		}
+	}
+}
+
+func TestMeasureSourceStrategyChain(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		answers    func(probeTarget) bool
+		wantMethod string
+		wantLoss   float64
+	}{
+		{"source answers", func(target probeTarget) bool { return target.Src != "" }, "source", 0},
+		{"interface answers", func(target probeTarget) bool { return target.Device != "" }, "interface", 0},
+		{"auto answers", func(target probeTarget) bool { return target.Src == "" && target.Device == "" }, "auto", 0},
+		{"none answers", func(probeTarget) bool { return false }, "", 100},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, "probe-source-strategies: [source, interface, auto]\nunready-source: probe\n")
+			var tried []probeTarget
+			prober := proberFunc(func(target probeTarget) (probeResult, error) {
+				tried = append(tried, target)
+				if tt.answers(target) {
+					return probeResult{Latency: 20 * time.Millisecond}, nil
+				}
+				return probeResult{Loss: 100}, nil
+			})
+			p := &probeSet{Primary: prober, Supervised: prober}
+
+			m := p.measure(config, "fmt2", config.Nodes["fmt2"], false)
+			if m.Loss != tt.wantLoss || m.Err != nil {
+				t.Errorf("measured loss %.1f error %v, want loss %.1f", m.Loss, m.Err, tt.wantLoss)
+			}
+			selectedSources.Lock()
+			selected := selectedSources.nodes["fmt2"]
+			selectedSources.Unlock()
+			if selected != tt.wantMethod {
+				t.Errorf("selected source strategy %q, want %q", selected, tt.wantMethod)
+			}
+			// Strategies after the first that answered aren't tried
+			if want := map[string]int{"source": 1, "interface": 2, "auto": 3, "": 3}[tt.wantMethod]; len(tried) != want {
+				t.Errorf("%d strategies tried, want %d", len(tried), want)
+			}
+		})
+	}
+}
+
+func TestCombineAnyUp(t *testing.T) {
+	failed := errors.New("network is unreachable")
+	for _, tt := range []struct {
+		name        string
+		results     map[string]measurement
+		wantLatency time.Duration
+		wantLoss    float64
+		wantErr     bool
+	}{
+		{"least loss wins", map[string]measurement{
+			"eth0": {probeResult: probeResult{Latency: 10 * time.Millisecond, Loss: 50}},
+			"eth1": {probeResult: probeResult{Latency: 30 * time.Millisecond}},
+		}, 30 * time.Millisecond, 0, false},
+		{"then lowest latency", map[string]measurement{
+			"eth0": {probeResult: probeResult{Latency: 30 * time.Millisecond}},
+			"eth1": {probeResult: probeResult{Latency: 10 * time.Millisecond}},
+		}, 10 * time.Millisecond, 0, false},
+		{"errors ignored while one source answers", map[string]measurement{
+			"eth0": {Err: failed},
+			"eth1": {probeResult: probeResult{Latency: 10 * time.Millisecond, Loss: 100}},
+		}, 10 * time.Millisecond, 100, false},
+		{"all sources error", map[string]measurement{
+			"eth0": {Err: failed},
+			"eth1": {Err: failed},
+		}, 0, 0, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m := combineAnyUp(tt.results)
+			if (m.Err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %t", m.Err, tt.wantErr)
+			}
+			if m.Latency != tt.wantLatency || m.Loss != tt.wantLoss {
+				t.Errorf("combined latency %s loss %.1f, want %s and %.1f", m.Latency, m.Loss, tt.wantLatency, tt.wantLoss)
+			}
+		})
+	}
+}
+
+func TestMeasureSourceInterfaces(t *testing.T) {
+	config := testConfig(t, "probe-source-interfaces: [eth0, eth1]\n")
+	var tried []probeTarget
+	prober := proberFunc(func(target probeTarget) (probeResult, error) {
+		tried = append(tried, target)
+		if target.Device == "eth0" {
+			return probeResult{}, errors.New("network is unreachable")
+		}
+		return probeResult{Latency: 20 * time.Millisecond}, nil
+	})
+	p := &probeSet{Primary: prober, Supervised: prober}
+
+	// The node is up as long as one uplink reaches its underlay address
+	m := p.measure(config, "fmt2", config.Nodes["fmt2"], false)
+	if m.Err != nil || m.Latency != 20*time.Millisecond {
+		t.Errorf("measured latency %s error %v, want 20ms", m.Latency, m.Err)
+	}
+	want := []probeTarget{{Dst: "192.0.2.20", Device: "eth0"}, {Dst: "192.0.2.20", Device: "eth1"}}
+	if !reflect.DeepEqual(tried, want) {
+		t.Errorf("probed %+v, want %+v", tried, want)
+	}
+}
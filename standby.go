@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// standbyUpdate is the reroute state mirrored to the standby director after each transition. Seq increases by one per
+// transition within a run of the primary identified by Started, so the standby can detect missed updates.
+type standbyUpdate struct {
+	Node    string         `json:"node"`
+	Started time.Time      `json:"started"`
+	Seq     uint64         `json:"seq"`
+	State   persistedState `json:"state"`
+	Sent    time.Time      `json:"sent"`
+}
+
+var standbyClient = &http.Client{Timeout: 5 * time.Second}
+
+// standbyUpdates serializes mirrored updates so they reach the standby in order
+var standbyUpdates = make(chan standbyUpdate, 16)
+
+// standbySeq is the sequence number of the last mirrored transition, guarded by the reroute lock
+var standbySeq uint64
+
+// startTime identifies this run of the director to the standby
+var startTime = time.Now()
+
+// mirrored holds the last update received from a primary when this director is a standby
+var mirrored = struct {
+	sync.Mutex
+	update   *standbyUpdate
+	received time.Time
+	missed   uint64
+}{}
+
+// mirrorStateLocked queues the current reroute state for the standby without blocking. The caller must hold the
+// reroute lock.
+func mirrorStateLocked(config *Config) {
+	if config.StandbyPeer == "" {
+		return
+	}
+	standbySeq++
+	update := standbyUpdate{
+		Node:    localNodeName,
+		Started: startTime,
+		Seq:     standbySeq,
+		State:   persistedState{Active: reroute.Active, Target: reroute.Target, Since: reroute.Since},
+	}
+	select {
+	case standbyUpdates <- update:
+	default:
+		// The standby detects the gap from the next sequence number
+		log.Warnf("Standby queue full, dropping update %d", update.Seq)
+	}
+}
+
+// postStandby sends an update to the standby's /standby endpoint
+func postStandby(config *Config, update standbyUpdate) error {
+	update.Sent = time.Now()
+	body, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(config.StandbyPeer, "/")+"/standby", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+config.StandbyToken)
+	resp, err := standbyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("standby returned %s", resp.Status)
+	}
+	return nil
+}
+
+// standbyLoop sends queued updates to the standby. Failures are logged and never affect the local transition.
+func standbyLoop(config *Config) {
+	for update := range standbyUpdates {
+		snapshot, _ := configSnapshot(config)
+		if err := postStandby(snapshot, update); err != nil {
+			metricStandbyMirror.WithLabelValues("failure").Inc()
+			log.Warnf("Error mirroring update %d to standby %s: %s", update.Seq, config.StandbyPeer, err)
+			continue
+		}
+		metricStandbyMirror.WithLabelValues("success").Inc()
+	}
+}
+
+// receiveMirror records an update from the primary, ignoring stale or duplicate updates and logging missed ones
+func receiveMirror(update standbyUpdate) {
+	mirrored.Lock()
+	defer mirrored.Unlock()
+	last := mirrored.update
+	if last != nil && last.Node == update.Node && last.Started.Equal(update.Started) {
+		if update.Seq <= last.Seq {
+			log.Debugf("Ignoring stale standby update %d from %s (have %d)", update.Seq, update.Node, last.Seq)
+			return
+		}
+		if gap := update.Seq - last.Seq - 1; gap > 0 {
+			mirrored.missed += gap
+			log.Warnf("Missed %d standby updates from %s (%d to %d)", gap, update.Node, last.Seq+1, update.Seq-1)
+			events.Add("standby-gap", update.Node, fmt.Sprintf("missed %d updates", gap))
+		}
+	} else if update.Seq > 1 {
+		log.Warnf("First standby update from %s run started %s is %d, earlier updates were missed", update.Node, update.Started.Format(time.RFC3339), update.Seq)
+	}
+	log.Infof("Mirrored state from %s: %+v (update %d)", update.Node, update.State, update.Seq)
+	mirrored.update = &update
+	mirrored.received = time.Now()
+}
+
+// mirroredStatus is the state last mirrored from a primary
+type mirroredStatus struct {
+	standbyUpdate
+	Received time.Time `json:"received"`
+	Missed   uint64    `json:"missed"`
+}
+
+// currentMirror returns the state last mirrored from a primary, or nil if none was received
+func currentMirror() *mirroredStatus {
+	mirrored.Lock()
+	defer mirrored.Unlock()
+	if mirrored.update == nil {
+		return nil
+	}
+	return &mirroredStatus{standbyUpdate: *mirrored.update, Received: mirrored.received, Missed: mirrored.missed}
+}
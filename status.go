@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// exclusions holds the reason each node is excluded from candidacy or auto-selection despite passing the latency and
+// loss thresholds
+var exclusions = struct {
+	sync.Mutex
+	reasons map[string]string
+}{reasons: map[string]string{}}
+
+// setExclusion sets (or clears, if reason is empty) the exclusion reason of a node and returns true if the node's
+// exclusion state changed
+func setExclusion(name, reason string) bool {
+	exclusions.Lock()
+	defer exclusions.Unlock()
+	_, excluded := exclusions.reasons[name]
+	if reason == "" {
+		delete(exclusions.reasons, name)
+		return excluded
+	}
+	exclusions.reasons[name] = reason
+	return !excluded
+}
+
+// exclusionReasons returns a copy of the exclusion reasons by node name
+func exclusionReasons() map[string]string {
+	exclusions.Lock()
+	defer exclusions.Unlock()
+	reasons := map[string]string{}
+	for name, reason := range exclusions.reasons {
+		reasons[name] = reason
+	}
+	return reasons
+}
+
+// statusResponse is the JSON body of the /status endpoint
+type statusResponse struct {
+	Node           string                 `json:"node"`
+	MonitorOnly    bool                   `json:"monitor_only"`
+	Panic          bool                   `json:"panic"`
+	AutoRerouted   bool                   `json:"auto_rerouted"`
+	Rerouting      bool                   `json:"rerouting"`
+	Target         string                 `json:"target,omitempty"`
+	Since          *time.Time             `json:"since,omitempty"`
+	TargetHealth   *targetHealth          `json:"target_health,omitempty"`
+	Candidates     int                    `json:"candidates"`
+	Cycles         int64                  `json:"cycles"`
+	Healthy        float64                `json:"healthy_fraction"`
+	Simulated      []string               `json:"simulated_down,omitempty"`
+	Windows        map[string]windowState `json:"windows"`
+	Excluded       map[string]string      `json:"excluded,omitempty"`
+	Degraded       []string               `json:"degraded,omitempty"`
+	ProbeIntervals map[string]string      `json:"probe_intervals"`
+	DefaultTarget  string                 `json:"default_target,omitempty"`
+	LocalHealth    *localHealth           `json:"local_health,omitempty"`
+	RevertAt       *time.Time             `json:"revert_at,omitempty"`
+	Mirrored       *mirroredStatus        `json:"mirrored,omitempty"` // State mirrored from a primary when this is a standby
+
+	// Latency percentiles of each node's sample window, only included with ?detail=true
+	Percentiles map[string]latencyPercentiles `json:"percentiles,omitempty"`
+}
+
+// currentStatus returns a snapshot of the director's state
+func currentStatus(config *Config) statusResponse {
+	status := statusResponse{
+		Node:           localNodeName,
+		MonitorOnly:    config.MonitorOnly,
+		Candidates:     candidateNodes.Len(),
+		Cycles:         atomic.LoadInt64(&completedCycles),
+		Healthy:        healthyFraction(config),
+		Simulated:      simulatedDownNodes(),
+		Windows:        windowStates(),
+		Excluded:       exclusionReasons(),
+		ProbeIntervals: probeIntervals(),
+		LocalHealth:    currentLocalHealth(config),
+		Mirrored:       currentMirror(),
+	}
+	for name, node := range candidateNodes.Snapshot() {
+		if degraded(config, node) {
+			status.Degraded = append(status.Degraded, name)
+		}
+	}
+	sort.Strings(status.Degraded)
+	if _, name, _ := defaultTarget(config); name != "" {
+		status.DefaultTarget = name
+	}
+
+	reroute.Lock()
+	defer reroute.Unlock()
+	if reroute.Active {
+		since := reroute.Since
+		health := reroute.Health
+		status.Rerouting = true
+		status.Panic = reroute.Panic
+		status.AutoRerouted = reroute.Auto
+		status.Target = reroute.Target
+		status.Since = &since
+		status.TargetHealth = &health
+		if !reroute.RevertPendingSince.IsZero() {
+			revertAt := failbackAt(config, reroute.RevertPendingSince, time.Now())
+			status.RevertAt = &revertAt
+		}
+	}
+	return status
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sweepResult is what a probe sweep measured of one node
+type sweepResult struct {
+	Measurement  measurement
+	Measurement6 *measurement // Nil unless probe-ipv6 is set and an IPv6 source was ready
+	ProbeTime    time.Time
+}
+
+// sweep measures every remote node that is due for a probe, running up to probe-concurrency probes at once. Nodes that
+// aren't measured by the probe-sweep-timeout deadline, or whose source wasn't ready, are left out of the results as if
+// they weren't due, so a slow node can't hold back the rest of the cycle. Workers still probing at the deadline finish
+// in the background and the next sweep waits for them, so no more than probe-concurrency probes are ever in flight.
+func (p *probeSet) sweep(config *Config) map[string]sweepResult {
+	p.wait()
+
+	type job struct {
+		name string
+		node Node
+	}
+	type done struct {
+		name   string
+		result sweepResult
+	}
+	var jobs []job
+	for name, node := range config.Nodes {
+		// Skip the local node and back off probing of persistently down nodes
+		if node.ID == config.LocalID || !dueForProbe(name, time.Now()) {
+			continue
+		}
+		jobs = append(jobs, job{name, node})
+	}
+
+	queue := make(chan job, len(jobs))
+	for _, j := range jobs {
+		queue <- j
+	}
+	close(queue)
+	// Buffered for every job so workers finishing after the deadline don't block
+	results := make(chan done, len(jobs))
+	deadline := time.Now().Add(config.ProbeSweepTimeout)
+	workers := config.ProbeConcurrency
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	p.workers.Add(workers)
+	atomic.AddInt32(&p.leftover, int32(workers))
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer p.workers.Done()
+			defer atomic.AddInt32(&p.leftover, -1)
+			for j := range queue {
+				if time.Now().After(deadline) {
+					continue
+				}
+				results <- done{j.name, p.sweepNode(config, j.name, j.node)}
+			}
+		}()
+	}
+
+	measured := map[string]sweepResult{}
+	timeout := time.NewTimer(time.Until(deadline))
+	defer timeout.Stop()
+	for received := 0; received < len(jobs); received++ {
+		select {
+		case d := <-results:
+			if !d.result.Measurement.NotReady {
+				measured[d.name] = d.result
+			}
+		case <-timeout.C:
+			log.Warnf("Probe sweep deadline of %s reached with %d of %d nodes unmeasured", config.ProbeSweepTimeout, len(jobs)-received, len(jobs))
+			metricProbeSweepTimeouts.Inc()
+			return measured
+		}
+	}
+	return measured
+}
+
+// wait blocks until the workers of earlier sweeps have finished. It must be called from the probe loop, which is the
+// only goroutine that starts workers.
+func (p *probeSet) wait() {
+	if n := atomic.LoadInt32(&p.leftover); n > 0 {
+		log.Warnf("Waiting for %d probe workers left running by the previous sweep", n)
+	}
+	p.workers.Wait()
+}
+
+// sweepNode measures one node for a sweep. A panicking prober is recorded as a probe error for the node instead of
+// taking down the process, since the cycle watchdog only covers the probe loop itself.
+func (p *probeSet) sweepNode(config *Config, name string, node Node) (result sweepResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			metricProbePanics.Inc()
+			result.Measurement = measurement{Err: fmt.Errorf("probe panicked: %v", r)}
+		}
+	}()
+	log.Debugf("Pinging %s %+v", name, node)
+	result.ProbeTime = time.Now()
+	result.Measurement = p.measure(config, name, node, false)
+	if result.Measurement.NotReady {
+		return result
+	}
+	if result.Measurement.Err != nil {
+		log.Warnf("Error pinging %s: %s", name, result.Measurement.Err)
+	}
+	if config.ProbeIPv6 {
+		// Judge the node on IPv4 alone until the IPv6 source is usable
+		if m6 := p.measure(config, name, node, true); !m6.NotReady {
+			if m6.Err != nil {
+				log.Warnf("Error pinging %s over IPv6: %s", name, m6.Err)
+			}
+			result.Measurement6 = &m6
+		}
+	}
+	return result
+}
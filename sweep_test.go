@@ -0,0 +1,42 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestSweepUnreadySource(t *testing.T) {
+	if _, err := netlink.LinkList(); err != nil {
+		t.Skipf("source readiness can't be checked: %s", err)
+	}
+	for _, tt := range []struct {
+		action       string
+		wantMeasured int
+		wantProbes   int32
+	}{
+		// The test config's internal source IPs aren't assigned to any interface here
+		{"skip", 0, 0},
+		{"probe", 2, 2},
+	} {
+		t.Run(tt.action, func(t *testing.T) {
+			config := testConfig(t, "unready-source: "+tt.action+"\n")
+			var probes int32
+			prober := proberFunc(func(probeTarget) (probeResult, error) {
+				atomic.AddInt32(&probes, 1)
+				return probeResult{Latency: 20 * time.Millisecond}, nil
+			})
+			p := &probeSet{Primary: prober, Supervised: prober}
+
+			measured := p.sweep(config)
+			if len(measured) != tt.wantMeasured {
+				t.Errorf("%d nodes measured, want %d", len(measured), tt.wantMeasured)
+			}
+			if got := atomic.LoadInt32(&probes); got != tt.wantProbes {
+				t.Errorf("%d probes sent, want %d", got, tt.wantProbes)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// logTeardownPlan logs every fd- interface a teardown would delete and returns how many there are. state is the
+// persisted reroute state, if any, used to warn that a live reroute would be interrupted.
+func logTeardownPlan(state *persistedState) (int, error) {
+	links, err := fabricLinks()
+	if err != nil {
+		return 0, err
+	}
+	for _, link := range links {
+		if gre, ok := link.(*netlink.Gretun); ok {
+			log.Infof("Teardown will delete %s (%s -> %s)", link.Attrs().Name, gre.Local, gre.Remote)
+		} else if vxlan, ok := link.(*netlink.Vxlan); ok {
+			log.Infof("Teardown will delete %s (vxlan %s -> %s)", link.Attrs().Name, vxlan.SrcAddr, vxlan.Group)
+		} else {
+			log.Infof("Teardown will delete %s (%s)", link.Attrs().Name, link.Type())
+		}
+	}
+	if state != nil && state.Active {
+		log.Warnf("Traffic is rerouted to %s, tearing down will interrupt it", state.Target)
+	}
+	log.Infof("Teardown will delete %d interfaces", len(links))
+	return len(links), nil
+}
+
+// confirmTeardown asks for confirmation of a -d teardown on an interactive terminal unless -yes is set, then waits
+// out teardown-delay so an accidental teardown can still be cancelled with Ctrl-C
+func confirmTeardown(config *Config, count int) error {
+	if !*yes {
+		stat, err := os.Stdin.Stat()
+		if err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+			return fmt.Errorf("refusing to tear down without -yes when not running interactively")
+		}
+		fmt.Printf("Delete %d fabric interfaces? [y/N] ", count)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			return fmt.Errorf("teardown cancelled")
+		}
+	}
+	if config.TeardownDelay > 0 {
+		log.Warnf("Tearing down in %s, press Ctrl-C to cancel", config.TeardownDelay)
+		time.Sleep(config.TeardownDelay)
+	}
+	return nil
+}
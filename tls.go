@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// loadTLS validates the API TLS settings by loading the certificate and client CA
+func loadTLS(config *Config) error {
+	if config.TLSCert == "" && config.TLSKey == "" {
+		if config.TLSClientCA != "" {
+			return fmt.Errorf("tls-client-ca requires tls-cert and tls-key")
+		}
+		return nil
+	}
+	if config.TLSCert == "" || config.TLSKey == "" {
+		return fmt.Errorf("tls-cert and tls-key must be set together")
+	}
+	if _, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey); err != nil {
+		return fmt.Errorf("error loading tls-cert and tls-key: %s", err)
+	}
+	if config.TLSClientCA != "" {
+		if _, err := loadCertPool(config.TLSClientCA); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadCertPool reads a PEM file of CA certificates
+func loadCertPool(path string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA file %s: %s", path, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", path)
+	}
+	return pool, nil
+}
+
+// serverTLSConfig returns the TLS config of the API servers, or nil if TLS is disabled. With tls-client-ca set,
+// clients must present a certificate issued by it.
+func serverTLSConfig(config *Config) (*tls.Config, error) {
+	if config.TLSCert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("error loading tls-cert and tls-key: %s", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if config.TLSClientCA != "" {
+		pool, err := loadCertPool(config.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// setupPeerTLS makes the clients that call sibling directors' APIs present this director's certificate and trust
+// tls-client-ca, so directors that all require client certificates from the same CA can still reach each other
+func setupPeerTLS(config *Config) error {
+	if config.TLSClientCA == "" {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+	if err != nil {
+		return fmt.Errorf("error loading tls-cert and tls-key: %s", err)
+	}
+	pool, err := loadCertPool(config.TLSClientCA)
+	if err != nil {
+		return err
+	}
+	for _, client := range []*http.Client{matrixClient, opinionClient, standbyClient} {
+		client.Transport = &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				RootCAs:      pool,
+				MinVersion:   tls.VersionTLS12,
+			},
+		}
+	}
+	return nil
+}
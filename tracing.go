@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxSpanCandidates bounds the number of candidates whose metrics are attached to a reroute span
+const maxSpanCandidates = 16
+
+// tracer creates the reroute transition spans. It is a no-op unless otlp-endpoint is set.
+var tracer = trace.NewNoopTracerProvider().Tracer("fabric-director")
+
+// setupTracing exports reroute transition spans to the configured OTLP endpoint and returns a function flushing
+// pending spans on shutdown
+func setupTracing(config *Config) (func(context.Context) error, error) {
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(config.OTLPEndpoint)}
+	if config.OTLPInsecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating OTLP exporter: %s", err)
+	}
+
+	limits := sdktrace.NewSpanLimits()
+	limits.AttributeCountLimit = 4*maxSpanCandidates + 16
+	limits.EventCountLimit = 16
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter, sdktrace.WithMaxQueueSize(256)),
+		sdktrace.WithRawSpanLimits(limits),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "fabric-director"),
+			attribute.String("service.version", version),
+			attribute.String("fabric_director.node", localNodeName),
+		)),
+	)
+	tracer = provider.Tracer("fabric-director")
+	return provider.Shutdown, nil
+}
+
+// startTransitionSpan starts a span for a reroute transition, recording the candidates' metrics as its decision inputs
+func startTransitionSpan(config *Config, name, target, reason string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attribute.String("reroute.target", target),
+		attribute.String("reroute.previous", reroute.Target),
+		attribute.String("reroute.reason", reason),
+		attribute.Int("reroute.candidates", candidateNodes.Len()),
+	}
+	var n int
+	for candidate, node := range candidateNodes.Snapshot() {
+		if n == maxSpanCandidates {
+			break
+		}
+		n++
+		attrs = append(attrs,
+			attribute.Float64("candidate."+candidate+".latency_ms", float64(node.Latency)/float64(time.Millisecond)),
+			attribute.Float64("candidate."+candidate+".jitter_ms", float64(node.Jitter)/float64(time.Millisecond)),
+			attribute.Float64("candidate."+candidate+".loss", node.Loss),
+			attribute.Bool("candidate."+candidate+".auto_selectable", autoSelectable(config, node)),
+		)
+	}
+	return tracer.Start(context.Background(), name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err, if any, as the outcome of a span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceNetlink runs a netlink operation in a child span so its duration and outcome are recorded
+func traceNetlink(ctx context.Context, name string, op func() error) error {
+	_, span := tracer.Start(ctx, name)
+	err := op()
+	endSpan(span, err)
+	return err
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// fabricLinks returns the fd- tunnel interfaces managed by the director
+func fabricLinks() ([]netlink.Link, error) {
+	links, err := nl.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	var fabric []netlink.Link
+	for _, link := range links {
+		if strings.HasPrefix(link.Attrs().Name, "fd-") {
+			fabric = append(fabric, link)
+		}
+	}
+	return fabric, nil
+}
+
+var (
+	descTunnelRxBytes = prometheus.NewDesc(
+		"fabric_director_tunnel_rx_bytes_total", "Bytes received on the tunnel to a node", []string{"dst"}, nil)
+	descTunnelTxBytes = prometheus.NewDesc(
+		"fabric_director_tunnel_tx_bytes_total", "Bytes sent on the tunnel to a node", []string{"dst"}, nil)
+	descTunnelRxPackets = prometheus.NewDesc(
+		"fabric_director_tunnel_rx_packets_total", "Packets received on the tunnel to a node", []string{"dst"}, nil)
+	descTunnelTxPackets = prometheus.NewDesc(
+		"fabric_director_tunnel_tx_packets_total", "Packets sent on the tunnel to a node", []string{"dst"}, nil)
+	descTunnelInfo = prometheus.NewDesc(
+		"fabric_director_tunnel_info", "Endpoints and internal addresses of each tunnel as configured in the kernel",
+		[]string{"node", "local", "remote", "internal_v4", "internal_v6"}, nil)
+)
+
+// tunnelStatsCollector exports the kernel's traffic counters and the realized endpoints and addresses of each tunnel
+// at scrape time, so the series follow the interfaces that exist without separate cleanup
+type tunnelStatsCollector struct{}
+
+func (tunnelStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- descTunnelRxBytes
+	ch <- descTunnelTxBytes
+	ch <- descTunnelRxPackets
+	ch <- descTunnelTxPackets
+	ch <- descTunnelInfo
+}
+
+func (tunnelStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	links, err := fabricLinks()
+	if err != nil {
+		log.Warnf("Error listing tunnels for statistics: %s", err)
+		return
+	}
+	collectTunnelStats(ch, links)
+}
+
+// collectTunnelStats sends the info series and traffic counters of tunnel interfaces
+func collectTunnelStats(ch chan<- prometheus.Metric, links []netlink.Link) {
+	for _, link := range links {
+		name := strings.TrimPrefix(link.Attrs().Name, "fd-")
+		ch <- tunnelInfo(link, name)
+
+		stats := link.Attrs().Statistics
+		if stats == nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(descTunnelRxBytes, prometheus.CounterValue, float64(stats.RxBytes), name)
+		ch <- prometheus.MustNewConstMetric(descTunnelTxBytes, prometheus.CounterValue, float64(stats.TxBytes), name)
+		ch <- prometheus.MustNewConstMetric(descTunnelRxPackets, prometheus.CounterValue, float64(stats.RxPackets), name)
+		ch <- prometheus.MustNewConstMetric(descTunnelTxPackets, prometheus.CounterValue, float64(stats.TxPackets), name)
+	}
+}
+
+// tunnelInfo returns the info series of a tunnel with its endpoints and the internal addresses assigned to it
+func tunnelInfo(link netlink.Link, name string) prometheus.Metric {
+	var local, remote string
+	if gre, ok := link.(*netlink.Gretun); ok {
+		local, remote = gre.Local.String(), gre.Remote.String()
+	} else if vxlan, ok := link.(*netlink.Vxlan); ok {
+		local, remote = vxlan.SrcAddr.String(), vxlan.Group.String()
+	} else if link.Type() == "wireguard" {
+		remote = wireguardEndpoint(link.Attrs().Name)
+	}
+	var internal4, internal6 string
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		log.Debugf("Error listing addresses of %s: %s", link.Attrs().Name, err)
+	}
+	for _, addr := range addrs {
+		if addr.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if addr.IP.To4() != nil {
+			internal4 = addr.IPNet.String()
+		} else {
+			internal6 = addr.IPNet.String()
+		}
+	}
+	return prometheus.MustNewConstMetric(descTunnelInfo, prometheus.GaugeValue, 1, name, local, remote, internal4, internal6)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/vishvananda/netlink"
+)
+
+func TestCollectTunnelStats(t *testing.T) {
+	links := []netlink.Link{
+		&netlink.Gretun{
+			LinkAttrs: netlink.LinkAttrs{Name: "fd-fmt2", Statistics: &netlink.LinkStatistics{
+				RxBytes: 1500, TxBytes: 3000, RxPackets: 1, TxPackets: 2,
+			}},
+			Local:  net.ParseIP("192.0.2.10"),
+			Remote: net.ParseIP("192.0.2.20"),
+		},
+		// Without statistics only the info series is exported
+		&netlink.Gretun{LinkAttrs: netlink.LinkAttrs{Name: "fd-sea3"}},
+	}
+	ch := make(chan prometheus.Metric, 10)
+	collectTunnelStats(ch, links)
+	close(ch)
+
+	got := map[string]float64{}
+	infos := 0
+	for metric := range ch {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		desc := metric.Desc()
+		if desc == descTunnelInfo {
+			infos++
+			continue
+		}
+		if m.GetLabel()[0].GetValue() != "fmt2" {
+			t.Errorf("counter for %s, want only fmt2", m.GetLabel()[0].GetValue())
+		}
+		switch desc {
+		case descTunnelRxBytes:
+			got["rx_bytes"] = m.GetCounter().GetValue()
+		case descTunnelTxBytes:
+			got["tx_bytes"] = m.GetCounter().GetValue()
+		case descTunnelRxPackets:
+			got["rx_packets"] = m.GetCounter().GetValue()
+		case descTunnelTxPackets:
+			got["tx_packets"] = m.GetCounter().GetValue()
+		}
+	}
+	if infos != 2 {
+		t.Errorf("%d info series, want 2", infos)
+	}
+	want := map[string]float64{"rx_bytes": 1500, "tx_bytes": 3000, "rx_packets": 1, "tx_packets": 2}
+	for counter, value := range want {
+		if got[counter] != value {
+			t.Errorf("%s is %.0f, want %.0f", counter, got[counter], value)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// localNode returns the node entry of this director
+func localNode(config *Config) (Node, bool) {
+	for _, node := range config.Nodes {
+		if node.ID == config.LocalID {
+			return node, true
+		}
+	}
+	return Node{}, false
+}
+
+// underlayFamily returns the address family of the underlay between this node and another, which is ipv6 if either
+// end is IPv6 only and ipv4 otherwise
+func underlayFamily(config *Config, node Node) string {
+	local, _ := localNode(config)
+	if node.Underlay == "ipv6" || local.Underlay == "ipv6" {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// underlayAddr returns a node's underlay address on an address family. Nodes with an IPv4 ip use their ip6 on an IPv6
+// underlay.
+func underlayAddr(node Node, family string) string {
+	if family == "ipv6" && node.Underlay != "ipv6" {
+		return node.IP6
+	}
+	return nodeIP(node.IP)
+}
+
+// nodeUnderlayIP returns the underlay address this node reaches another node at, used for tunnel endpoints and probes
+// that bypass the tunnel
+func nodeUnderlayIP(config *Config, node Node) string {
+	return underlayAddr(node, underlayFamily(config, node))
+}
+
+// validateUnderlay checks every node's underlay and that each tunnel from this node has an address on both ends for its
+// underlay family
+func validateUnderlay(config *Config) error {
+	for name, node := range config.Nodes {
+		switch node.Underlay {
+		case "", "ipv4":
+		case "ipv6":
+			if ip := net.ParseIP(node.IP); ip == nil || ip.To4() != nil {
+				return fmt.Errorf("node %s has underlay ipv6 but ip %s is not an IPv6 address", name, node.IP)
+			}
+		default:
+			return fmt.Errorf("node %s has invalid underlay %s (must be ipv4 or ipv6)", name, node.Underlay)
+		}
+		if node.IP6 != "" {
+			host, _ := splitZone(node.IP6)
+			if ip := net.ParseIP(host); ip == nil || ip.To4() != nil {
+				return fmt.Errorf("node %s has invalid ip6 %s", name, node.IP6)
+			}
+		}
+	}
+
+	local, ok := localNode(config)
+	if !ok {
+		return nil // Reported when the plan is built
+	}
+	for name, node := range config.Nodes {
+		if node.ID == config.LocalID || underlayFamily(config, node) != "ipv6" {
+			continue
+		}
+		if underlayAddr(local, "ipv6") == "" {
+			return fmt.Errorf("local node needs an ip6 for its IPv6 underlay tunnel to %s", name)
+		}
+		if underlayAddr(node, "ipv6") == "" {
+			return fmt.Errorf("node %s needs an ip6 for its IPv6 underlay tunnel from the local node", name)
+		}
+	}
+	return nil
+}
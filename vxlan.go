@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// vxlanVNI returns the VNI of the tunnel between two nodes. Every fd- interface uses the same UDP port, so each pair
+// of nodes needs its own VNI, and both ends derive the same one from their IDs.
+func vxlanVNI(config *Config, a, b uint8) int {
+	if a > b {
+		a, b = b, a
+	}
+	return config.VxlanVNI + int(a)<<8 + int(b)
+}
+
+// addVXLAN adds a point-to-point VXLAN tunnel for a planned tunnel and returns the interface index
+func addVXLAN(config *Config, t tunnelPlan) (int, error) {
+	log.Debugf("Adding VXLAN tunnel %s VNI %d from %s to %s and adding %s and %s", t.Interface, t.VNI, t.Local, t.Remote, t.Internal4, t.Internal6)
+
+	localIP, localZone, err := parseZonedIP(t.Local)
+	if err != nil {
+		return -1, fmt.Errorf("error parsing local address for VXLAN tunnel %s: %s", t.Interface, err)
+	}
+	remoteIP, remoteZone, err := parseZonedIP(t.Remote)
+	if err != nil {
+		return -1, fmt.Errorf("error parsing remote address for VXLAN tunnel %s: %s", t.Interface, err)
+	}
+
+	la := netlink.NewLinkAttrs()
+	la.Name = t.Interface
+	la.MTU = config.TunnelMTU
+	// A unicast group makes the remote the default destination, so the interface is point-to-point without learning
+	vxlan := &netlink.Vxlan{
+		LinkAttrs: la,
+		VxlanId:   t.VNI,
+		SrcAddr:   localIP,
+		Group:     remoteIP,
+		Port:      t.RemotePort,
+		Learning:  false,
+	}
+	// Link-local endpoints must be bound to the interface they're scoped to
+	if remoteZone != 0 {
+		vxlan.VtepDevIndex = remoteZone
+	} else if localZone != 0 {
+		vxlan.VtepDevIndex = localZone
+	}
+	if err := netlink.LinkAdd(vxlan); err != nil {
+		return -1, fmt.Errorf("error adding VXLAN tunnel %s: %s", t.Interface, err)
+	}
+	return setupTunnelLink(vxlan, "VXLAN", t.Internal4, t.Internal6, config.AddressFamily == "dual")
+}
+
+// vxlanMatches returns an empty string if an existing link is a VXLAN interface with a planned tunnel's VNI, endpoints,
+// port and MTU, or a description of the first difference found
+func vxlanMatches(config *Config, link netlink.Link, t tunnelPlan) string {
+	vxlan, ok := link.(*netlink.Vxlan)
+	if !ok {
+		return fmt.Sprintf("type is %s, not vxlan", link.Type())
+	}
+	if vxlan.VxlanId != t.VNI {
+		return fmt.Sprintf("vni is %d, not %d", vxlan.VxlanId, t.VNI)
+	}
+	local, _, err := parseZonedIP(t.Local)
+	if err != nil || !vxlan.SrcAddr.Equal(local) {
+		return fmt.Sprintf("local is %s, not %s", vxlan.SrcAddr, t.Local)
+	}
+	remote, _, err := parseZonedIP(t.Remote)
+	if err != nil || !vxlan.Group.Equal(remote) {
+		return fmt.Sprintf("remote is %s, not %s", vxlan.Group, t.Remote)
+	}
+	if vxlan.Port != t.RemotePort {
+		return fmt.Sprintf("port is %d, not %d", vxlan.Port, t.RemotePort)
+	}
+	if vxlan.Attrs().MTU != config.TunnelMTU {
+		return fmt.Sprintf("mtu is %d, not %d", vxlan.Attrs().MTU, config.TunnelMTU)
+	}
+	return ""
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// probePanics is the number of consecutive probe cycles that panicked. It's only used by the probe loop.
+var probePanics int
+
+// runProbeCycle runs one probe cycle, recovering from a panic so the next tick starts a fresh cycle. With
+// probe-panic-exit set the director exits after that many consecutive panicking cycles, leaving the restart to its
+// supervisor.
+func runProbeCycle(config *Config, cycle func()) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		probePanics++
+		metricProbePanics.Inc()
+		log.WithField("stack", string(debug.Stack())).Errorf("Probe cycle panicked, restarting on the next cycle: %v", r)
+		events.Add("probe-panic", "", fmt.Sprint(r))
+		if config.ProbePanicExit > 0 && probePanics >= config.ProbePanicExit {
+			log.Fatalf("%d consecutive probe cycles panicked, exiting", probePanics)
+		}
+	}()
+	cycle()
+	probePanics = 0
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRunProbeCycle(t *testing.T) {
+	config := testConfig(t, "probe-panic-exit: 3\n")
+	t.Cleanup(func() { probePanics = 0 })
+	var exits []int
+	log.StandardLogger().ExitFunc = func(code int) { exits = append(exits, code) }
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		log.StandardLogger().ExitFunc = nil
+		log.SetOutput(os.Stderr)
+	})
+	panics := testutil.ToFloat64(metricProbePanics)
+	panicking := func() { panic("nil map") }
+
+	runProbeCycle(config, panicking)
+	runProbeCycle(config, panicking)
+	if probePanics != 2 {
+		t.Errorf("%d consecutive panics, want 2", probePanics)
+	}
+	// A cycle completing resets the count
+	ran := false
+	runProbeCycle(config, func() { ran = true })
+	if !ran || probePanics != 0 {
+		t.Errorf("cycle ran %t with %d consecutive panics after it, want 0", ran, probePanics)
+	}
+	if got := testutil.ToFloat64(metricProbePanics) - panics; got != 2 {
+		t.Errorf("counted %.0f panics, want 2", got)
+	}
+
+	for i := 0; i < 3; i++ {
+		runProbeCycle(config, panicking)
+	}
+	if len(exits) != 1 || exits[0] != 1 {
+		t.Errorf("exits %v after probe-panic-exit consecutive panics, want one with status 1", exits)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// webhookEvent is the payload POSTed to the configured webhook on reroute transitions
+type webhookEvent struct {
+	Event    string    `json:"event"`
+	Node     string    `json:"node"`
+	Target   string    `json:"target,omitempty"`
+	Previous string    `json:"previous,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+var webhookClient = &http.Client{Timeout: 5 * time.Second}
+
+// postWebhook POSTs an encoded event once, returning whether a failure is worth retrying
+func postWebhook(url string, body []byte) (bool, error) {
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return false, nil
+}
+
+// sendWebhook POSTs an event to the configured webhook URL in the background, retrying transient failures up to
+// WebhookRetries times with exponential backoff. Events that can't be delivered are logged with their payload so they
+// can be replayed.
+func sendWebhook(config *Config, event webhookEvent) {
+	if config.Webhook == "" {
+		return
+	}
+	event.Node = localNodeName
+	event.Time = time.Now()
+
+	url, retries, backoff := config.Webhook, config.WebhookRetries, config.WebhookRetryBackoff
+	go func() {
+		body, err := json.Marshal(event)
+		if err != nil {
+			log.Warnf("Error encoding webhook event: %s", err)
+			return
+		}
+		for attempt := 0; ; attempt++ {
+			retry, err := postWebhook(url, body)
+			if err == nil {
+				metricWebhookDelivery.WithLabelValues("success").Inc()
+				return
+			}
+			if !retry || attempt >= retries {
+				metricWebhookDelivery.WithLabelValues("failure").Inc()
+				log.WithField("payload", string(body)).Errorf("Dead letter: giving up on %s webhook after %d attempts: %s", event.Event, attempt+1, err)
+				return
+			}
+			metricWebhookDelivery.WithLabelValues("retry").Inc()
+			log.Warnf("Error sending %s webhook (attempt %d/%d), retrying in %s: %s", event.Event, attempt+1, retries+1, backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}()
+}
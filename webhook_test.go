@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSendWebhookRetries(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		statuses     []int // Status of each attempt, the last one repeats
+		retries      string
+		wantAttempts int32
+		wantOutcome  string
+	}{
+		{"delivered", []int{http.StatusOK}, "2", 1, "success"},
+		{"transient failures", []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}, "2", 3, "success"},
+		{"retries exhausted", []int{http.StatusBadGateway}, "1", 2, "failure"},
+		{"permanent failure", []int{http.StatusBadRequest}, "3", 1, "failure"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(atomic.AddInt32(&attempts, 1))
+				if n > len(tt.statuses) {
+					n = len(tt.statuses)
+				}
+				w.WriteHeader(tt.statuses[n-1])
+			}))
+			defer server.Close()
+			config := testConfig(t, "webhook: "+server.URL+"\nwebhook-retries: "+tt.retries+"\nwebhook-retry-backoff: 1ms\n")
+			outcome := metricWebhookDelivery.WithLabelValues(tt.wantOutcome)
+			before := testutil.ToFloat64(outcome)
+
+			sendWebhook(config, webhookEvent{Event: "reroute", Target: "fmt2"})
+
+			deadline := time.Now().Add(5 * time.Second)
+			for testutil.ToFloat64(outcome) == before {
+				if time.Now().After(deadline) {
+					t.Fatalf("no %s recorded after %d attempts", tt.wantOutcome, atomic.LoadInt32(&attempts))
+				}
+				time.Sleep(time.Millisecond)
+			}
+			if got := atomic.LoadInt32(&attempts); got != tt.wantAttempts {
+				t.Errorf("%d attempts, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
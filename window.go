@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+)
+
+// healthWindow is a ring of a node's most recent per-cycle health results
+type healthWindow struct {
+	results []bool
+	next    int
+	count   int
+}
+
+// Add records a cycle's result, replacing the oldest result once the window is full
+func (w *healthWindow) Add(pass bool) {
+	w.results[w.next] = pass
+	w.next = (w.next + 1) % len(w.results)
+	if w.count < len(w.results) {
+		w.count++
+	}
+}
+
+// Passes returns the number of passing cycles in the window
+func (w *healthWindow) Passes() int {
+	var passes int
+	for i := 0; i < w.count; i++ {
+		if w.results[i] {
+			passes++
+		}
+	}
+	return passes
+}
+
+// windowState is the JSON representation of a node's health window
+type windowState struct {
+	Passes  int `json:"passes"`
+	Samples int `json:"samples"`
+	Size    int `json:"size"`
+}
+
+// healthWindows holds the health window of each node by name
+var healthWindows = struct {
+	sync.Mutex
+	nodes map[string]*healthWindow
+}{nodes: map[string]*healthWindow{}}
+
+// recordWindow adds a cycle's result to a node's health window and returns true if the node passed thresholds in at
+// least CandidateWindowPass of the last CandidateWindow cycles
+func recordWindow(config *Config, name string, pass bool) bool {
+	healthWindows.Lock()
+	defer healthWindows.Unlock()
+	w, ok := healthWindows.nodes[name]
+	if !ok {
+		w = &healthWindow{results: make([]bool, config.CandidateWindow)}
+		healthWindows.nodes[name] = w
+	}
+	w.Add(pass)
+	return w.Passes() >= config.CandidateWindowPass
+}
+
+// windowStates returns the health window state of each node
+func windowStates() map[string]windowState {
+	healthWindows.Lock()
+	defer healthWindows.Unlock()
+	states := map[string]windowState{}
+	for name, w := range healthWindows.nodes {
+		states[name] = windowState{
+			Passes:  w.Passes(),
+			Samples: w.count,
+			Size:    len(w.results),
+		}
+	}
+	return states
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestRecordWindow(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		extra   string
+		results []bool
+		want    []bool // Candidacy after each result
+	}{
+		{"no window", "", []bool{true, false, true}, []bool{true, false, true}},
+		{"2 of 3", "candidate-window: 3\ncandidate-window-pass: 2\n",
+			[]bool{true, true, false, false, true, true},
+			[]bool{false, true, true, false, false, true}},
+		{"all of 3", "candidate-window: 3\n",
+			[]bool{true, true, true, false, true, true, true},
+			[]bool{false, false, true, false, false, false, true}},
+		{"1 of 3", "candidate-window: 3\ncandidate-window-pass: 1\n",
+			[]bool{false, true, false, false, false},
+			[]bool{false, true, true, true, false}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, tt.extra)
+			for i, pass := range tt.results {
+				if got := recordWindow(config, "fmt2", pass); got != tt.want[i] {
+					t.Errorf("cycle %d: candidate %t, want %t", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWindowStates(t *testing.T) {
+	config := testConfig(t, "candidate-window: 4\ncandidate-window-pass: 2\n")
+	for _, pass := range []bool{true, false, true, true, false, false} {
+		recordWindow(config, "fmt2", pass)
+	}
+	got := windowStates()["fmt2"]
+	if want := (windowState{Passes: 2, Samples: 4, Size: 4}); got != want {
+		t.Errorf("window %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// nodeTunnelType returns the tunnel type to a node, which is the global tunnel-type unless the node overrides it
+func nodeTunnelType(config *Config, node Node) string {
+	if node.TunnelType != "" {
+		return node.TunnelType
+	}
+	return config.TunnelType
+}
+
+// wireguardPort returns the port the interface towards the node with the given ID listens on. Each fd- interface is a
+// separate WireGuard device with a single peer, so each needs its own port.
+func wireguardPort(config *Config, id uint8) int {
+	return config.WireguardPort + int(id)
+}
+
+// wireguardPrivateKey returns the local node's private key from wireguard-private-key or wireguard-private-key-file
+func wireguardPrivateKey(config *Config) (wgtypes.Key, error) {
+	key := config.WireguardKey
+	if config.WireguardKeyFile != "" {
+		b, err := os.ReadFile(config.WireguardKeyFile)
+		if err != nil {
+			return wgtypes.Key{}, fmt.Errorf("error reading wireguard-private-key-file: %s", err)
+		}
+		key = strings.TrimSpace(string(b))
+	}
+	if key == "" {
+		return wgtypes.Key{}, fmt.Errorf("wireguard tunnels require wireguard-private-key or wireguard-private-key-file")
+	}
+	return wgtypes.ParseKey(key)
+}
+
+// printWireguardKey generates a WireGuard key pair and prints it for the config: the private key for the node's own
+// config and the public key for the nodes section of every director's config
+func printWireguardKey() error {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("wireguard-private-key: %s\n", key)
+	fmt.Printf("public-key: %s\n", key.PublicKey())
+	return nil
+}
+
+// wireguardConfig returns the device config of a planned WireGuard tunnel: one peer for the remote node that may send
+// and receive any address, since rerouted traffic to any destination is routed through the tunnel
+func wireguardConfig(config *Config, t tunnelPlan) (wgtypes.Config, error) {
+	privateKey, err := wireguardPrivateKey(config)
+	if err != nil {
+		return wgtypes.Config{}, err
+	}
+	publicKey, err := wgtypes.ParseKey(t.PublicKey)
+	if err != nil {
+		return wgtypes.Config{}, fmt.Errorf("invalid public-key of %s: %s", t.Node, err)
+	}
+	remote, zone, err := parseZonedIP(t.Remote)
+	if err != nil {
+		return wgtypes.Config{}, err
+	}
+	endpoint := &net.UDPAddr{IP: remote, Port: t.RemotePort}
+	if zone != 0 {
+		if iface, err := net.InterfaceByIndex(zone); err == nil {
+			endpoint.Zone = iface.Name
+		}
+	}
+	keepalive := 25 * time.Second
+	listenPort := t.ListenPort
+	return wgtypes.Config{
+		PrivateKey:   &privateKey,
+		ListenPort:   &listenPort,
+		ReplacePeers: true,
+		Peers: []wgtypes.PeerConfig{{
+			PublicKey:                   publicKey,
+			Endpoint:                    endpoint,
+			PersistentKeepaliveInterval: &keepalive,
+			ReplaceAllowedIPs:           true,
+			AllowedIPs: []net.IPNet{
+				{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
+				{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+			},
+		}},
+	}, nil
+}
+
+// addWireguard adds a WireGuard tunnel with a single peer for a planned tunnel and returns the interface index
+func addWireguard(config *Config, t tunnelPlan) (int, error) {
+	log.Debugf("Adding WireGuard tunnel %s to %s port %d and adding %s and %s", t.Interface, t.Remote, t.RemotePort, t.Internal4, t.Internal6)
+	device, err := wireguardConfig(config, t)
+	if err != nil {
+		return -1, fmt.Errorf("error configuring WireGuard tunnel %s: %s", t.Interface, err)
+	}
+
+	la := netlink.NewLinkAttrs()
+	la.Name = t.Interface
+	la.MTU = config.TunnelMTU
+	link := &netlink.GenericLink{LinkAttrs: la, LinkType: "wireguard"}
+	if err := netlink.LinkAdd(link); err != nil {
+		return -1, fmt.Errorf("error adding WireGuard tunnel %s: %s", t.Interface, err)
+	}
+
+	client, err := wgctrl.New()
+	if err != nil {
+		return -1, fmt.Errorf("error opening WireGuard control: %s", err)
+	}
+	defer client.Close()
+	if err := client.ConfigureDevice(t.Interface, device); err != nil {
+		return -1, fmt.Errorf("error configuring WireGuard tunnel %s: %s", t.Interface, err)
+	}
+	return setupTunnelLink(link, "WireGuard", t.Internal4, t.Internal6, config.AddressFamily == "dual")
+}
+
+// wireguardMatches returns an empty string if an existing link is a WireGuard device configured as planned, or a
+// description of the first difference found
+func wireguardMatches(config *Config, link netlink.Link, t tunnelPlan) string {
+	if link.Type() != "wireguard" {
+		return fmt.Sprintf("type is %s, not wireguard", link.Type())
+	}
+	want, err := wireguardConfig(config, t)
+	if err != nil {
+		return err.Error()
+	}
+	client, err := wgctrl.New()
+	if err != nil {
+		return fmt.Sprintf("error opening WireGuard control: %s", err)
+	}
+	defer client.Close()
+	device, err := client.Device(t.Interface)
+	if err != nil {
+		return fmt.Sprintf("error reading device: %s", err)
+	}
+	if device.PrivateKey != *want.PrivateKey {
+		return "private key differs"
+	}
+	if device.ListenPort != *want.ListenPort {
+		return fmt.Sprintf("listen port is %d, not %d", device.ListenPort, *want.ListenPort)
+	}
+	if len(device.Peers) != 1 || device.Peers[0].PublicKey != want.Peers[0].PublicKey {
+		return "peer differs"
+	}
+	if endpoint := device.Peers[0].Endpoint; endpoint == nil || !endpoint.IP.Equal(want.Peers[0].Endpoint.IP) ||
+		endpoint.Port != want.Peers[0].Endpoint.Port {
+		return fmt.Sprintf("endpoint is %s, not %s", endpoint, want.Peers[0].Endpoint)
+	}
+	if link.Attrs().MTU != config.TunnelMTU {
+		return fmt.Sprintf("mtu is %d, not %d", link.Attrs().MTU, config.TunnelMTU)
+	}
+	return ""
+}
+
+// wireguardEndpoint returns the endpoint of the peer of a WireGuard device, or an empty string if it can't be read
+func wireguardEndpoint(name string) string {
+	client, err := wgctrl.New()
+	if err != nil {
+		return ""
+	}
+	defer client.Close()
+	device, err := client.Device(name)
+	if err != nil || len(device.Peers) == 0 || device.Peers[0].Endpoint == nil {
+		return ""
+	}
+	return device.Peers[0].Endpoint.IP.String()
+}